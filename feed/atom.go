@@ -5,6 +5,9 @@ import (
 	"strings"
 )
 
+// AtomNamespace 是 Atom 1.0 的 XML 命名空间
+const AtomNamespace = "http://www.w3.org/2005/Atom"
+
 // AtomFeed represents an atom web feed.
 type AtomFeed struct {
 	// XMLName.
@@ -84,6 +87,27 @@ type AtomEntry struct {
 
 	// Information about rights, for example copyrights (optional).
 	Rights AtomText `xml:"rights,omitempty"`
+
+	// 聚合条目来源的订阅源（可选）
+	Source *AtomSource `xml:"source,omitempty"`
+}
+
+// AtomSource 保存条目来源订阅源的元数据
+type AtomSource struct {
+	ID      string     `xml:"id,omitempty"`
+	Title   AtomText   `xml:"title"`
+	Updated string     `xml:"updated,omitempty"`
+	Links   []AtomLink `xml:"link"`
+}
+
+// GetAuthor 返回条目第一个作者的名字
+func (entry *AtomEntry) GetAuthor() string {
+	for _, author := range entry.Authors {
+		if name := strings.TrimSpace(author.Name); name != "" {
+			return name
+		}
+	}
+	return ""
 }
 
 func (entry *AtomEntry) GetContent() (content string) {
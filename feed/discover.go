@@ -0,0 +1,76 @@
+package feed
+
+import (
+	"fmt"
+	"html"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+var (
+	linkTagPattern   = regexp.MustCompile(`(?is)<link\s[^>]*>`)
+	attributePattern = regexp.MustCompile(`(?is)([a-z_:-]+)\s*=\s*(?:"([^"]*)"|'([^']*)'|([^\s"'>]+))`)
+)
+
+// discoverTypes 是 ParseFeed 能解析的链接类型
+var discoverTypes = map[string]bool{
+	"application/rss+xml":  true,
+	"application/atom+xml": true,
+	"application/xml":      true,
+	"text/xml":             true,
+}
+
+// DiscoverLinks 按文档顺序返回 HTML 页面中 <link rel="alternate"> 声明的订阅源绝对地址
+func DiscoverLinks(page string, data []byte) (links []string) {
+	base, err := url.Parse(page)
+	if err != nil {
+		return nil
+	}
+	for _, tag := range linkTagPattern.FindAll(data, -1) {
+		attributes := tagAttributes(tag)
+		rel := strings.Fields(strings.ToLower(attributes["rel"]))
+		if !slices.Contains(rel, "alternate") || !discoverTypes[strings.ToLower(attributes["type"])] {
+			continue
+		}
+		href, err := base.Parse(strings.TrimSpace(attributes["href"]))
+		if err != nil || attributes["href"] == "" {
+			continue
+		}
+		links = append(links, href.String())
+	}
+	return
+}
+
+// tagAttributes 返回 HTML 开始标签中已反转义的属性，属性名为小写
+func tagAttributes(tag []byte) map[string]string {
+	attributes := map[string]string{}
+	for _, match := range attributePattern.FindAllSubmatch(tag, -1) {
+		value := string(match[2]) + string(match[3]) + string(match[4])
+		attributes[strings.ToLower(string(match[1]))] = html.UnescapeString(value)
+	}
+	return attributes
+}
+
+// DiscoverFeed 下载订阅源，如果 link 是网页则使用页面声明的第一个订阅源，
+// 并返回实际找到订阅源的地址
+func DiscoverFeed(link string, options *FetchOptions) (*Feed, string, error) {
+	data, err := fetch(link, options)
+	if err != nil {
+		return nil, "", err
+	}
+	if feed, err := ParseFeed(data); err == nil {
+		return feed, link, nil
+	}
+	candidates := DiscoverLinks(link, data)
+	for _, candidate := range candidates {
+		if feed, err := FetchFeedWith(candidate, options); err == nil {
+			return feed, candidate, nil
+		}
+	}
+	if len(candidates) > 0 {
+		return nil, "", fmt.Errorf("no valid feed among the %d linked from %s", len(candidates), link)
+	}
+	return nil, "", fmt.Errorf("no feed found at %s", link)
+}
@@ -24,6 +24,7 @@ type FeedItem struct {
 	Title       string
 	Link        string
 	Description string
+	Author      string
 	PubDate     time.Time
 }
 
@@ -111,6 +112,7 @@ func parseAsRSS(data []byte) (*Feed, error) {
 			Title:       cleanContent(item.Title),
 			Link:        item.Link,
 			Description: cleanContent(item.GetContent()),
+			Author:      item.GetAuthor(),
 			PubDate:     pubDate,
 		}
 		feed.Items = append(feed.Items, feedItem)
@@ -156,6 +158,7 @@ func parseAsAtom(data []byte) (*Feed, error) {
 			Title:       cleanContent(entry.Title.Data),
 			Link:        link,
 			Description: cleanContent(entry.GetContent()),
+			Author:      entry.GetAuthor(),
 			PubDate:     pubDate,
 		}
 		feed.Items = append(feed.Items, feedItem)
@@ -181,15 +184,64 @@ func findBestLink(links []AtomLink) string {
 	return links[0].Href
 }
 
+// FetchOptions 自定义抓取订阅源时的请求
+type FetchOptions struct {
+	UserAgent string            `json:"user_agent,omitempty"`
+	Headers   map[string]string `json:"headers,omitempty"`
+	Username  string            `json:"username,omitempty"`
+	Password  string            `json:"password,omitempty"`
+	// Timeout 超时秒数，0 表示默认的 30 秒，最多 MaxTimeout 秒
+	Timeout int `json:"timeout,omitempty"`
+}
+
+// MaxTimeout 是单次抓取允许的最长超时秒数
+const MaxTimeout = 120
+
 // FetchFeed 从给定URL下载订阅源并解析它
 func FetchFeed(url string) (*Feed, error) {
+	return FetchFeedWith(url, nil)
+}
+
+// FetchFeedWith 使用自定义请求选项下载并解析订阅源
+func FetchFeedWith(url string, options *FetchOptions) (*Feed, error) {
+	data, err := fetch(url, options)
+	if err != nil {
+		return nil, err
+	}
+	// 解析订阅源
+	return ParseFeed(data)
+}
+
+// fetch 使用自定义请求选项下载 URL 的内容
+func fetch(url string, options *FetchOptions) ([]byte, error) {
+	if options == nil {
+		options = &FetchOptions{}
+	}
 	// 创建带超时的HTTP客户端
+	timeout := 30 * time.Second
+	if options.Timeout > 0 {
+		timeout = time.Duration(min(options.Timeout, MaxTimeout)) * time.Second
+	}
 	client := &http.Client{
-		Timeout: 30 * time.Second,
+		Timeout: timeout,
+	}
+
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("invalid feed url: %w", err)
+	}
+	for name, value := range options.Headers {
+		req.Header.Set(name, value)
+	}
+	if options.UserAgent != "" {
+		req.Header.Set("User-Agent", options.UserAgent)
+	}
+	if options.Username != "" {
+		req.SetBasicAuth(options.Username, options.Password)
 	}
 
 	// 发送GET请求
-	resp, err := client.Get(url)
+	resp, err := client.Do(req)
 	if err != nil {
 		return nil, fmt.Errorf("failed to fetch feed: %w", err)
 	}
@@ -206,6 +258,5 @@ func FetchFeed(url string) (*Feed, error) {
 		return nil, fmt.Errorf("failed to read response: %w", err)
 	}
 
-	// 解析订阅源
-	return ParseFeed(data)
+	return data, nil
 }
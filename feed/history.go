@@ -0,0 +1,383 @@
+package feed
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"html"
+	"io"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ParseHistory 能读取的导出格式
+const (
+	// HistoryGoogleReader 是 Google Reader 的 stream JSON，
+	// Google Takeout、Inoreader 和 FreshRSS 都导出这种格式
+	HistoryGoogleReader = "google-reader"
+	HistoryFeedly       = "feedly"
+	HistoryMiniflux     = "miniflux"
+	HistoryPocket       = "pocket"
+	HistoryInstapaper   = "instapaper"
+)
+
+// HistoryItem 是从其他阅读器或稍后读服务导出的文章及其阅读状态
+type HistoryItem struct {
+	// ID 是条目在订阅源中的 id，导出文件保留时才有
+	ID        string
+	Title     string
+	Link      string
+	Content   string
+	Author    string
+	Published time.Time
+	// 稍后读服务保存的是网页而不是订阅条目，Feed 为 nil
+	Feed     *HistoryFeed
+	Category string
+	Read     bool
+	Saved    bool
+}
+
+// HistoryFeed 是 HistoryItem 所在的订阅源
+type HistoryFeed struct {
+	Title string
+	Link  string
+	Home  string
+}
+
+// History 是导出文件的内容
+type History struct {
+	Format string
+	Items  []*HistoryItem
+}
+
+// ParseHistory 读取加星或已读条目的导出文件并自动检测格式。
+// zip 压缩包（如 Google Takeout 或 FreshRSS 的导出）会逐个文件读取，无法解析的文件将被忽略
+func ParseHistory(data []byte) (*History, error) {
+	if !bytes.HasPrefix(data, []byte("PK\x03\x04")) {
+		return parseHistoryFile(data)
+	}
+	archive, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, err
+	}
+	var history *History
+	for _, file := range archive.File {
+		switch strings.ToLower(path.Ext(file.Name)) {
+		case ".json", ".csv", ".html", ".htm":
+		default:
+			continue
+		}
+		content, err := readZipFile(file)
+		if err != nil {
+			return nil, err
+		}
+		part, err := parseHistoryFile(content)
+		if err != nil || part == nil {
+			continue
+		}
+		if history == nil {
+			history = part
+		} else {
+			history.Items = append(history.Items, part.Items...)
+		}
+	}
+	if history == nil {
+		return nil, errors.New("no export of starred or read items found in the archive")
+	}
+	return history, nil
+}
+
+func readZipFile(file *zip.File) ([]byte, error) {
+	f, err := file.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return io.ReadAll(f)
+}
+
+func parseHistoryFile(data []byte) (*History, error) {
+	data = bytes.TrimPrefix(data, []byte{0xEF, 0xBB, 0xBF})
+	trimmed := bytes.TrimSpace(data)
+	switch {
+	case len(trimmed) == 0:
+		return nil, errors.New("empty export")
+	case trimmed[0] == '{' || trimmed[0] == '[':
+		return parseHistoryJSON(trimmed)
+	case trimmed[0] == '<':
+		return parseHistoryHTML(trimmed)
+	default:
+		return parseHistoryCSV(trimmed)
+	}
+}
+
+// streamItem 是 Google Reader 或 Feedly stream 中的条目，
+// Feedly 额外提供 originId、带标签的分类、tags 和 unread
+type streamItem struct {
+	ID           string `json:"id"`
+	OriginID     string `json:"originId"`
+	Title        string `json:"title"`
+	Published    int64  `json:"published"`
+	Author       string `json:"author"`
+	CanonicalURL string `json:"canonicalUrl"`
+	Canonical    []struct {
+		Href string `json:"href"`
+	} `json:"canonical"`
+	Alternate []struct {
+		Href string `json:"href"`
+		Type string `json:"type"`
+	} `json:"alternate"`
+	Content *struct {
+		Content string `json:"content"`
+	} `json:"content"`
+	Summary *struct {
+		Content string `json:"content"`
+	} `json:"summary"`
+	// Categories 在 Google Reader 中是 stream id，在 Feedly 中是带标签的 id
+	Categories []json.RawMessage `json:"categories"`
+	Tags       []streamLabel     `json:"tags"`
+	Unread     *bool             `json:"unread"`
+	Origin     struct {
+		StreamID string `json:"streamId"`
+		Title    string `json:"title"`
+		HTMLURL  string `json:"htmlUrl"`
+	} `json:"origin"`
+}
+
+type streamLabel struct {
+	ID    string `json:"id"`
+	Label string `json:"label"`
+}
+
+// minifluxEntry 是从 Miniflux API /v1/entries 保存的条目
+type minifluxEntry struct {
+	Title       string    `json:"title"`
+	URL         string    `json:"url"`
+	PublishedAt time.Time `json:"published_at"`
+	Content     string    `json:"content"`
+	Author      string    `json:"author"`
+	Status      string    `json:"status"`
+	Starred     bool      `json:"starred"`
+	Feed        struct {
+		Title    string `json:"title"`
+		SiteURL  string `json:"site_url"`
+		FeedURL  string `json:"feed_url"`
+		Category struct {
+			Title string `json:"title"`
+		} `json:"category"`
+	} `json:"feed"`
+}
+
+func parseHistoryJSON(data []byte) (*History, error) {
+	var export struct {
+		ID      string            `json:"id"`
+		Items   []json.RawMessage `json:"items"`
+		Entries []minifluxEntry   `json:"entries"`
+	}
+	if data[0] == '[' {
+		// Feedly 导出的是条目数组
+		if err := json.Unmarshal(data, &export.Items); err != nil {
+			return nil, err
+		}
+	} else if err := json.Unmarshal(data, &export); err != nil {
+		return nil, err
+	}
+	if export.Entries != nil {
+		return parseMiniflux(export.Entries), nil
+	}
+	if export.Items == nil {
+		return nil, errors.New("unknown JSON export, expected items or entries")
+	}
+	// Google Reader 的 shared-by-followers stream 里是别人的条目
+	if strings.HasSuffix(export.ID, "/state/com.google/broadcast-friends") {
+		return nil, nil
+	}
+	history := &History{Format: HistoryGoogleReader}
+	starred := strings.HasSuffix(export.ID, "/state/com.google/starred")
+	for _, raw := range export.Items {
+		var stream streamItem
+		if err := json.Unmarshal(raw, &stream); err != nil {
+			return nil, err
+		}
+		item := stream.historyItem()
+		if stream.OriginID != "" || stream.Tags != nil || stream.Unread != nil {
+			history.Format = HistoryFeedly
+		}
+		item.Saved = item.Saved || starred
+		history.Items = append(history.Items, item)
+	}
+	return history, nil
+}
+
+func (stream *streamItem) historyItem() *HistoryItem {
+	item := &HistoryItem{
+		ID:     stream.OriginID,
+		Title:  stream.Title,
+		Author: stream.Author,
+	}
+	switch {
+	case len(stream.Canonical) > 0:
+		item.Link = stream.Canonical[0].Href
+	case stream.CanonicalURL != "":
+		item.Link = stream.CanonicalURL
+	}
+	for _, alternate := range stream.Alternate {
+		if item.Link == "" && (alternate.Type == "" || alternate.Type == "text/html") {
+			item.Link = alternate.Href
+		}
+	}
+	if stream.Content != nil {
+		item.Content = stream.Content.Content
+	} else if stream.Summary != nil {
+		item.Content = stream.Summary.Content
+	}
+	// Google Reader 以秒计时，Feedly 以毫秒计时
+	if published := stream.Published; published > 1e11 {
+		item.Published = time.UnixMilli(published).UTC()
+	} else if published > 0 {
+		item.Published = time.Unix(published, 0).UTC()
+	}
+	if link, ok := strings.CutPrefix(stream.Origin.StreamID, "feed/"); ok {
+		item.Feed = &HistoryFeed{Title: stream.Origin.Title, Link: link, Home: stream.Origin.HTMLURL}
+	}
+	for _, raw := range stream.Categories {
+		var category streamLabel
+		if json.Unmarshal(raw, &category.ID) != nil && json.Unmarshal(raw, &category) != nil {
+			continue
+		}
+		switch {
+		case strings.HasSuffix(category.ID, "/state/com.google/starred"):
+			item.Saved = true
+		case strings.HasSuffix(category.ID, "/state/com.google/read"):
+			item.Read = true
+		case item.Category != "":
+		case category.Label != "":
+			item.Category = category.Label
+		case strings.Contains(category.ID, "/label/"):
+			item.Category = category.ID[strings.Index(category.ID, "/label/")+len("/label/"):]
+		}
+	}
+	for _, tag := range stream.Tags {
+		if strings.HasSuffix(tag.ID, "/tag/global.saved") {
+			item.Saved = true
+		}
+	}
+	if stream.Unread != nil {
+		item.Read = !*stream.Unread
+	}
+	return item
+}
+
+func parseMiniflux(entries []minifluxEntry) *History {
+	history := &History{Format: HistoryMiniflux}
+	for _, entry := range entries {
+		item := &HistoryItem{
+			Title:     entry.Title,
+			Link:      entry.URL,
+			Content:   entry.Content,
+			Author:    entry.Author,
+			Published: entry.PublishedAt,
+			Category:  entry.Feed.Category.Title,
+			Read:      entry.Status == "read",
+			Saved:     entry.Starred,
+		}
+		if entry.Feed.FeedURL != "" {
+			item.Feed = &HistoryFeed{Title: entry.Feed.Title, Link: entry.Feed.FeedURL, Home: entry.Feed.SiteURL}
+		}
+		history.Items = append(history.Items, item)
+	}
+	return history
+}
+
+var (
+	sectionPattern = regexp.MustCompile(`(?is)<h1[^>]*>(.*?)</h1>`)
+	anchorPattern  = regexp.MustCompile(`(?is)(<a\s[^>]*>)(.*?)</a>`)
+)
+
+// parseHistoryHTML 读取 Pocket 和 Instapaper 导出的 HTML，每个文件夹一个标题，下面列出链接
+func parseHistoryHTML(data []byte) (*History, error) {
+	history := &History{Format: HistoryInstapaper}
+	if bytes.Contains(data, []byte("time_added")) {
+		history.Format = HistoryPocket
+	}
+	sections := sectionPattern.FindAllSubmatchIndex(data, -1)
+	for i, section := range sections {
+		end := len(data)
+		if i+1 < len(sections) {
+			end = sections[i+1][0]
+		}
+		folder := strings.TrimSpace(html.UnescapeString(string(data[section[2]:section[3]])))
+		for _, anchor := range anchorPattern.FindAllSubmatch(data[section[1]:end], -1) {
+			attributes := tagAttributes(anchor[1])
+			item := &HistoryItem{
+				Title: strings.TrimSpace(html.UnescapeString(string(anchor[2]))),
+				Link:  attributes["href"],
+				Saved: true,
+			}
+			if seconds, err := strconv.ParseInt(attributes["time_added"], 10, 64); err == nil {
+				item.Published = time.Unix(seconds, 0).UTC()
+			}
+			item.Read = isArchive(folder)
+			history.Items = append(history.Items, item)
+		}
+	}
+	if len(history.Items) == 0 {
+		return nil, errors.New("unknown export, expected a Pocket or Instapaper HTML export")
+	}
+	return history, nil
+}
+
+// isArchive 判断 Pocket 或 Instapaper 的文件夹是否存放已读条目
+func isArchive(folder string) bool {
+	folder = strings.ToLower(folder)
+	return folder == "archive" || folder == "read archive"
+}
+
+// parseHistoryCSV 读取 Pocket（title, url, time_added, tags, status）
+// 和 Instapaper（URL, Title, Selection, Folder, Timestamp）导出的 CSV
+func parseHistoryCSV(data []byte) (*History, error) {
+	records, err := csv.NewReader(bytes.NewReader(data)).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, errors.New("empty CSV export")
+	}
+	columns := map[string]int{}
+	for i, name := range records[0] {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	field := func(record []string, name string) string {
+		if i, ok := columns[name]; ok && i < len(record) {
+			return record[i]
+		}
+		return ""
+	}
+	history := &History{Format: HistoryPocket}
+	timestamp, folder := "time_added", "status"
+	if _, ok := columns["folder"]; ok {
+		history.Format = HistoryInstapaper
+		timestamp, folder = "timestamp", "folder"
+	} else if _, ok := columns["time_added"]; !ok {
+		return nil, errors.New("unknown CSV export, expected a Pocket or Instapaper export")
+	}
+	for _, record := range records[1:] {
+		item := &HistoryItem{
+			Title:   field(record, "title"),
+			Link:    field(record, "url"),
+			Content: html.EscapeString(field(record, "selection")),
+			Read:    isArchive(field(record, folder)),
+			Saved:   true,
+		}
+		if seconds, err := strconv.ParseInt(field(record, timestamp), 10, 64); err == nil {
+			item.Published = time.Unix(seconds, 0).UTC()
+		}
+		history.Items = append(history.Items, item)
+	}
+	return history, nil
+}
@@ -0,0 +1,149 @@
+package feed
+
+import (
+	"archive/zip"
+	"bytes"
+	"testing"
+	"time"
+)
+
+const googleStarred = `{
+  "id": "user/0123/state/com.google/starred",
+  "items": [{
+    "id": "tag:google.com,2005:reader/item/1",
+    "categories": ["user/0123/state/com.google/read", "user/0123/label/Tech"],
+    "title": "Hello",
+    "published": 1262304000,
+    "canonical": [{"href": "https://blog.example.org/hello"}],
+    "content": {"content": "<p>Hi</p>"},
+    "author": "Ada",
+    "origin": {"streamId": "feed/https://blog.example.org/feed.xml", "title": "Example Blog", "htmlUrl": "https://blog.example.org/"}
+  }]
+}`
+
+const feedlySaved = `[{
+  "id": "abc",
+  "originId": "https://news.example.com/?p=7",
+  "title": "Saved story",
+  "published": 1700000000000,
+  "alternate": [{"href": "https://news.example.com/7", "type": "text/html"}],
+  "summary": {"content": "summary"},
+  "categories": [{"id": "user/u/category/News", "label": "News"}],
+  "tags": [{"id": "user/u/tag/global.saved", "label": "Saved For Later"}],
+  "unread": true,
+  "origin": {"streamId": "feed/https://news.example.com/rss", "title": "News"}
+}]`
+
+const minifluxEntries = `{"total": 1, "entries": [{
+  "title": "Entry", "url": "https://m.example.com/1", "published_at": "2023-05-01T10:00:00Z",
+  "content": "body", "status": "read", "starred": true,
+  "feed": {"title": "M", "site_url": "https://m.example.com/", "feed_url": "https://m.example.com/feed", "category": {"title": "Misc"}}
+}]}`
+
+const pocketHTML = `<!DOCTYPE html><html><head><title>Pocket Export</title></head><body>
+<h1>Unread</h1>
+<ul><li><a href="https://a.example.com/" time_added="1600000000" tags="go">A &amp; B</a></li></ul>
+<h1>Read Archive</h1>
+<ul><li><a href="https://b.example.com/" time_added="1500000000" tags="">B</a></li></ul>
+</body></html>`
+
+const instapaperCSV = `URL,Title,Selection,Folder,Timestamp
+https://c.example.com/,C,a <quote>,Unread,1650000000
+https://d.example.com/,D,,Archive,1640000000
+`
+
+func TestParseHistory(t *testing.T) {
+	for name, test := range map[string]struct {
+		data   string
+		format string
+		want   HistoryItem
+	}{
+		"google reader": {googleStarred, HistoryGoogleReader, HistoryItem{
+			Title: "Hello", Link: "https://blog.example.org/hello", Content: "<p>Hi</p>", Author: "Ada",
+			Published: time.Unix(1262304000, 0).UTC(), Category: "Tech", Read: true, Saved: true,
+			Feed: &HistoryFeed{Title: "Example Blog", Link: "https://blog.example.org/feed.xml", Home: "https://blog.example.org/"},
+		}},
+		"feedly": {feedlySaved, HistoryFeedly, HistoryItem{
+			ID: "https://news.example.com/?p=7", Title: "Saved story", Link: "https://news.example.com/7", Content: "summary",
+			Published: time.UnixMilli(1700000000000).UTC(), Category: "News", Saved: true,
+			Feed: &HistoryFeed{Title: "News", Link: "https://news.example.com/rss"},
+		}},
+		"miniflux": {minifluxEntries, HistoryMiniflux, HistoryItem{
+			Title: "Entry", Link: "https://m.example.com/1", Content: "body",
+			Published: time.Date(2023, 5, 1, 10, 0, 0, 0, time.UTC), Category: "Misc", Read: true, Saved: true,
+			Feed: &HistoryFeed{Title: "M", Link: "https://m.example.com/feed", Home: "https://m.example.com/"},
+		}},
+		"pocket": {pocketHTML, HistoryPocket, HistoryItem{
+			Title: "A & B", Link: "https://a.example.com/", Published: time.Unix(1600000000, 0).UTC(), Saved: true,
+		}},
+		"instapaper": {instapaperCSV, HistoryInstapaper, HistoryItem{
+			Title: "C", Link: "https://c.example.com/", Content: "a &lt;quote&gt;", Published: time.Unix(1650000000, 0).UTC(), Saved: true,
+		}},
+	} {
+		history, err := ParseHistory([]byte(test.data))
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		if history.Format != test.format {
+			t.Errorf("%s: format %q, want %q", name, history.Format, test.format)
+		}
+		if len(history.Items) == 0 {
+			t.Errorf("%s: no items", name)
+			continue
+		}
+		got := *history.Items[0]
+		if got.Feed != nil && test.want.Feed != nil && *got.Feed == *test.want.Feed {
+			got.Feed = test.want.Feed
+		}
+		if got != test.want {
+			t.Errorf("%s: got %+v, want %+v", name, got, test.want)
+		}
+	}
+}
+
+func TestParseHistoryArchived(t *testing.T) {
+	for name, data := range map[string]string{"pocket": pocketHTML, "instapaper": instapaperCSV} {
+		history, err := ParseHistory([]byte(data))
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if len(history.Items) != 2 || history.Items[0].Read || !history.Items[1].Read {
+			t.Errorf("%s: want an unread then an archived item, got %+v", name, history.Items)
+		}
+	}
+}
+
+func TestParseHistoryZip(t *testing.T) {
+	var buf bytes.Buffer
+	archive := zip.NewWriter(&buf)
+	for name, content := range map[string]string{
+		"Takeout/Reader/starred.json":   googleStarred,
+		"Takeout/Reader/followers.json": `{"followers": []}`,
+		"Takeout/Reader/shared-by-followers.json": `{"id": "user/0123/state/com.google/broadcast-friends",
+			"items": [{"title": "Not mine", "canonical": [{"href": "https://x.example.com/"}]}]}`,
+		"Takeout/Reader/subscriptions.xml": "<opml/>",
+	} {
+		f, err := archive.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.Write([]byte(content))
+	}
+	archive.Close()
+	history, err := ParseHistory(buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history.Items) != 1 || history.Items[0].Title != "Hello" {
+		t.Errorf("want only the starred item, got %+v", history.Items)
+	}
+}
+
+func TestParseHistoryUnknown(t *testing.T) {
+	for _, data := range []string{"", `{"followers": []}`, "a,b\n1,2\n", "<html></html>"} {
+		if _, err := ParseHistory([]byte(data)); err == nil {
+			t.Errorf("ParseHistory(%q) did not fail", data)
+		}
+	}
+}
@@ -0,0 +1,44 @@
+package feed
+
+// JSONFeedVersion 是 JSONFeed 输出的 JSON Feed 版本
+const JSONFeedVersion = "https://jsonfeed.org/version/1.1"
+
+// JSONFeed 代表一个 JSON Feed 文档
+type JSONFeed struct {
+	Version     string           `json:"version"`
+	Title       string           `json:"title"`
+	HomePageURL string           `json:"home_page_url,omitempty"`
+	FeedURL     string           `json:"feed_url,omitempty"`
+	Description string           `json:"description,omitempty"`
+	Language    string           `json:"language,omitempty"`
+	Authors     []JSONFeedAuthor `json:"authors,omitempty"`
+	Items       []JSONFeedItem   `json:"items"`
+}
+
+// JSONFeedAuthor 是订阅源或条目的作者
+type JSONFeedAuthor struct {
+	Name string `json:"name,omitempty"`
+	URL  string `json:"url,omitempty"`
+}
+
+// JSONFeedItem 是 JSON Feed 中的一个条目，Source 是扩展字段 "_source"，
+// 表示聚合条目来源的订阅源
+type JSONFeedItem struct {
+	ID            string           `json:"id"`
+	URL           string           `json:"url,omitempty"`
+	ExternalURL   string           `json:"external_url,omitempty"`
+	Title         string           `json:"title,omitempty"`
+	ContentHTML   string           `json:"content_html"`
+	DatePublished string           `json:"date_published,omitempty"`
+	DateModified  string           `json:"date_modified,omitempty"`
+	Authors       []JSONFeedAuthor `json:"authors,omitempty"`
+	Tags          []string         `json:"tags,omitempty"`
+	Source        *JSONFeedSource  `json:"_source,omitempty"`
+}
+
+// JSONFeedSource 描述条目来源的订阅源
+type JSONFeedSource struct {
+	Title       string `json:"title"`
+	HomePageURL string `json:"home_page_url,omitempty"`
+	FeedURL     string `json:"feed_url,omitempty"`
+}
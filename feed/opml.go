@@ -2,25 +2,67 @@ package feed
 
 import (
 	"encoding/xml"
-	"time"
+	"io"
+	"strings"
 )
 
+// Outline 是一个订阅源，没有 XMLURL 时是包含其他 outline 的文件夹
 type Outline struct {
-	Type    string `xml:"type,attr"`
-	Title   string `xml:"title,attr"`
-	Text    string `xml:"text,attr"`
-	XMLURL  string `xml:"xmlUrl,attr"`
-	HTMLURL string `xml:"htmlUrl,attr"`
+	Type        string `xml:"type,attr,omitempty"`
+	Title       string `xml:"title,attr,omitempty"`
+	Text        string `xml:"text,attr"`
+	XMLURL      string `xml:"xmlUrl,attr,omitempty"`
+	HTMLURL     string `xml:"htmlUrl,attr,omitempty"`
+	Description string `xml:"description,attr,omitempty"`
+	Language    string `xml:"language,attr,omitempty"`
+	// Category 是逗号分隔的分类列表，每个分类用斜杠分隔层级
+	Category string `xml:"category,attr,omitempty"`
+	// Attrs 保留其他所有属性
+	Attrs    []xml.Attr `xml:",any,attr"`
+	Outlines []Outline  `xml:"outline"`
+}
+
+// Name 返回 outline 的 text，没有时使用 title
+func (outline *Outline) Name() string {
+	if outline.Text != "" {
+		return outline.Text
+	}
+	return outline.Title
+}
+
+// Categories 将 category 属性拆分为各个分类路径，并去掉开头的斜杠
+func (outline *Outline) Categories() (categories []string) {
+	for _, category := range strings.Split(outline.Category, ",") {
+		if category = strings.Trim(strings.TrimSpace(category), "/"); category != "" {
+			categories = append(categories, category)
+		}
+	}
+	return
 }
 
 type OPML struct {
-	XMLName   xml.Name  `xml:"opml"`
-	Title     string    `xml:"head>title"`
-	Outlines  []Outline `xml:"body>outline"`
-	CreatedAt time.Time `xml:"dateCreated"`
+	XMLName     xml.Name  `xml:"opml"`
+	Version     string    `xml:"version,attr,omitempty"`
+	Title       string    `xml:"head>title"`
+	DateCreated string    `xml:"head>dateCreated,omitempty"`
+	Outlines    []Outline `xml:"body>outline"`
 }
 
 func ParseOPML(data []byte) (opml *OPML, err error) {
 	err = xml.Unmarshal(data, &opml)
 	return
 }
+
+// FetchOPML 下载并解析 OPML 文档
+func FetchOPML(url string, options *FetchOptions) (*OPML, error) {
+	data, err := fetch(url, options)
+	if err != nil {
+		return nil, err
+	}
+	return ParseOPML(data)
+}
+
+// WriteOPML 输出带缩进的 OPML 文档
+func WriteOPML(w io.Writer, opml *OPML) error {
+	return writeXML(w, opml)
+}
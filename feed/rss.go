@@ -9,7 +9,13 @@ import (
 
 type RssGuid struct {
 	Value       string `xml:",chardata"`
-	IsPermaLink bool   `xml:"isPermaLink,attr,omitempty"`
+	IsPermaLink string `xml:"isPermaLink,attr,omitempty"`
+}
+
+// RssSource 表示聚合条目来源的频道
+type RssSource struct {
+	URL   string `xml:"url,attr"`
+	Title string `xml:",chardata"`
 }
 
 type RssItem struct {
@@ -19,6 +25,10 @@ type RssItem struct {
 	Description    string  `xml:"description"`
 	PubDate        string  `xml:"pubDate"`
 	ContentEncoded string  `xml:"encoded,omitempty"`
+	// Author 是邮箱地址，Creator 是大多数订阅源使用的 Dublin Core 名字
+	Author  string     `xml:"author,omitempty"`
+	Creator string     `xml:"http://purl.org/dc/elements/1.1/ creator,omitempty"`
+	Source  *RssSource `xml:"source,omitempty"`
 }
 
 func (item *RssItem) ID() string {
@@ -47,6 +57,18 @@ func cleanContent(content string) string {
 	return content
 }
 
+func (item *RssItem) GetAuthor() string {
+	if item.Creator != "" {
+		return strings.TrimSpace(item.Creator)
+	}
+	// <author> 是邮箱地址，后面通常在括号里写名字
+	author := strings.TrimSpace(item.Author)
+	if start := strings.Index(author, "("); start > 0 && strings.HasSuffix(author, ")") {
+		return strings.TrimSpace(author[start+1 : len(author)-1])
+	}
+	return author
+}
+
 func (item *RssItem) GetContent() string {
 	if item.ContentEncoded != "" {
 		return cleanContent(item.ContentEncoded)
@@ -55,11 +77,14 @@ func (item *RssItem) GetContent() string {
 }
 
 type RssFeed struct {
-	XMLName     xml.Name  `xml:"rss"`
-	Title       string    `xml:"channel>title"`
-	Description string    `xml:"channel>description"`
-	Link        string    `xml:"channel>link"`
-	Items       []RssItem `xml:"channel>item"`
+	XMLName       xml.Name  `xml:"rss"`
+	Version       string    `xml:"version,attr,omitempty"`
+	Title         string    `xml:"channel>title"`
+	Description   string    `xml:"channel>description"`
+	Link          string    `xml:"channel>link"`
+	LastBuildDate string    `xml:"channel>lastBuildDate,omitempty"`
+	Generator     string    `xml:"channel>generator,omitempty"`
+	Items         []RssItem `xml:"channel>item"`
 }
 
 func ParseRss(data []byte) (feed *RssFeed, err error) {
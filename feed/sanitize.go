@@ -0,0 +1,124 @@
+package feed
+
+import (
+	"html"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// htmlTokenPattern 匹配注释、声明、处理指令以及开始和结束标签
+var htmlTokenPattern = regexp.MustCompile(`(?s)<!--.*?(?:-->|$)|<![^>]*>|<\?[^>]*>|</?([a-zA-Z][a-zA-Z0-9]*)(?:[^>"']|"[^"]*"|'[^']*')*>`)
+
+// allowedElements 是保留的元素及其允许的属性
+var allowedElements = map[string][]string{
+	"a": {"href", "title"}, "img": {"src", "alt", "title", "width", "height"},
+	"p": nil, "br": nil, "hr": nil, "div": nil, "span": nil,
+	"b": nil, "strong": nil, "i": nil, "em": nil, "u": nil, "s": nil, "del": nil, "ins": nil,
+	"sub": nil, "sup": nil, "small": nil, "mark": nil, "abbr": {"title"}, "cite": nil, "time": {"datetime"},
+	"code": nil, "pre": nil, "kbd": nil, "samp": nil, "blockquote": {"cite"}, "q": {"cite"},
+	"h1": nil, "h2": nil, "h3": nil, "h4": nil, "h5": nil, "h6": nil,
+	"ul": nil, "ol": {"start"}, "li": nil, "dl": nil, "dt": nil, "dd": nil,
+	"table": nil, "thead": nil, "tbody": nil, "tfoot": nil, "tr": nil, "caption": nil,
+	"th": {"colspan", "rowspan"}, "td": {"colspan", "rowspan"},
+	"figure": nil, "figcaption": nil, "picture": nil, "source": {"src", "type"},
+	"video": {"src", "poster", "controls"}, "audio": {"src", "controls"},
+}
+
+// droppedElements 的内容会和元素一起删除
+var droppedElements = map[string]bool{
+	"script": true, "style": true, "iframe": true, "frameset": true, "object": true, "embed": true, "applet": true,
+	"noscript": true, "noembed": true, "noframes": true, "template": true, "svg": true, "math": true,
+	"textarea": true, "select": true, "title": true, "xmp": true,
+}
+
+// urlAttributes 的值必须是 http(s) 或 mailto 链接，或者相对地址
+var urlAttributes = map[string]bool{"href": true, "src": true, "cite": true, "poster": true}
+
+// SanitizeHTML 只保留 allowedElements 中的元素和属性，删除脚本、事件属性和
+// javascript: 等链接，使其他来源的文章内容可以直接嵌入页面
+func SanitizeHTML(content string) string {
+	var out strings.Builder
+	for pos := 0; pos < len(content); {
+		match := htmlTokenPattern.FindStringSubmatchIndex(content[pos:])
+		if match == nil {
+			out.WriteString(escapeText(content[pos:]))
+			break
+		}
+		start, end := pos+match[0], pos+match[1]
+		out.WriteString(escapeText(content[pos:start]))
+		tag := content[start:end]
+		pos = end
+		// 注释、声明和处理指令没有名称，直接丢弃
+		if match[2] < 0 {
+			continue
+		}
+		name := strings.ToLower(tag[match[2]-match[0] : match[3]-match[0]])
+		closing := tag[1] == '/'
+		if droppedElements[name] {
+			if !closing {
+				pos += closingTag(content[pos:], name)
+			}
+			continue
+		}
+		attributes, ok := allowedElements[name]
+		if !ok {
+			continue
+		}
+		if closing {
+			out.WriteString("</" + name + ">")
+			continue
+		}
+		out.WriteString("<" + name)
+		values := tagAttributes([]byte(tag))
+		for _, attribute := range attributes {
+			value, ok := values[attribute]
+			if ok && urlAttributes[attribute] {
+				value, ok = safeURL(value)
+			}
+			if ok {
+				out.WriteString(" " + attribute + `="` + html.EscapeString(value) + `"`)
+			}
+		}
+		out.WriteString(">")
+	}
+	return out.String()
+}
+
+// closingTag 返回 name 元素结束标签的位置，找不到时返回 len(content)
+func closingTag(content, name string) int {
+	for i := 0; ; i++ {
+		next := strings.Index(content[i:], "</")
+		if next < 0 {
+			return len(content)
+		}
+		i += next
+		if end := i + 2 + len(name); end <= len(content) && strings.EqualFold(content[i+2:end], name) {
+			return i
+		}
+	}
+}
+
+// escapeText 转义不属于标签的尖括号
+func escapeText(text string) string {
+	return strings.NewReplacer("<", "&lt;", ">", "&gt;").Replace(text)
+}
+
+// safeURL 去掉浏览器会忽略的换行、制表符和控制字符，只接受 http(s)、mailto 和相对地址
+func safeURL(value string) (string, bool) {
+	value = strings.Map(func(r rune) rune {
+		if r < ' ' || r == 0x7f {
+			return -1
+		}
+		return r
+	}, strings.TrimSpace(value))
+	u, err := url.Parse(value)
+	if err != nil {
+		return "", false
+	}
+	switch strings.ToLower(u.Scheme) {
+	case "", "http", "https", "mailto":
+		return value, true
+	}
+	return "", false
+}
@@ -0,0 +1,22 @@
+package feed
+
+import "testing"
+
+func TestSanitizeHTML(t *testing.T) {
+	tests := []struct{ in, want string }{
+		{`<p onclick="x()">Hi <b>there</b></p>`, `<p>Hi <b>there</b></p>`},
+		{`a<script>alert(1)</script>b<SCRIPT src=x></SCRIPT >c`, `abc`},
+		{`<a href="javascript:alert(1)">x</a>`, `<a>x</a>`},
+		{`<a href="java&#x09;script:alert(1)">x</a>`, `<a>x</a>`},
+		{`<a href='https://example.com/?a=1&amp;b="2"' target=_blank>x</a>`, `<a href="https://example.com/?a=1&amp;b=&#34;2&#34;">x</a>`},
+		{`<img src="/a.png" onerror="alert(1)"><iframe src="https://evil"></iframe>`, `<img src="/a.png">`},
+		{`<!-- <script> --><custom>kept</custom> 1 < 2`, `kept 1 &lt; 2`},
+		{`<img src=x alt="a><script>alert(1)</script>">`, `<img src="x" alt="a&gt;&lt;script&gt;alert(1)&lt;/script&gt;">`},
+		{`<style>p{}</style`, `&lt;/style`},
+	}
+	for _, test := range tests {
+		if got := SanitizeHTML(test.in); got != test.want {
+			t.Errorf("SanitizeHTML(%q) = %q, want %q", test.in, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,283 @@
+package feed
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// Channel 是要用 WriteRSS 或 WriteAtom 输出的订阅源
+type Channel struct {
+	// ID 是订阅源的永久 URI，见 TagURI
+	ID          string
+	Title       string
+	Description string
+	// Link 是展示订阅源的网页，Self 是订阅源自身的地址
+	Link      string
+	Self      string
+	Updated   time.Time
+	Generator string
+	Entries   []Entry
+}
+
+// Entry 是 Channel 中的一个条目
+type Entry struct {
+	// ID 是条目的永久 URI，见 TagURI
+	ID         string
+	Title      string
+	Link       string
+	Content    string
+	Author     string
+	Published  time.Time
+	Updated    time.Time
+	Categories []string
+	// Source 是聚合条目原本所在的订阅源
+	Source *Source
+}
+
+// Source 描述条目来源的订阅源
+type Source struct {
+	Title string
+	// Link 是来源的网页，Self 是来源订阅源的地址
+	Link    string
+	Self    string
+	Updated time.Time
+}
+
+// TagURI 生成形如 "tag:example.com,2024-01-02:post/1" 的 tag: URI（RFC 4151），
+// authority 为主机名，端口会被去掉
+func TagURI(authority string, date time.Time, specific string) string {
+	if host, _, ok := strings.Cut(authority, ":"); ok && !strings.HasPrefix(authority, "[") {
+		authority = host
+	}
+	return fmt.Sprintf("tag:%s,%s:%s", strings.ToLower(authority), date.UTC().Format("2006-01-02"), specific)
+}
+
+type rssOutput struct {
+	XMLName   xml.Name         `xml:"rss"`
+	Version   string           `xml:"version,attr"`
+	XmlnsAtom string           `xml:"xmlns:atom,attr"`
+	XmlnsDC   string           `xml:"xmlns:dc,attr"`
+	Channel   rssOutputChannel `xml:"channel"`
+}
+
+type rssOutputChannel struct {
+	// self 链接放在最前面，按本地名读取 <link> 的解析器会保留最后一个
+	AtomLink      atomOutputLink  `xml:"atom:link"`
+	Title         string          `xml:"title"`
+	Link          string          `xml:"link"`
+	Description   string          `xml:"description"`
+	LastBuildDate string          `xml:"lastBuildDate,omitempty"`
+	Generator     string          `xml:"generator,omitempty"`
+	Items         []rssOutputItem `xml:"item"`
+}
+
+type rssOutputItem struct {
+	Title       string     `xml:"title,omitempty"`
+	Link        string     `xml:"link,omitempty"`
+	Description string     `xml:"description,omitempty"`
+	Creator     string     `xml:"dc:creator,omitempty"`
+	Categories  []string   `xml:"category,omitempty"`
+	Guid        *RssGuid   `xml:"guid,omitempty"`
+	PubDate     string     `xml:"pubDate,omitempty"`
+	Source      *RssSource `xml:"source,omitempty"`
+}
+
+// WriteRSS 将 channel 输出为 RSS 2.0 文档
+func WriteRSS(w io.Writer, channel *Channel) error {
+	doc := rssOutput{
+		Version:   "2.0",
+		XmlnsAtom: AtomNamespace,
+		XmlnsDC:   "http://purl.org/dc/elements/1.1/",
+		Channel: rssOutputChannel{
+			AtomLink:    atomOutputLink{Href: channel.Self, Rel: "self", Type: "application/rss+xml"},
+			Title:       channel.Title,
+			Link:        channel.Link,
+			Description: channel.Description,
+			Generator:   channel.Generator,
+		},
+	}
+	if doc.Channel.Description == "" {
+		doc.Channel.Description = channel.Title
+	}
+	if !channel.Updated.IsZero() {
+		doc.Channel.LastBuildDate = channel.Updated.UTC().Format(time.RFC1123Z)
+	}
+	for _, entry := range channel.Entries {
+		item := rssOutputItem{
+			Title:       entry.Title,
+			Link:        entry.Link,
+			Description: entry.Content,
+			Creator:     entry.Author,
+			Categories:  entry.Categories,
+		}
+		if entry.ID != "" {
+			item.Guid = &RssGuid{Value: entry.ID, IsPermaLink: "false"}
+		} else if entry.Link != "" {
+			item.Guid = &RssGuid{Value: entry.Link, IsPermaLink: "true"}
+		}
+		if !entry.Published.IsZero() {
+			item.PubDate = entry.Published.UTC().Format(time.RFC1123Z)
+		}
+		// <source> 需要来源订阅源的地址
+		if entry.Source != nil && entry.Source.Self != "" {
+			item.Source = &RssSource{URL: entry.Source.Self, Title: entry.Source.Title}
+		}
+		doc.Channel.Items = append(doc.Channel.Items, item)
+	}
+	return writeXML(w, doc)
+}
+
+type atomOutput struct {
+	XMLName   xml.Name             `xml:"feed"`
+	Xmlns     string               `xml:"xmlns,attr"`
+	ID        string               `xml:"id"`
+	Title     atomOutputText       `xml:"title"`
+	Subtitle  *atomOutputText      `xml:"subtitle,omitempty"`
+	Updated   string               `xml:"updated"`
+	Links     []atomOutputLink     `xml:"link"`
+	Author    *atomOutputPerson    `xml:"author,omitempty"`
+	Generator *atomOutputGenerator `xml:"generator,omitempty"`
+	Entries   []atomOutputEntry    `xml:"entry"`
+}
+
+type atomOutputText struct {
+	Type string `xml:"type,attr,omitempty"`
+	Data string `xml:",chardata"`
+}
+
+type atomOutputLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr,omitempty"`
+	Type string `xml:"type,attr,omitempty"`
+}
+
+type atomOutputPerson struct {
+	Name string `xml:"name"`
+}
+
+type atomOutputGenerator struct {
+	URI  string `xml:"uri,attr,omitempty"`
+	Name string `xml:",chardata"`
+}
+
+type atomOutputCategory struct {
+	Term string `xml:"term,attr"`
+}
+
+type atomOutputEntry struct {
+	ID         string               `xml:"id"`
+	Title      atomOutputText       `xml:"title"`
+	Updated    string               `xml:"updated"`
+	Published  string               `xml:"published,omitempty"`
+	Author     *atomOutputPerson    `xml:"author,omitempty"`
+	Links      []atomOutputLink     `xml:"link"`
+	Categories []atomOutputCategory `xml:"category"`
+	Content    *atomOutputText      `xml:"content,omitempty"`
+	Source     *atomOutputSource    `xml:"source,omitempty"`
+}
+
+type atomOutputSource struct {
+	ID      string           `xml:"id,omitempty"`
+	Title   atomOutputText   `xml:"title"`
+	Updated string           `xml:"updated,omitempty"`
+	Links   []atomOutputLink `xml:"link"`
+}
+
+// WriteAtom 将 channel 输出为 Atom 1.0 文档。Atom 要求每个条目都有作者，
+// 没有作者的条目使用订阅源的标题
+func WriteAtom(w io.Writer, channel *Channel) error {
+	doc := atomOutput{
+		Xmlns:   AtomNamespace,
+		ID:      firstOf(channel.ID, channel.Self, channel.Link),
+		Title:   atomOutputText{Data: channel.Title},
+		Updated: atomTime(channel.Updated),
+		Author:  &atomOutputPerson{Name: channel.Title},
+	}
+	if channel.Description != "" {
+		doc.Subtitle = &atomOutputText{Data: channel.Description}
+	}
+	// alternate 链接放在最前面，ParseFeed 把它当作订阅源的链接
+	if channel.Link != "" {
+		doc.Links = append(doc.Links, atomOutputLink{Href: channel.Link, Rel: "alternate", Type: "text/html"})
+	}
+	if channel.Self != "" {
+		doc.Links = append(doc.Links, atomOutputLink{Href: channel.Self, Rel: "self", Type: "application/atom+xml"})
+	}
+	if channel.Generator != "" {
+		doc.Generator = &atomOutputGenerator{Name: channel.Generator}
+	}
+	for _, entry := range channel.Entries {
+		updated := entry.Updated
+		if updated.IsZero() {
+			updated = entry.Published
+		}
+		out := atomOutputEntry{
+			ID:      firstOf(entry.ID, entry.Link),
+			Title:   atomOutputText{Type: "text", Data: entry.Title},
+			Updated: atomTime(updated),
+		}
+		if !entry.Published.IsZero() {
+			out.Published = atomTime(entry.Published)
+		}
+		if entry.Author != "" {
+			out.Author = &atomOutputPerson{Name: entry.Author}
+		}
+		if entry.Link != "" {
+			out.Links = append(out.Links, atomOutputLink{Href: entry.Link, Rel: "alternate", Type: "text/html"})
+		}
+		for _, category := range entry.Categories {
+			out.Categories = append(out.Categories, atomOutputCategory{Term: category})
+		}
+		if entry.Content != "" || entry.Link == "" {
+			out.Content = &atomOutputText{Type: "html", Data: entry.Content}
+		}
+		if source := entry.Source; source != nil {
+			out.Source = &atomOutputSource{ID: firstOf(source.Self, source.Link), Title: atomOutputText{Data: source.Title}}
+			if !source.Updated.IsZero() {
+				out.Source.Updated = atomTime(source.Updated)
+			}
+			if source.Link != "" {
+				out.Source.Links = append(out.Source.Links, atomOutputLink{Href: source.Link, Rel: "alternate", Type: "text/html"})
+			}
+			if source.Self != "" {
+				out.Source.Links = append(out.Source.Links, atomOutputLink{Href: source.Self, Rel: "self"})
+			}
+		}
+		doc.Entries = append(doc.Entries, out)
+	}
+	return writeXML(w, doc)
+}
+
+// firstOf 返回第一个非空值，用于 id 缺失时回退到链接
+func firstOf(values ...string) string {
+	for _, value := range values {
+		if value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+// atomTime 格式化为 RFC 3339 时间，零值使用 Unix 纪元
+func atomTime(t time.Time) string {
+	if t.IsZero() {
+		t = time.Unix(0, 0)
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+func writeXML(w io.Writer, doc any) error {
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	if err := encoder.Encode(doc); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
@@ -0,0 +1,275 @@
+package feed
+
+import (
+	"bytes"
+	"encoding/xml"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testChannel() *Channel {
+	published := time.Date(2024, 3, 1, 12, 30, 0, 0, time.UTC)
+	return &Channel{
+		ID:          TagURI("reader.example.com:8080", time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC), "posts?saved="),
+		Title:       "Reader - Saved",
+		Description: "Saved",
+		Link:        "https://reader.example.com/posts?saved=",
+		Self:        "https://reader.example.com/atom.xml?saved",
+		Updated:     published.Add(time.Hour),
+		Generator:   "feedreader",
+		Entries: []Entry{
+			{
+				ID:         TagURI("reader.example.com", published, "post/1"),
+				Title:      "Fish & <Chips>",
+				Link:       "https://blog.example.org/fish",
+				Content:    "<p>Tasty &amp; hot</p>",
+				Author:     "Ada",
+				Published:  published,
+				Categories: []string{"Food"},
+				Source: &Source{
+					Title: "Example Blog",
+					Link:  "https://blog.example.org/",
+					Self:  "https://blog.example.org/feed.xml",
+				},
+			},
+			{
+				ID:        TagURI("reader.example.com", published, "post/2"),
+				Title:     "No link",
+				Content:   "plain",
+				Published: published.Add(-24 * time.Hour),
+			},
+		},
+	}
+}
+
+func TestTagURI(t *testing.T) {
+	date := time.Date(2024, 1, 2, 23, 0, 0, 0, time.FixedZone("", -5*3600))
+	for authority, want := range map[string]string{
+		"Reader.Example.com":      "tag:reader.example.com,2024-01-03:post/1",
+		"reader.example.com:8080": "tag:reader.example.com,2024-01-03:post/1",
+	} {
+		if got := TagURI(authority, date, "post/1"); got != want {
+			t.Errorf("TagURI(%q) = %q, want %q", authority, got, want)
+		}
+	}
+}
+
+// checkRoundTrip parses the written document with ParseFeed and compares
+// it with the channel.
+func checkRoundTrip(t *testing.T, data []byte, channel *Channel, feedType FeedType) {
+	t.Helper()
+	parsed, err := ParseFeed(data)
+	if err != nil {
+		t.Fatalf("ParseFeed: %v\n%s", err, data)
+	}
+	if parsed.Type != feedType {
+		t.Errorf("type = %q, want %q", parsed.Type, feedType)
+	}
+	if parsed.Title != channel.Title {
+		t.Errorf("title = %q, want %q", parsed.Title, channel.Title)
+	}
+	if parsed.Link != channel.Link {
+		t.Errorf("link = %q, want %q", parsed.Link, channel.Link)
+	}
+	if len(parsed.Items) != len(channel.Entries) {
+		t.Fatalf("got %d items, want %d", len(parsed.Items), len(channel.Entries))
+	}
+	for i, entry := range channel.Entries {
+		item := parsed.Items[i]
+		if item.ID != entry.ID {
+			t.Errorf("item %d: id = %q, want %q", i, item.ID, entry.ID)
+		}
+		if item.Title != entry.Title {
+			t.Errorf("item %d: title = %q, want %q", i, item.Title, entry.Title)
+		}
+		if item.Link != entry.Link {
+			t.Errorf("item %d: link = %q, want %q", i, item.Link, entry.Link)
+		}
+		if item.Description != entry.Content {
+			t.Errorf("item %d: content = %q, want %q", i, item.Description, entry.Content)
+		}
+		if item.Author != entry.Author {
+			t.Errorf("item %d: author = %q, want %q", i, item.Author, entry.Author)
+		}
+		if !item.PubDate.Equal(entry.Published) {
+			t.Errorf("item %d: date = %v, want %v", i, item.PubDate, entry.Published)
+		}
+	}
+}
+
+func TestWriteRSS(t *testing.T) {
+	channel := testChannel()
+	var buf bytes.Buffer
+	if err := WriteRSS(&buf, channel); err != nil {
+		t.Fatal(err)
+	}
+	checkRoundTrip(t, buf.Bytes(), channel, TypeRSS)
+
+	var doc struct {
+		Version string `xml:"version,attr"`
+		Channel struct {
+			Description string `xml:"description"`
+			Self        []struct {
+				Href string `xml:"href,attr"`
+				Rel  string `xml:"rel,attr"`
+			} `xml:"http://www.w3.org/2005/Atom link"`
+			Items []struct {
+				Guid struct {
+					IsPermaLink string `xml:"isPermaLink,attr"`
+				} `xml:"guid"`
+				Source *struct {
+					URL   string `xml:"url,attr"`
+					Title string `xml:",chardata"`
+				} `xml:"source"`
+				Categories []string `xml:"category"`
+			} `xml:"item"`
+		} `xml:"channel"`
+	}
+	if err := xml.Unmarshal(buf.Bytes(), &doc); err != nil {
+		t.Fatal(err)
+	}
+	if doc.Version != "2.0" {
+		t.Errorf("version = %q, want 2.0", doc.Version)
+	}
+	if doc.Channel.Description != channel.Description {
+		t.Errorf("description = %q, want %q", doc.Channel.Description, channel.Description)
+	}
+	if len(doc.Channel.Self) != 1 || doc.Channel.Self[0].Rel != "self" || doc.Channel.Self[0].Href != channel.Self {
+		t.Errorf("self link = %+v, want %q", doc.Channel.Self, channel.Self)
+	}
+	first := doc.Channel.Items[0]
+	if first.Guid.IsPermaLink != "false" {
+		t.Errorf("guid isPermaLink = %q, want false", first.Guid.IsPermaLink)
+	}
+	if first.Source == nil || first.Source.URL != "https://blog.example.org/feed.xml" || first.Source.Title != "Example Blog" {
+		t.Errorf("source = %+v", first.Source)
+	}
+	if len(first.Categories) != 1 || first.Categories[0] != "Food" {
+		t.Errorf("categories = %q", first.Categories)
+	}
+	if doc.Channel.Items[1].Source != nil {
+		t.Errorf("item without source has %+v", doc.Channel.Items[1].Source)
+	}
+}
+
+func TestWriteAtom(t *testing.T) {
+	channel := testChannel()
+	var buf bytes.Buffer
+	if err := WriteAtom(&buf, channel); err != nil {
+		t.Fatal(err)
+	}
+	checkRoundTrip(t, buf.Bytes(), channel, TypeAtom)
+
+	type link struct {
+		Href string `xml:"href,attr"`
+		Rel  string `xml:"rel,attr"`
+	}
+	var doc struct {
+		XMLName xml.Name
+		ID      string `xml:"id"`
+		Updated string `xml:"updated"`
+		Author  string `xml:"author>name"`
+		Links   []link `xml:"link"`
+		Entries []struct {
+			ID      string `xml:"id"`
+			Updated string `xml:"updated"`
+			Links   []link `xml:"link"`
+			Content *struct {
+				Type string `xml:"type,attr"`
+			} `xml:"content"`
+			Summary *struct{} `xml:"summary"`
+			Source  *struct {
+				ID    string `xml:"id"`
+				Title string `xml:"title"`
+				Links []link `xml:"link"`
+			} `xml:"source"`
+		} `xml:"entry"`
+	}
+	if err := xml.Unmarshal(buf.Bytes(), &doc); err != nil {
+		t.Fatal(err)
+	}
+	if doc.XMLName.Space != AtomNamespace || doc.XMLName.Local != "feed" {
+		t.Errorf("root = %v, want {%s feed}", doc.XMLName, AtomNamespace)
+	}
+	if !strings.HasPrefix(doc.ID, "tag:reader.example.com,2024-01-02:") {
+		t.Errorf("id = %q, want a tag: URI", doc.ID)
+	}
+	if _, err := time.Parse(time.RFC3339, doc.Updated); err != nil {
+		t.Errorf("updated: %v", err)
+	}
+	if doc.Author == "" {
+		t.Error("feed has no author for entries without one")
+	}
+	rels := map[string]string{}
+	for _, l := range doc.Links {
+		rels[l.Rel] = l.Href
+	}
+	if rels["self"] != channel.Self || rels["alternate"] != channel.Link {
+		t.Errorf("links = %v", rels)
+	}
+	for i, entry := range doc.Entries {
+		if !strings.HasPrefix(entry.ID, "tag:") {
+			t.Errorf("entry %d: id = %q, want a tag: URI", i, entry.ID)
+		}
+		if _, err := time.Parse(time.RFC3339, entry.Updated); err != nil {
+			t.Errorf("entry %d: updated: %v", i, err)
+		}
+		if entry.Content == nil && len(entry.Links) == 0 {
+			t.Errorf("entry %d has neither content nor an alternate link", i)
+		}
+		if entry.Summary != nil {
+			t.Errorf("entry %d has an empty summary", i)
+		}
+	}
+	source := doc.Entries[0].Source
+	if source == nil || source.ID != "https://blog.example.org/feed.xml" || source.Title != "Example Blog" || len(source.Links) != 2 {
+		t.Errorf("source = %+v", source)
+	}
+	if doc.Entries[1].Source != nil {
+		t.Errorf("entry without source has %+v", doc.Entries[1].Source)
+	}
+}
+
+func TestWriteAtomNeverEmptyId(t *testing.T) {
+	published := time.Date(2024, 3, 1, 12, 30, 0, 0, time.UTC)
+	channel := &Channel{
+		Title:   "Reader",
+		Link:    "https://reader.example.com/posts",
+		Updated: published,
+		Entries: []Entry{
+			{Title: "Home only", Link: "https://blog.example.org/1", Published: published,
+				Source: &Source{Title: "Blog", Link: "https://blog.example.org/"}},
+			{ID: "tag:reader.example.com,2024-03-01:post/2", Title: "No source feed", Published: published,
+				Source: &Source{Title: "Untitled"}},
+		},
+	}
+	var buf bytes.Buffer
+	if err := WriteAtom(&buf, channel); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(buf.String(), "<id></id>") {
+		t.Fatalf("empty id in:\n%s", buf.String())
+	}
+	var doc struct {
+		ID      string `xml:"id"`
+		Entries []struct {
+			ID     string `xml:"id"`
+			Source struct {
+				ID *string `xml:"id"`
+			} `xml:"source"`
+		} `xml:"entry"`
+	}
+	if err := xml.Unmarshal(buf.Bytes(), &doc); err != nil {
+		t.Fatal(err)
+	}
+	if doc.ID != channel.Link {
+		t.Errorf("feed id = %q, want the link", doc.ID)
+	}
+	if first := doc.Entries[0]; first.ID != "https://blog.example.org/1" || first.Source.ID == nil || *first.Source.ID != "https://blog.example.org/" {
+		t.Errorf("entry without id: %q, source id %v", first.ID, first.Source.ID)
+	}
+	if id := doc.Entries[1].Source.ID; id != nil {
+		t.Errorf("source without links has id %q", *id)
+	}
+}
@@ -1,13 +1,16 @@
 package main
 
 import (
+	"bufio"
 	"flag"
+	"fmt"
 	"net/http"
 	"os"
 	"path"
+	"strconv"
+	"strings"
 
 	"github.com/lsongdev/feedreader/reader"
-	"github.com/song940/fever-go/fever"
 )
 
 func main() {
@@ -21,28 +24,171 @@ func main() {
 	flag.Parse()
 	config.Load()
 
+	if flag.Arg(0) == "passwd" {
+		if err := passwdCommand(); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	server, err := reader.NewReader(config)
 	if err != nil {
 		panic(err)
 	}
 
-	api := fever.New(server)
-	router := http.NewServeMux()
-	router.HandleFunc("/", server.IndexView)
-	router.HandleFunc("/new", server.NewView)
-	router.HandleFunc("/posts", server.PostView)
-	router.HandleFunc("/feeds", server.FeedView)
-	router.HandleFunc("/refresh", server.RefreshView)
-	router.HandleFunc("/import", server.ImportView)
-	router.HandleFunc("/categories", server.CategoryView)
-	router.HandleFunc("/rss.xml", server.RssXml)
-	router.HandleFunc("/atom.xml", server.AomXml)
-	router.HandleFunc("/opml.xml", server.OpmlXml)
-	router.HandleFunc("/feeds.json", server.FeedsJson)
-	router.HandleFunc("/posts.json", server.PostsJson)
-	router.Handle("/fever/", api)
-	err = http.ListenAndServe(config.Listen, router)
+	if flag.Arg(0) == "token" {
+		if err := tokenCommand(server, config, flag.Args()[1:]); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if command := flag.Arg(0); command == "export" || command == "restore" || command == "backup" {
+		if err := archiveCommand(server, config, command, flag.Args()[1:]); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	err = http.ListenAndServe(config.Listen, server.Handler())
 	if err != nil {
 		panic(err)
 	}
 }
+
+// passwdCommand reads a password from stdin and prints its hash for the
+// password_hash field in config.yaml.
+func passwdCommand() error {
+	fmt.Fprint(os.Stderr, "Password: ")
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && line == "" {
+		return err
+	}
+	password := strings.TrimRight(line, "\r\n")
+	if password == "" {
+		return fmt.Errorf("password is empty")
+	}
+	hash, err := reader.HashPassword(password)
+	if err != nil {
+		return err
+	}
+	fmt.Println(hash)
+	return nil
+}
+
+// tokenCommand manages API tokens from the command line:
+//
+//	reader token list
+//	reader token create -name NAME [-scopes read,write] [-user USER]
+//	reader token revoke ID
+func tokenCommand(server *reader.Reader, config *reader.Config, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: token list | create -name NAME [-scopes read,write,admin] [-user USER] | revoke ID")
+	}
+	switch args[0] {
+	case "list":
+		tokens, err := server.GetTokens()
+		if err != nil {
+			return err
+		}
+		for _, token := range tokens {
+			fmt.Printf("%d\t%s\t%s\t%s\n", token.Id, token.Name, token.Username, strings.Join(token.Scopes, ","))
+		}
+	case "create":
+		cmd := flag.NewFlagSet("token create", flag.ExitOnError)
+		name := cmd.String("name", "", "token name")
+		scopes := cmd.String("scopes", reader.ScopeRead, "comma separated scopes: read, write, admin")
+		username := cmd.String("user", "", "user the token belongs to (default: first configured user)")
+		cmd.Parse(args[1:])
+		if *username == "" && len(config.Users) > 0 {
+			*username = config.Users[0].Username
+		}
+		if server.FindUser(*username) == nil {
+			return fmt.Errorf("unknown user: %s", *username)
+		}
+		list, err := reader.ParseScopes(*scopes)
+		if err != nil {
+			return err
+		}
+		secret, _, err := server.CreateToken(*username, *name, list)
+		if err != nil {
+			return err
+		}
+		fmt.Println(secret)
+	case "revoke":
+		if len(args) < 2 {
+			return fmt.Errorf("usage: token revoke ID")
+		}
+		id, err := strconv.Atoi(args[1])
+		if err != nil {
+			return fmt.Errorf("invalid token id: %s", args[1])
+		}
+		return server.RevokeToken(id)
+	default:
+		return fmt.Errorf("unknown token command: %s", args[0])
+	}
+	return nil
+}
+
+// archiveCommand exports and restores a user's archive, or backs up the
+// database:
+//
+//	reader export [-user USER] [-o FILE]
+//	reader restore [-user USER] FILE
+//	reader backup
+func archiveCommand(server *reader.Reader, config *reader.Config, command string, args []string) error {
+	if command == "backup" {
+		file, err := server.Backup()
+		if err != nil {
+			return err
+		}
+		fmt.Println(file)
+		return nil
+	}
+	cmd := flag.NewFlagSet(command, flag.ExitOnError)
+	username := cmd.String("user", "", "user whose data is exported or restored (default: first configured user)")
+	output := cmd.String("o", "", "file to write the archive to (default: standard output)")
+	cmd.Parse(args)
+	if *username == "" && len(config.Users) > 0 {
+		*username = config.Users[0].Username
+	}
+	user := server.FindUser(*username)
+	if user == nil {
+		return fmt.Errorf("unknown user: %s", *username)
+	}
+	server = server.As(user)
+	if command == "export" {
+		if *output == "" {
+			return server.ExportArchive(os.Stdout)
+		}
+		f, err := os.Create(*output)
+		if err != nil {
+			return err
+		}
+		if err = server.ExportArchive(f); err != nil {
+			f.Close()
+			return err
+		}
+		return f.Close()
+	}
+	if cmd.NArg() != 1 {
+		return fmt.Errorf("usage: restore [-user USER] FILE")
+	}
+	data, err := os.ReadFile(cmd.Arg(0))
+	if err != nil {
+		return err
+	}
+	report, err := server.RestoreArchive(data)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Restored %d categories, %d feeds, %d lists, %d shared feeds, %d posts and the state of %d posts\n",
+		report.Categories, report.Feeds, report.Lists, report.Shares, report.Posts, report.States)
+	for _, share := range report.NewShares {
+		fmt.Printf("Shared feed %q has the new secret %s\n", share.Name, share.Secret)
+	}
+	return nil
+}
@@ -2,10 +2,14 @@ package reader
 
 import (
 	"crypto/md5"
+	"encoding/json"
 	"fmt"
 	"log"
+	"net/http"
+	"net/url"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/song940/fever-go/fever"
 )
@@ -16,21 +20,83 @@ func (user *User) FeverAuthKey() string {
 	return fmt.Sprintf("%X", md5.Sum([]byte(str)))
 }
 
+// feverEndpoints lists the Fever request kinds in the order fever-go checks them.
+var feverEndpoints = []string{"groups", "feeds", "items", "unread_item_ids", "saved_item_ids", "mark"}
+
+func feverEndpoint(input url.Values) string {
+	for _, name := range feverEndpoints {
+		if input.Has(name) {
+			return name
+		}
+	}
+	return "auth"
+}
+
+// feverSession implements fever.Handler for a single request and keeps the
+// first storage error so it can be reported back to the client.
+type feverSession struct {
+	reader *Reader
+	token  *Token
+	err    error
+}
+
+func (s *feverSession) fail(format string, err error) {
+	log.Printf(format, err)
+	if s.err == nil {
+		s.err = err
+	}
+}
+
+// FeverView serves the Fever API.
+func (reader *Reader) FeverView(w http.ResponseWriter, r *http.Request) {
+	var input url.Values
+	if r.Method == "POST" {
+		r.ParseForm()
+		input = r.Form
+	} else {
+		input = r.URL.Query()
+	}
+	start := time.Now()
+	endpoint := feverEndpoint(input)
+	session := &feverSession{reader: reader}
+	response := fever.New(session).Handle(input)
+	status := http.StatusOK
+	if session.err != nil {
+		status = http.StatusInternalServerError
+		response["error"] = session.err.Error()
+	}
+	elapsed := time.Since(start)
+	reader.metrics.Observe("fever."+endpoint, elapsed, session.err != nil)
+	log.Printf("fever %s %s %d %s", r.Method, endpoint, status, elapsed)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(response)
+}
+
 // FeverAuthenticate implements fever.Handler.
-func (r *Reader) FeverAuthenticate(apiKey string) bool {
-	log.Println("Authenticating", apiKey)
-	for _, user := range r.config.Users {
-		if strings.ToUpper(apiKey) == user.FeverAuthKey() {
+func (s *feverSession) FeverAuthenticate(apiKey string) bool {
+	for i, user := range s.reader.config.Users {
+		// users with only a password hash have to use API tokens
+		if user.Password != "" && strings.ToUpper(apiKey) == user.FeverAuthKey() {
+			s.reader = s.reader.As(&s.reader.config.Users[i])
+			return true
+		}
+	}
+	if token := s.reader.AuthenticateFeverToken(apiKey); token != nil && token.Allows(ScopeRead) {
+		if user := s.reader.FindUser(token.Username); user != nil {
+			s.token = token
+			s.reader = s.reader.As(user)
 			return true
 		}
 	}
+	log.Println("Fever authentication failed")
 	return false
 }
 
-func (r *Reader) FeverGroups() (response fever.GroupsResponse) {
-	categories, err := r.GetCategories()
+func (s *feverSession) FeverGroups() (response fever.GroupsResponse) {
+	categories, err := s.reader.GetCategories()
 	if err != nil {
-		log.Fatalf("Failed to get categories: %v", err)
+		s.fail("Failed to get categories: %v", err)
 		return
 	}
 	response.Groups = make([]fever.Group, 0, len(categories))
@@ -40,9 +106,9 @@ func (r *Reader) FeverGroups() (response fever.GroupsResponse) {
 			Title: group.Name,
 		})
 	}
-	feeds, err := r.GetFeeds(nil)
+	feeds, err := s.reader.GetFeeds(nil)
 	if err != nil {
-		log.Fatalf("Failed to get feeds: %v", err)
+		s.fail("Failed to get feeds: %v", err)
 		return
 	}
 	var feedGroups = make(map[int][]string)
@@ -58,10 +124,10 @@ func (r *Reader) FeverGroups() (response fever.GroupsResponse) {
 	return
 }
 
-func (r *Reader) FeverFeeds() (response fever.FeedsResponse) {
-	feeds, err := r.GetFeeds(nil)
+func (s *feverSession) FeverFeeds() (response fever.FeedsResponse) {
+	feeds, err := s.reader.GetFeeds(nil)
 	if err != nil {
-		log.Fatalf("Failed to get subscriptions: %v", err)
+		s.fail("Failed to get subscriptions: %v", err)
 		return
 	}
 	var groups map[int][]string = make(map[int][]string)
@@ -85,26 +151,44 @@ func (r *Reader) FeverFeeds() (response fever.FeedsResponse) {
 	return response
 }
 
-func (r *Reader) FeverItems(req *fever.ItemRequest) (response fever.ItemsResponse) {
+// parseIdList validates a comma separated list of numeric ids.
+func parseIdList(str string) (ids []string, err error) {
+	for _, id := range strings.Split(str, ",") {
+		id = strings.TrimSpace(id)
+		if id == "" {
+			continue
+		}
+		if _, err = strconv.Atoi(id); err != nil {
+			return nil, fmt.Errorf("invalid id: %q", id)
+		}
+		ids = append(ids, id)
+	}
+	return
+}
+
+func (s *feverSession) FeverItems(req *fever.ItemRequest) (response fever.ItemsResponse) {
 	conditions := make([]string, 0)
 	if req.SinceId != "" {
-		conditions = append(conditions, fmt.Sprintf("p.id > %s", req.SinceId))
+		sinceId, err := strconv.Atoi(req.SinceId)
+		if err != nil {
+			s.fail("Invalid since_id: %v", err)
+			return
+		}
+		conditions = append(conditions, fmt.Sprintf("p.id > %d", sinceId))
 	}
 	if req.WithIDs != "" {
-		conditions = append(conditions, fmt.Sprintf("p.id IN (%s)", req.WithIDs))
+		ids, err := parseIdList(req.WithIDs)
+		if err != nil {
+			s.fail("Invalid with_ids: %v", err)
+			return
+		}
+		conditions = append(conditions, fmt.Sprintf("p.id IN (%s)", strings.Join(ids, ",")))
 	}
-	posts, err := r.GetPosts(conditions, nil)
+	posts, err := s.reader.GetPosts(conditions, nil)
 	if err != nil {
-		log.Fatalf("Failed to get posts: %v", err)
+		s.fail("Failed to get posts: %v", err)
 		return
 	}
-	b2i := func(b bool) int {
-		if b {
-			return 1
-		} else {
-			return 0
-		}
-	}
 	response.Total = len(posts)
 	response.Items = make([]fever.Item, 0, len(posts))
 	for _, post := range posts {
@@ -123,48 +207,45 @@ func (r *Reader) FeverItems(req *fever.ItemRequest) (response fever.ItemsRespons
 	return response
 }
 
-func (r *Reader) FeverUnreadItemIds() (response fever.UnreadResponse) {
-	postIds := make([]string, 0)
-	rows, err := r.db.Query("SELECT id FROM posts WHERE is_read = 0")
+// joinedPostIds returns the ids of matching posts as a comma separated list.
+func (reader *Reader) joinedPostIds(conditions ...string) (string, error) {
+	ids, err := reader.PostIds(conditions)
 	if err != nil {
-		log.Fatalf("Failed to get unread posts: %v", err)
-		return
+		return "", err
 	}
-	for rows.Next() {
-		var id string
-		err := rows.Scan(&id)
-		if err != nil {
-			log.Fatalf("Failed to get unread posts: %v", err)
-			return
-		}
-		postIds = append(postIds, id)
+	list := make([]string, len(ids))
+	for i, id := range ids {
+		list[i] = strconv.Itoa(id)
 	}
-	response.ItemIDs = strings.Join(postIds, ",")
-	return response
+	return strings.Join(list, ","), nil
 }
 
-func (r *Reader) FeverSavedItemIds() (response fever.SavedResponse) {
-	postIds := make([]string, 0)
-	rows, err := r.db.Query("SELECT id FROM posts WHERE is_saved = 1")
+func (s *feverSession) FeverUnreadItemIds() (response fever.UnreadResponse) {
+	ids, err := s.reader.joinedPostIds("p.is_read = 0")
 	if err != nil {
-		log.Fatalf("Failed to get saved posts: %v", err)
+		s.fail("Failed to get unread posts: %v", err)
 		return
 	}
-	for rows.Next() {
-		var id string
-		err := rows.Scan(&id)
-		if err != nil {
-			log.Fatalf("Failed to get saved posts: %v", err)
-			return
-		}
-		postIds = append(postIds, id)
+	response.ItemIDs = ids
+	return response
+}
+
+func (s *feverSession) FeverSavedItemIds() (response fever.SavedResponse) {
+	ids, err := s.reader.joinedPostIds("p.is_saved = 1")
+	if err != nil {
+		s.fail("Failed to get saved posts: %v", err)
+		return
 	}
-	response.ItemIDs = strings.Join(postIds, ",")
+	response.ItemIDs = ids
 	return response
 }
 
-func (r *Reader) FeverMark(req *fever.MarkRequest) (response fever.MarkResponse) {
+func (s *feverSession) FeverMark(req *fever.MarkRequest) (response fever.MarkResponse) {
 	log.Println("Marking item", req.Type, req.Id, "as", req.As)
+	if s.token != nil && !s.token.Allows(ScopeWrite) {
+		s.fail("Failed to update post: %v", fmt.Errorf("token %q lacks the write scope", s.token.Name))
+		return
+	}
 	updates := make([]string, 0)
 	if req.Type == "item" {
 		switch req.As {
@@ -176,10 +257,12 @@ func (r *Reader) FeverMark(req *fever.MarkRequest) (response fever.MarkResponse)
 			updates = append(updates, "is_saved = 1")
 		case "unsaved":
 			updates = append(updates, "is_saved = 0")
+		default:
+			return
 		}
-		err := r.UpdatePost(req.Id, updates)
+		err := s.reader.UpdatePost(req.Id, updates)
 		if err != nil {
-			log.Fatalf("Failed to update post: %v", err)
+			s.fail("Failed to update post: %v", err)
 			return
 		}
 	}
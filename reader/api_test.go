@@ -0,0 +1,74 @@
+package reader
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// feverPost calls the Fever API of the server and decodes its JSON response.
+func feverPost(t *testing.T, server *httptest.Server, apiKey, query string) (int, map[string]any) {
+	t.Helper()
+	form := url.Values{"api_key": {apiKey}}
+	res, err := http.Post(server.URL+"/fever/?api&"+query, "application/x-www-form-urlencoded", strings.NewReader(form.Encode()))
+	if err != nil {
+		t.Fatalf("%s: %v", query, err)
+	}
+	defer res.Body.Close()
+	var body map[string]any
+	if err := json.NewDecoder(res.Body).Decode(&body); err != nil {
+		t.Fatalf("%s: invalid JSON response: %v", query, err)
+	}
+	return res.StatusCode, body
+}
+
+var feverQueries = []string{"groups", "feeds", "items", "items&since_id=1", "unread_item_ids", "saved_item_ids", "mark=item&as=read&id=1"}
+
+func TestFeverStorageErrors(t *testing.T) {
+	for name, breakStorage := range map[string]func(reader *Reader) error{
+		"closed database": func(reader *Reader) error {
+			return reader.db.Close()
+		},
+		"missing tables": func(reader *Reader) error {
+			for _, table := range []string{"post_states", "posts", "subscriptions", "categories"} {
+				if _, err := reader.db.Exec("DROP TABLE " + table); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	} {
+		reader := newTestReader(t)
+		server := httptest.NewServer(http.HandlerFunc(reader.FeverView))
+		apiKey := reader.config.Users[0].FeverAuthKey()
+		if status, _ := feverPost(t, server, apiKey, "groups"); status != http.StatusOK {
+			t.Fatalf("%s: groups before breaking storage: %d", name, status)
+		}
+		if err := breakStorage(reader); err != nil {
+			t.Fatal(err)
+		}
+		for _, query := range feverQueries {
+			status, body := feverPost(t, server, apiKey, query)
+			if status != http.StatusInternalServerError {
+				t.Errorf("%s: %s: status %d, want 500", name, query, status)
+			}
+			if message, _ := body["error"].(string); message == "" {
+				t.Errorf("%s: %s: no error in %v", name, query, body)
+			}
+			if body["auth"] != float64(1) {
+				t.Errorf("%s: %s: auth %v, want 1", name, query, body["auth"])
+			}
+		}
+		// The server is still up and keeps answering after the failures.
+		if status, body := feverPost(t, server, apiKey, ""); status != http.StatusOK || body["auth"] != float64(1) {
+			t.Errorf("%s: server stopped answering: %d %v", name, status, body)
+		}
+		if stats := reader.metrics.Snapshot()["fever.groups"]; stats.Errors != 1 {
+			t.Errorf("%s: fever.groups errors %d, want 1", name, stats.Errors)
+		}
+		server.Close()
+	}
+}
@@ -0,0 +1,514 @@
+package reader
+
+import (
+	"archive/zip"
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/lsongdev/feedreader/feed"
+)
+
+// archiveVersion is the version of the archive format written by
+// ExportArchive. RestoreArchive reads this and every earlier version.
+const archiveVersion = 1
+
+// Files of an archive.
+const (
+	archiveData = "archive.json"
+	archiveOPML = "feeds.opml"
+)
+
+// Archive is everything a user has: categories, subscriptions with their
+// settings, feed lists, shared feeds, and the posts of the subscribed feeds
+// with the user's read and saved state.
+type Archive struct {
+	Version    int               `json:"version"`
+	ExportedAt time.Time         `json:"exported_at"`
+	Username   string            `json:"username"`
+	Language   string            `json:"language,omitempty"`
+	Categories []ArchiveCategory `json:"categories"`
+	Feeds      []ArchiveFeed     `json:"feeds"`
+	Lists      []ArchiveList     `json:"lists"`
+	Shares     []ArchiveShare    `json:"shares"`
+	Posts      []ArchivePost     `json:"posts"`
+}
+
+type ArchiveCategory struct {
+	Name string `json:"name"`
+}
+
+type ArchiveFeed struct {
+	Type            string          `json:"type"`
+	Name            string          `json:"name"`
+	Home            string          `json:"home"`
+	Link            string          `json:"link"`
+	Category        string          `json:"category"`
+	RefreshInterval int             `json:"refresh_interval,omitempty"`
+	Disabled        bool            `json:"disabled,omitempty"`
+	Private         bool            `json:"private,omitempty"`
+	Attributes      json.RawMessage `json:"attributes,omitempty"`
+	// FetchOptions are those of a private feed, the user's own copy, without
+	// the password and headers, which may hold credentials.
+	FetchOptions json.RawMessage `json:"fetch_options,omitempty"`
+	// List is the URL of the feed list managing the subscription, and
+	// ListLink the link the list gives the feed.
+	List      string    `json:"list,omitempty"`
+	ListLink  string    `json:"list_link,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type ArchiveList struct {
+	Name     string `json:"name"`
+	URL      string `json:"url"`
+	Category string `json:"category"`
+	Dropped  string `json:"dropped"`
+}
+
+// ArchiveShare is a shared feed. Its secret is not kept, a restored share
+// gets a new one.
+type ArchiveShare struct {
+	Name      string    `json:"name"`
+	Filter    string    `json:"filter"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type ArchivePost struct {
+	// Feed is the link of the post's feed.
+	Feed      string    `json:"feed"`
+	EntryId   string    `json:"entry_id"`
+	Title     string    `json:"title"`
+	Content   string    `json:"content"`
+	Link      string    `json:"link"`
+	Author    string    `json:"author,omitempty"`
+	Published time.Time `json:"published"`
+	Read      bool      `json:"read,omitempty"`
+	Saved     bool      `json:"saved,omitempty"`
+}
+
+// RestoreReport counts what restoring an archive added. Data the user
+// already had is left as it is.
+type RestoreReport struct {
+	Version    int `json:"version"`
+	Categories int `json:"categories"`
+	Feeds      int `json:"feeds"`
+	Lists      int `json:"lists"`
+	Shares     int `json:"shares"`
+	Posts      int `json:"posts"`
+	// States is the number of posts marked read or saved.
+	States int `json:"states"`
+	// NewShares are the restored shared feeds with their new secrets, which
+	// are not stored.
+	NewShares []RestoredShare `json:"new_shares,omitempty"`
+}
+
+// RestoredShare is the new secret of a restored shared feed.
+type RestoredShare struct {
+	Name   string `json:"name"`
+	Secret string `json:"secret"`
+}
+
+// ArchiveName is the file name of the user's archive exported now.
+func (reader *Reader) ArchiveName() string {
+	return fmt.Sprintf("feedreader-%s-%s.zip", reader.user.Username, time.Now().Format("20060102"))
+}
+
+// ExportArchive writes a zip file with the user's archive as JSON and their
+// subscriptions as OPML.
+func (reader *Reader) ExportArchive(w io.Writer) error {
+	archive, err := reader.archive()
+	if err != nil {
+		return err
+	}
+	opml, err := reader.ExportOPML()
+	if err != nil {
+		return err
+	}
+	out := zip.NewWriter(w)
+	create := func(name string) (io.Writer, error) {
+		return out.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: archive.ExportedAt})
+	}
+	f, err := create(archiveData)
+	if err != nil {
+		return err
+	}
+	encoder := json.NewEncoder(f)
+	encoder.SetIndent("", "  ")
+	if err = encoder.Encode(archive); err != nil {
+		return err
+	}
+	if f, err = create(archiveOPML); err != nil {
+		return err
+	}
+	if err = feed.WriteOPML(f, opml); err != nil {
+		return err
+	}
+	return out.Close()
+}
+
+func (reader *Reader) archive() (*Archive, error) {
+	archive := &Archive{
+		Version: archiveVersion, ExportedAt: time.Now().UTC(), Username: reader.user.Username,
+		Categories: []ArchiveCategory{}, Feeds: []ArchiveFeed{}, Lists: []ArchiveList{}, Shares: []ArchiveShare{}, Posts: []ArchivePost{},
+	}
+	var language sql.NullString
+	reader.db.QueryRow("SELECT language FROM users WHERE id = ?", reader.userId()).Scan(&language)
+	archive.Language = language.String
+
+	categories, err := reader.GetCategories()
+	if err != nil {
+		return nil, err
+	}
+	for _, category := range categories {
+		archive.Categories = append(archive.Categories, ArchiveCategory{Name: category.Name})
+	}
+
+	rows, err := reader.db.Query(`
+		SELECT f.type, COALESCE(NULLIF(sub.name, ''), f.name), f.home, f.link, g.name, f.refresh_interval,
+			sub.disabled, f.owner_id = sub.user_id, CASE WHEN f.owner_id = sub.user_id THEN f.fetch_options END,
+			sub.attributes, l.url, sub.list_link, sub.created_at
+		FROM subscriptions sub
+		JOIN feeds f ON f.id = sub.feed_id
+		JOIN categories g ON g.id = sub.category_id
+		LEFT JOIN feed_lists l ON l.id = sub.list_id
+		WHERE sub.user_id = ?
+		ORDER BY sub.id`, reader.userId())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var item ArchiveFeed
+		var feedType, home, options, attributes, list, listLink sql.NullString
+		var interval sql.NullInt64
+		err := rows.Scan(&feedType, &item.Name, &home, &item.Link, &item.Category, &interval,
+			&item.Disabled, &item.Private, &options, &attributes, &list, &listLink, &item.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		item.Type, item.Home, item.RefreshInterval = feedType.String, home.String, int(interval.Int64)
+		item.List, item.ListLink = list.String, listLink.String
+		if options := parseFetchOptions(options.String); options != nil {
+			options.Password, options.Headers = "", nil
+			if item.FetchOptions, err = json.Marshal(options); err != nil {
+				return nil, err
+			}
+		}
+		if attributes.String != "" {
+			item.Attributes = json.RawMessage(attributes.String)
+		}
+		archive.Feeds = append(archive.Feeds, item)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	lists, err := reader.GetLists()
+	if err != nil {
+		return nil, err
+	}
+	for _, list := range lists {
+		archive.Lists = append(archive.Lists, ArchiveList{
+			Name: list.Name, URL: list.URL, Category: list.Category.Name, Dropped: list.Dropped,
+		})
+	}
+
+	shares, err := reader.db.Query(`
+		SELECT name, filter, created_at FROM shares WHERE username = ? ORDER BY id
+	`, reader.user.Username)
+	if err != nil {
+		return nil, err
+	}
+	defer shares.Close()
+	for shares.Next() {
+		var share ArchiveShare
+		if err := shares.Scan(&share.Name, &share.Filter, &share.CreatedAt); err != nil {
+			return nil, err
+		}
+		archive.Shares = append(archive.Shares, share)
+	}
+	if err = shares.Err(); err != nil {
+		return nil, err
+	}
+
+	posts, err := reader.db.Query(`
+		SELECT f.link, p.entry_id, p.title, p.content, p.link, p.author, p.pub_date,
+			COALESCE(st.is_read, 0), COALESCE(st.is_saved, 0)
+		FROM posts p
+		JOIN subscriptions sub ON sub.feed_id = p.feed_id AND sub.user_id = ?
+		JOIN feeds f ON f.id = p.feed_id
+		LEFT JOIN post_states st ON st.post_id = p.id AND st.user_id = sub.user_id
+		ORDER BY p.id`, reader.userId())
+	if err != nil {
+		return nil, err
+	}
+	defer posts.Close()
+	for posts.Next() {
+		var post ArchivePost
+		var entryId, title, content, link, author sql.NullString
+		var published sql.NullTime
+		err := posts.Scan(&post.Feed, &entryId, &title, &content, &link, &author, &published, &post.Read, &post.Saved)
+		if err != nil {
+			return nil, err
+		}
+		post.EntryId, post.Title, post.Content = entryId.String, title.String, content.String
+		post.Link, post.Author, post.Published = link.String, author.String, published.Time
+		archive.Posts = append(archive.Posts, post)
+	}
+	return archive, posts.Err()
+}
+
+// RestoreArchive adds the content of an archive, or of its archive.json, to
+// the user's data. It can be restored into another instance or user, and
+// restoring it twice adds nothing the second time. Posts the feeds do not
+// have go into paused private copies, see ownFeed, so a shared feed is never
+// claimed, and their HTML is sanitized. Shared feeds get new secrets,
+// returned in the report.
+func (reader *Reader) RestoreArchive(data []byte) (*RestoreReport, error) {
+	data, err := archiveFile(data)
+	if err != nil {
+		return nil, err
+	}
+	var archive Archive
+	if err = json.Unmarshal(data, &archive); err != nil {
+		return nil, fmt.Errorf("invalid archive: %w", err)
+	}
+	if archive.Version < 1 || archive.Version > archiveVersion {
+		return nil, fmt.Errorf("unsupported archive version %d, this version reads up to %d", archive.Version, archiveVersion)
+	}
+	defaultCategoryId, err := reader.DefaultCategoryId()
+	if err != nil {
+		return nil, err
+	}
+	report := &RestoreReport{Version: archive.Version}
+	tx, err := reader.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	count := func(res sql.Result, err error) (int, error) {
+		if err != nil {
+			return 0, err
+		}
+		n, err := res.RowsAffected()
+		return int(n), err
+	}
+
+	if archive.Language != "" {
+		_, err = tx.Exec("UPDATE users SET language = ? WHERE id = ? AND language IS NULL", archive.Language, reader.userId())
+		if err != nil {
+			return nil, err
+		}
+	}
+	for _, category := range archive.Categories {
+		n, err := count(tx.Exec(`
+			INSERT OR IGNORE INTO categories (user_id, name, position)
+			SELECT ?, ?, COALESCE(MAX(position), 0) + 1 FROM categories WHERE user_id = ?
+		`, reader.userId(), category.Name, reader.userId()))
+		if err != nil {
+			return nil, err
+		}
+		report.Categories += n
+	}
+	categories := map[string]int{}
+	if err = scanIds(tx, categories, "SELECT name, id FROM categories WHERE user_id = ?", reader.userId()); err != nil {
+		return nil, err
+	}
+	categoryId := func(name string) int {
+		if id, ok := categories[name]; ok {
+			return id
+		}
+		return defaultCategoryId
+	}
+
+	for _, list := range archive.Lists {
+		n, err := count(tx.Exec(`
+			INSERT OR IGNORE INTO feed_lists (user_id, name, url, category_id, dropped) VALUES (?, ?, ?, ?, ?)
+		`, reader.userId(), list.Name, list.URL, categoryId(list.Category), list.Dropped))
+		if err != nil {
+			return nil, err
+		}
+		report.Lists += n
+	}
+	lists := map[string]int{}
+	if err = scanIds(tx, lists, "SELECT url, id FROM feed_lists WHERE user_id = ?", reader.userId()); err != nil {
+		return nil, err
+	}
+
+	feeds := map[string]int{}
+	for _, item := range archive.Feeds {
+		var id int
+		err := tx.QueryRow(fmt.Sprintf("SELECT id FROM %s WHERE link = ?", reader.feedsTable()), item.Link).Scan(&id)
+		if err == nil {
+			feeds[item.Link] = id
+			continue
+		}
+		if err != sql.ErrNoRows {
+			return nil, err
+		}
+		if id, err = reader.restoreFeed(tx, item); err != nil {
+			return nil, err
+		}
+		feeds[item.Link] = id
+		var listId any
+		if id, ok := lists[item.List]; ok {
+			listId = id
+		}
+		n, err := count(tx.Exec(`
+			INSERT OR IGNORE INTO subscriptions (user_id, feed_id, category_id, name, disabled, attributes, list_id, list_link, created_at)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+		`, reader.userId(), id, categoryId(item.Category), item.Name, item.Disabled, nullJSON(item.Attributes),
+			listId, nullString(item.ListLink), item.CreatedAt))
+		if err != nil {
+			return nil, err
+		}
+		report.Feeds += n
+	}
+
+	for _, share := range archive.Shares {
+		secret, err := newShareSecret()
+		if err != nil {
+			return nil, err
+		}
+		n, err := count(tx.Exec(`
+			INSERT OR IGNORE INTO shares (name, username, token_hash, filter, created_at) VALUES (?, ?, ?, ?, ?)
+		`, share.Name, reader.user.Username, hashToken(secret), share.Filter, share.CreatedAt))
+		if err != nil {
+			return nil, err
+		}
+		if n > 0 {
+			report.NewShares = append(report.NewShares, RestoredShare{Name: share.Name, Secret: secret})
+		}
+		report.Shares += n
+	}
+
+	targets := map[string]postTarget{}
+	for _, post := range archive.Posts {
+		feedId, ok := feeds[post.Feed]
+		if !ok {
+			continue
+		}
+		target, ok := targets[post.Feed]
+		if !ok {
+			if target, _, err = reader.ownFeed(tx, feedId); err != nil {
+				return nil, err
+			}
+			targets[post.Feed] = target
+		}
+		var postId int
+		created := false
+		if target.shared != 0 {
+			postId, err = findPost(tx, target.shared, post.EntryId, post.Link)
+		}
+		if target.shared == 0 || err == sql.ErrNoRows {
+			content := feed.SanitizeHTML(post.Content)
+			postId, created, err = storePost(tx, target.id, post.EntryId, post.Title, content, post.Link, post.Author, post.Published)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if created {
+			report.Posts++
+		}
+		changed, err := reader.storePostState(tx, postId, post.Read, post.Saved)
+		if err != nil {
+			return nil, err
+		}
+		if changed {
+			report.States++
+		}
+	}
+	return report, tx.Commit()
+}
+
+// restoreFeed returns the feed an archived subscription the user does not
+// have is restored to. A private feed gets a private row with its settings,
+// other feeds the shared row of their link, whose settings are never taken
+// from an archive.
+func (reader *Reader) restoreFeed(tx *sql.Tx, item ArchiveFeed) (id int, err error) {
+	var owner, interval int
+	var options any
+	if item.Private || len(item.FetchOptions) > 0 {
+		owner, interval = reader.userId(), max(item.RefreshInterval, 0)
+		if parsed := parseFetchOptions(string(item.FetchOptions)); parsed != nil {
+			parsed.Timeout = min(max(parsed.Timeout, 0), feed.MaxTimeout)
+			data, _ := json.Marshal(parsed)
+			options = string(data)
+		}
+	}
+	err = tx.QueryRow("SELECT id FROM feeds WHERE link = ? AND owner_id = ? ORDER BY id LIMIT 1", item.Link, owner).Scan(&id)
+	if err == sql.ErrNoRows {
+		err = tx.QueryRow(`
+			INSERT INTO feeds (type, name, home, link, refresh_interval, fetch_options, owner_id) VALUES (?, ?, ?, ?, ?, ?, ?) RETURNING id
+		`, item.Type, item.Name, item.Home, item.Link, interval, options, owner).Scan(&id)
+	}
+	return
+}
+
+// archiveFile returns archive.json from an archive, or data itself when it
+// is not a zip file.
+func archiveFile(data []byte) ([]byte, error) {
+	if !bytes.HasPrefix(data, []byte("PK\x03\x04")) {
+		return data, nil
+	}
+	archive, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, err
+	}
+	f, err := archive.Open(archiveData)
+	if err != nil {
+		return nil, errors.New("not a feedreader archive, " + archiveData + " is missing")
+	}
+	defer f.Close()
+	return io.ReadAll(f)
+}
+
+// scanIds fills ids with the name and id pairs a query selects.
+func scanIds(tx *sql.Tx, ids map[string]int, query string, args ...any) error {
+	rows, err := tx.Query(query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name string
+		var id int
+		if err := rows.Scan(&name, &id); err != nil {
+			return err
+		}
+		ids[name] = id
+	}
+	return rows.Err()
+}
+
+func nullJSON(data json.RawMessage) any {
+	if len(data) == 0 {
+		return nil
+	}
+	return string(data)
+}
+
+func nullString(str string) any {
+	if str == "" {
+		return nil
+	}
+	return str
+}
+
+// ArchiveView downloads the user's archive.
+func (reader *Reader) ArchiveView(w http.ResponseWriter, r *http.Request) {
+	reader = reader.forRequest(r)
+	var buf bytes.Buffer
+	if err := reader.ExportArchive(&buf); err != nil {
+		reader.Error(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", reader.ArchiveName()))
+	w.Write(buf.Bytes())
+}
@@ -0,0 +1,109 @@
+package reader
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/lsongdev/feedreader/feed"
+)
+
+func TestArchiveLeavesOutCredentials(t *testing.T) {
+	reader := newTestReader(t)
+	server, _ := serveTestFeed(t)
+	admin := reader.As(&reader.config.Users[0])
+	categoryId, err := admin.DefaultCategoryId()
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, err := admin.CreateFeed("rss", "Test", "", server.URL, categoryId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	options := &feed.FetchOptions{Username: "me", Password: "hunter2", Headers: map[string]string{"X-Api-Key": "key"}, UserAgent: "Bot"}
+	if _, err := admin.UpdateFeed(strconv.Itoa(id), FeedUpdate{FetchOptions: options}); err != nil {
+		t.Fatal(err)
+	}
+	secret, _, err := admin.CreateShare("Team", url.Values{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	archive, err := admin.archive()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := json.Marshal(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, leaked := range []string{"hunter2", "X-Api-Key", hashToken(secret)} {
+		if strings.Contains(string(data), leaked) {
+			t.Errorf("the archive holds %q: %s", leaked, data)
+		}
+	}
+	if len(archive.Feeds) != 1 || !archive.Feeds[0].Private || !strings.Contains(string(archive.Feeds[0].FetchOptions), "Bot") {
+		t.Errorf("feeds: %+v", archive.Feeds)
+	}
+}
+
+func TestRestoreArchiveKeepsPostsPrivate(t *testing.T) {
+	reader := newTestReader(t)
+	server, _ := serveTestFeed(t)
+	bob, err := reader.ProvisionUser("bob", "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	admin, other := reader.As(&reader.config.Users[0]), reader.As(bob)
+	categoryId, err := admin.DefaultCategoryId()
+	if err != nil {
+		t.Fatal(err)
+	}
+	shared, err := admin.CreateFeed("rss", "Test", "", server.URL, categoryId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	archive := fmt.Sprintf(`{"version": 1,
+		"feeds": [{"name": "Test", "link": %[1]q}, {"name": "Other", "link": "https://example.org/feed"}],
+		"shares": [{"name": "Team", "token_hash": "%[2]s", "filter": ""}],
+		"posts": [{"feed": %[1]q, "entry_id": "1", "title": "Hi", "link": "https://example.com/1",
+			"content": "<img src=x onerror=alert(1)><script>alert(2)</script>", "saved": true},
+			{"feed": "https://example.org/feed", "entry_id": "2", "title": "Alone", "link": "https://example.org/2"}]
+	}`, server.URL, hashToken("known"))
+	report, err := other.RestoreArchive([]byte(archive))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Posts != 2 || report.States != 1 {
+		t.Errorf("report: %+v", report)
+	}
+	// Feeds only bob follows stay shared too, so they are still fetched once.
+	var private int
+	if err := reader.db.QueryRow(`SELECT COUNT(*) FROM feeds WHERE owner_id != 0 AND link NOT LIKE 'history:%'`).Scan(&private); err != nil || private != 0 {
+		t.Errorf("%d feeds taken private: %v", private, err)
+	}
+	var posts int
+	reader.db.QueryRow("SELECT COUNT(*) FROM posts WHERE feed_id = ?", shared).Scan(&posts)
+	if posts != 0 {
+		t.Errorf("a restore added %d posts to a feed admin follows", posts)
+	}
+	var content string
+	var owner int
+	err = reader.db.QueryRow(`
+		SELECT p.content, f.owner_id FROM posts p JOIN feeds f ON f.id = p.feed_id WHERE p.link = ?
+	`, "https://example.com/1").Scan(&content, &owner)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if owner != bob.Id || content != `<img src="x">` {
+		t.Errorf("restored post of owner %d: %q", owner, content)
+	}
+	if reader.AuthenticateShare("known") != nil {
+		t.Error("a restored share kept the archived secret")
+	}
+	if len(report.NewShares) != 1 || reader.AuthenticateShare(report.NewShares[0].Secret) == nil {
+		t.Errorf("new shares: %+v", report.NewShares)
+	}
+}
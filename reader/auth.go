@@ -0,0 +1,370 @@
+package reader
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+const sessionCookie = "session"
+
+// publicPaths are served without a login, either because they are part of
+// the login flow or because the API and shares authenticate requests themselves.
+var publicPaths = []string{
+	"/login",
+	"/oidc/",
+	"/share/",
+	"/fever/",
+	"/api/v1/",
+	"/accounts/ClientLogin",
+	"/reader/api/0/",
+	"/index.php/apps/news/api/",
+}
+
+// HashPassword returns a bcrypt hash suitable for the password_hash config field.
+func HashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	return string(hash), err
+}
+
+// CheckPassword compares a password against the user's hash, falling back
+// to the legacy plaintext password field.
+func (user *User) CheckPassword(password string) bool {
+	if user.PasswordHash != "" {
+		return bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password)) == nil
+	}
+	return user.Password != "" && user.Password == password
+}
+
+// Authenticator is an auth backend that identifies the user making a
+// request, or returns nil when the request has no credentials for it.
+type Authenticator interface {
+	Authenticate(r *http.Request) *User
+}
+
+// AuthenticatorFunc adapts a function to the Authenticator interface.
+type AuthenticatorFunc func(r *http.Request) *User
+
+func (f AuthenticatorFunc) Authenticate(r *http.Request) *User {
+	return f(r)
+}
+
+// authenticators lists the enabled auth backends in the order they are tried.
+func (reader *Reader) authenticators() []Authenticator {
+	backends := []Authenticator{AuthenticatorFunc(reader.cookieUser)}
+	if reader.proxyAuth != nil {
+		backends = append(backends, reader.proxyAuth)
+	}
+	return append(backends, AuthenticatorFunc(reader.BasicAuthUser))
+}
+
+// FindUser returns the configured user with the given name, or a user
+// provisioned by an auth backend.
+func (reader *Reader) FindUser(username string) *User {
+	for i, user := range reader.config.Users {
+		if user.Username == username {
+			return &reader.config.Users[i]
+		}
+	}
+	user := &User{Username: username}
+	err := reader.db.QueryRow(`
+		SELECT id FROM users WHERE username = ? AND provider != ''
+	`, username).Scan(&user.Id)
+	if err != nil {
+		return nil
+	}
+	return user
+}
+
+// ProvisionUser returns the named user, creating it on first login through
+// an external auth backend such as a proxy or OpenID Connect.
+func (reader *Reader) ProvisionUser(username, provider string) (*User, error) {
+	if user := reader.FindUser(username); user != nil {
+		return user, nil
+	}
+	return reader.createUser(username, provider)
+}
+
+// createUser adds a user for an external auth backend, and fails when the
+// name is already taken.
+func (reader *Reader) createUser(username, provider string) (*User, error) {
+	res, err := reader.db.Exec(`
+		INSERT OR IGNORE INTO users (username, provider) VALUES (?, ?)
+	`, username, provider)
+	if err != nil {
+		return nil, err
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return nil, fmt.Errorf("user %q already exists", username)
+	}
+	user := reader.FindUser(username)
+	if user == nil {
+		return nil, fmt.Errorf("user %q cannot be provisioned", username)
+	}
+	log.Printf("Provisioned user %q from %s", username, provider)
+	if _, err := reader.As(user).DefaultCategoryId(); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// Login checks credentials and returns the matching user.
+func (reader *Reader) Login(username, password string) *User {
+	user := reader.FindUser(username)
+	if user == nil || !user.CheckPassword(password) {
+		return nil
+	}
+	return user
+}
+
+// CreateSession starts a session for the user and returns its secret.
+func (reader *Reader) CreateSession(username string, ttl time.Duration) (secret string, err error) {
+	buf := make([]byte, 32)
+	if _, err = rand.Read(buf); err != nil {
+		return
+	}
+	secret = hex.EncodeToString(buf)
+	_, err = reader.db.Exec(`
+		INSERT INTO sessions (token_hash, username, expires_at) VALUES (?, ?, ?)
+	`, hashToken(secret), username, time.Now().Add(ttl))
+	// Sweep expired sessions while we are here.
+	reader.db.Exec("DELETE FROM sessions WHERE expires_at < ?", time.Now())
+	return
+}
+
+// SessionUser returns the user owning an unexpired session.
+func (reader *Reader) SessionUser(secret string) *User {
+	var username string
+	var expires time.Time
+	err := reader.db.QueryRow(`
+		SELECT username, expires_at FROM sessions WHERE token_hash = ?
+	`, hashToken(secret)).Scan(&username, &expires)
+	if err != nil || time.Now().After(expires) {
+		return nil
+	}
+	return reader.FindUser(username)
+}
+
+// DeleteSession ends a session.
+func (reader *Reader) DeleteSession(secret string) error {
+	_, err := reader.db.Exec("DELETE FROM sessions WHERE token_hash = ?", hashToken(secret))
+	return err
+}
+
+func (reader *Reader) cookieUser(r *http.Request) *User {
+	cookie, err := r.Cookie(sessionCookie)
+	if err != nil {
+		return nil
+	}
+	return reader.SessionUser(cookie.Value)
+}
+
+// CurrentUser returns the user authenticated by the first auth backend that
+// recognises the request: session cookie, trusted proxy header or Basic Auth.
+func (reader *Reader) CurrentUser(r *http.Request) *User {
+	for _, backend := range reader.authenticators() {
+		if user := backend.Authenticate(r); user != nil {
+			return user
+		}
+	}
+	return nil
+}
+
+// matchPath reports whether path equals one of the patterns, or falls under
+// one ending in a slash.
+func matchPath(patterns []string, path string) bool {
+	for _, pattern := range patterns {
+		if path == pattern || (strings.HasSuffix(pattern, "/") && strings.HasPrefix(path, pattern)) {
+			return true
+		}
+	}
+	return false
+}
+
+// RequireLogin protects every route except publicPaths. Browsers are sent to
+// the login page, other clients get a 401.
+func (reader *Reader) RequireLogin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if matchPath(publicPaths, r.URL.Path) || matchPath(staticPaths, r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if user := reader.CurrentUser(r); user != nil {
+			next.ServeHTTP(w, withUser(r, user))
+			return
+		}
+		if secret, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+			if token := reader.AuthenticateToken(strings.TrimSpace(secret)); token != nil {
+				if user := reader.FindUser(token.Username); user != nil {
+					if scope := bearerScope(r); !token.Allows(scope) {
+						http.Error(w, fmt.Sprintf("Forbidden: token %q lacks the %s scope", token.Name, scope), http.StatusForbidden)
+						return
+					}
+					r = r.WithContext(context.WithValue(r.Context(), tokenKey{}, token))
+					next.ServeHTTP(w, withUser(r, user))
+					return
+				}
+			}
+		}
+		if r.Method == "GET" && strings.Contains(r.Header.Get("Accept"), "text/html") {
+			http.Redirect(w, r, "/login?next="+url.QueryEscape(r.URL.RequestURI()), http.StatusFound)
+			return
+		}
+		w.Header().Set("WWW-Authenticate", "Basic realm=Restricted")
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+	})
+}
+
+// bearerScope is the scope an API token needs for a web request: read to
+// look, write to change anything, and admin for the settings page, which
+// manages tokens and shared feeds.
+func bearerScope(r *http.Request) string {
+	switch {
+	case r.URL.Path == "/settings":
+		return ScopeAdmin
+	case isSafeMethod(r.Method):
+		return ScopeRead
+	}
+	return ScopeWrite
+}
+
+// safeRedirect only allows local redirect targets after login.
+func safeRedirect(next string) string {
+	if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") || strings.HasPrefix(next, "/\\") {
+		return "/"
+	}
+	return next
+}
+
+func (reader *Reader) isSecureRequest(r *http.Request) bool {
+	if r.TLS != nil || strings.HasPrefix(reader.config.BaseURL, "https://") {
+		return true
+	}
+	return sentFrom(r, reader.proxies) && r.Header.Get("X-Forwarded-Proto") == "https"
+}
+
+// baseURL is the configured base URL, or else the scheme and host the
+// request was made to.
+func (reader *Reader) baseURL(r *http.Request) string {
+	if reader.config.BaseURL != "" {
+		return strings.TrimSuffix(reader.config.BaseURL, "/")
+	}
+	if reader.isSecureRequest(r) {
+		return "https://" + r.Host
+	}
+	return "http://" + r.Host
+}
+
+// LoginView shows the login form and starts a session on success.
+func (reader *Reader) LoginView(w http.ResponseWriter, r *http.Request) {
+	reader = reader.forRequest(r)
+	next := safeRedirect(r.FormValue("next"))
+	if r.Method != "POST" {
+		reader.Render(w, "login", H{"next": next, "oidc": reader.oidcName()})
+		return
+	}
+	username := r.FormValue("username")
+	user := reader.Login(username, r.FormValue("password"))
+	if user == nil {
+		log.Println("Login failed for", username)
+		w.WriteHeader(http.StatusUnauthorized)
+		reader.Render(w, "login", H{
+			"next":     next,
+			"username": username,
+			"oidc":     reader.oidcName(),
+			"error":    "login.failed",
+		})
+		return
+	}
+	if err := reader.startSession(w, r, user); err != nil {
+		reader.Error(w, err)
+		return
+	}
+	http.Redirect(w, r, next, http.StatusFound)
+}
+
+// startSession logs the user in by setting a session cookie.
+func (reader *Reader) startSession(w http.ResponseWriter, r *http.Request, user *User) error {
+	ttl := reader.config.SessionDuration()
+	secret, err := reader.CreateSession(user.Username, ttl)
+	if err != nil {
+		return err
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    secret,
+		Path:     "/",
+		Expires:  time.Now().Add(ttl),
+		HttpOnly: true,
+		Secure:   reader.isSecureRequest(r),
+		SameSite: http.SameSiteLaxMode,
+	})
+	return nil
+}
+
+// LogoutView ends the current session. It only accepts POST so other sites
+// cannot log the user out.
+func (reader *Reader) LogoutView(w http.ResponseWriter, r *http.Request) {
+	if !requireMethod(w, r, "POST") {
+		return
+	}
+	if cookie, err := r.Cookie(sessionCookie); err == nil {
+		if err := reader.DeleteSession(cookie.Value); err != nil {
+			reader.Error(w, err)
+			return
+		}
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   reader.isSecureRequest(r),
+		SameSite: http.SameSiteLaxMode,
+	})
+	// Drop the posts cached for offline reading along with the service worker.
+	w.Header().Set("Clear-Site-Data", `"cache", "storage"`)
+	http.Redirect(w, r, "/login", http.StatusFound)
+}
+
+// parseDuration parses a config duration, falling back to the default.
+func parseDuration(value string, fallback time.Duration) time.Duration {
+	if value == "" {
+		return fallback
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		log.Printf("Invalid duration %q, using %s", value, fallback)
+		return fallback
+	}
+	return d
+}
+
+// SessionDuration returns how long login sessions last.
+func (conf *Config) SessionDuration() time.Duration {
+	return parseDuration(conf.SessionTTL, 30*24*time.Hour)
+}
+
+// AuthConfig enables auth backends besides passwords and API tokens.
+type AuthConfig struct {
+	Proxy *ProxyAuthConfig `json:"proxy" yaml:"proxy"`
+	OIDC  *OIDCConfig      `json:"oidc" yaml:"oidc"`
+}
+
+// checkUsers warns about users that still have plaintext passwords.
+func (conf *Config) checkUsers() {
+	for _, user := range conf.Users {
+		if user.PasswordHash == "" && user.Password != "" {
+			log.Printf("User %q has a plaintext password, generate a password_hash with `feedreader passwd`", user.Username)
+		}
+	}
+}
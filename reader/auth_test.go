@@ -0,0 +1,139 @@
+package reader
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// webPaths are the routes behind RequireLogin.
+var webPaths = []string{
+	"/", "/logout", "/new", "/posts", "/posts/mark", "/read", "/read.json", "/events", "/offline", "/proxy",
+	"/feeds", "/feeds/edit", "/refresh", "/import", "/lists", "/categories", "/settings",
+	"/rss.xml", "/atom.xml", "/feed.json", "/opml.xml", "/archive.zip", "/feeds.json", "/posts.json", "/metrics.json",
+	"/api/v1/categories", "/api/v1/feeds", "/api/v1/feeds/move", "/api/v1/refresh", "/api/v1/posts/bulk",
+	"/api/v1/opml", "/api/v1/history", "/api/v1/archive", "/api/v1/tokens",
+}
+
+// bearerRequest makes a request with an API token, and the CSRF cookie and
+// header a non-browser client can set on its own.
+func bearerRequest(handler http.Handler, method, path, secret string, form url.Values) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, path, strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.Header.Set("Authorization", "Bearer "+secret)
+	csrf := strings.Repeat("a", 64)
+	r.AddCookie(&http.Cookie{Name: csrfCookie, Value: csrf})
+	r.Header.Set(csrfHeader, csrf)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	return w
+}
+
+func TestReadTokenCannotPost(t *testing.T) {
+	reader := newTestReader(t)
+	handler := reader.Handler()
+	secret, _, err := reader.CreateToken("admin", "reader", []string{ScopeRead})
+	if err != nil {
+		t.Fatal(err)
+	}
+	form := url.Values{"action": {"create"}, "name": {"escalated"}, "scopes": {ScopeAdmin}}
+	for _, path := range webPaths {
+		if w := bearerRequest(handler, "POST", path, secret, form); w.Code != http.StatusForbidden {
+			t.Errorf("POST %s with a read token: %d, want 403", path, w.Code)
+		}
+	}
+	if tokens, _ := reader.GetTokens(); len(tokens) != 1 {
+		t.Errorf("a read token created %d tokens", len(tokens)-1)
+	}
+	if w := bearerRequest(handler, "GET", "/feeds.json", secret, nil); w.Code != http.StatusOK {
+		t.Errorf("GET /feeds.json with a read token: %d, want 200", w.Code)
+	}
+}
+
+func TestWriteTokenCanPost(t *testing.T) {
+	reader := newTestReader(t)
+	handler := reader.Handler()
+	_, postId := addTestPost(t, reader.As(&reader.config.Users[0]))
+	secret, _, err := reader.CreateToken("admin", "writer", []string{ScopeWrite})
+	if err != nil {
+		t.Fatal(err)
+	}
+	form := url.Values{"action": {"read"}, "id": {strconv.Itoa(postId)}}
+	if w := bearerRequest(handler, "POST", "/posts/mark", secret, form); w.Code != http.StatusFound {
+		t.Fatalf("POST /posts/mark with a write token: %d, want a redirect", w.Code)
+	}
+	var read bool
+	if err := reader.db.QueryRow(`SELECT is_read FROM post_states WHERE post_id = ?`, postId).Scan(&read); err != nil {
+		t.Fatal(err)
+	}
+	if !read {
+		t.Error("POST /posts/mark with a write token left the post unread")
+	}
+	if w := bearerRequest(handler, "GET", "/categories", secret, nil); w.Code != http.StatusOK {
+		t.Errorf("GET /categories with a write token: %d, want 200", w.Code)
+	}
+}
+
+func TestReadTokenDoesNotMarkRead(t *testing.T) {
+	reader := newTestReader(t)
+	handler := reader.Handler()
+	_, postId := addTestPost(t, reader.As(&reader.config.Users[0]))
+	secret, _, err := reader.CreateToken("admin", "reader", []string{ScopeRead})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{"/posts?id=", "/read.json?id="} {
+		if w := bearerRequest(handler, "GET", path+strconv.Itoa(postId), secret, nil); w.Code != http.StatusOK {
+			t.Fatalf("GET %s with a read token: %d, want 200", path, w.Code)
+		}
+	}
+	var read int
+	reader.db.QueryRow(`SELECT COUNT(*) FROM post_states WHERE post_id = ? AND is_read`, postId).Scan(&read)
+	if read != 0 {
+		t.Error("a read token marked a post read")
+	}
+}
+
+func TestSettingsNeedAdminToken(t *testing.T) {
+	reader := newTestReader(t)
+	handler := reader.Handler()
+	write, _, err := reader.CreateToken("admin", "writer", []string{ScopeWrite})
+	if err != nil {
+		t.Fatal(err)
+	}
+	admin, _, err := reader.CreateToken("admin", "admin", []string{ScopeAdmin})
+	if err != nil {
+		t.Fatal(err)
+	}
+	form := url.Values{"action": {"create"}, "name": {"escalated"}, "scopes": {ScopeAdmin}}
+	if w := bearerRequest(handler, "GET", "/settings", write, nil); w.Code != http.StatusForbidden {
+		t.Errorf("GET /settings with a write token: %d, want 403", w.Code)
+	}
+	if w := bearerRequest(handler, "POST", "/settings", write, form); w.Code != http.StatusForbidden {
+		t.Errorf("POST /settings with a write token: %d, want 403", w.Code)
+	}
+	if w := bearerRequest(handler, "POST", "/settings", admin, form); w.Code != http.StatusOK {
+		t.Errorf("POST /settings with an admin token: %d, want 200", w.Code)
+	}
+}
+
+func TestLoginPageIsTranslated(t *testing.T) {
+	reader := newTestReader(t)
+	form := url.Values{"username": {"admin"}, "password": {"wrong"}}
+	r := httptest.NewRequest("POST", "/login", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.Header.Set("Accept-Language", "zh-CN")
+	w := httptest.NewRecorder()
+	reader.LoginView(w, r)
+	body := w.Body.String()
+	if w.Code != http.StatusUnauthorized || !strings.Contains(body, "用户名或密码错误") {
+		t.Errorf("login error: %d %s", w.Code, body)
+	}
+	// Pages embed the messages of their scripts, not the whole catalog.
+	if !strings.Contains(body, `"action.undo"`) || strings.Contains(body, `"login.title"`) {
+		t.Errorf("embedded messages: %s", body)
+	}
+}
@@ -0,0 +1,99 @@
+package reader
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// BackupConfig schedules copies of the database, taken while the server
+// runs.
+type BackupConfig struct {
+	// Interval between backups, no backups are scheduled when empty.
+	Interval string `json:"interval" yaml:"interval"`
+	// Dir holds the backups, "backups" in the data directory by default.
+	Dir string `json:"dir" yaml:"dir"`
+	// Keep is how many backups are kept, 7 by default.
+	Keep int `json:"keep" yaml:"keep"`
+}
+
+// BackupDir returns the directory backups are written to.
+func (conf *Config) BackupDir() string {
+	if conf.Backup.Dir == "" {
+		return filepath.Join(conf.Dir, "backups")
+	}
+	if filepath.IsAbs(conf.Backup.Dir) {
+		return conf.Backup.Dir
+	}
+	return filepath.Join(conf.Dir, conf.Backup.Dir)
+}
+
+// BackupKeep returns how many backups are kept.
+func (conf *Config) BackupKeep() int {
+	if conf.Backup.Keep <= 0 {
+		return 7
+	}
+	return conf.Backup.Keep
+}
+
+// Backup writes a consistent copy of the database to the backup directory
+// with VACUUM INTO, then deletes the oldest backups beyond the ones kept.
+func (reader *Reader) Backup() (file string, err error) {
+	dir := reader.config.BackupDir()
+	if err = os.MkdirAll(dir, 0755); err != nil {
+		return
+	}
+	file = filepath.Join(dir, fmt.Sprintf("reader-%s.db", time.Now().UTC().Format("20060102T150405Z")))
+	if _, err = reader.db.Exec("VACUUM INTO ?", file); err != nil {
+		return
+	}
+	return file, reader.pruneBackups()
+}
+
+// backups lists the backup files, oldest first.
+func (reader *Reader) backups() ([]string, error) {
+	files, err := filepath.Glob(filepath.Join(reader.config.BackupDir(), "reader-*.db"))
+	sort.Strings(files)
+	return files, err
+}
+
+func (reader *Reader) pruneBackups() error {
+	files, err := reader.backups()
+	if err != nil {
+		return err
+	}
+	for len(files) > reader.config.BackupKeep() {
+		if err = os.Remove(files[0]); err != nil {
+			return err
+		}
+		files = files[1:]
+	}
+	return nil
+}
+
+// backupPeriodically backs up the database every backup interval, counted
+// from the latest backup so restarts do not delay or repeat it.
+func (reader *Reader) backupPeriodically(interval time.Duration) {
+	for {
+		var last time.Time
+		if files, _ := reader.backups(); len(files) > 0 {
+			if info, err := os.Stat(files[len(files)-1]); err == nil {
+				last = info.ModTime()
+			}
+		}
+		if wait := time.Until(last.Add(interval)); wait > 0 {
+			time.Sleep(wait)
+			continue
+		}
+		file, err := reader.Backup()
+		if err != nil {
+			log.Println("Error backing up the database:", err)
+			time.Sleep(interval)
+			continue
+		}
+		log.Println("Backed up the database to", file)
+	}
+}
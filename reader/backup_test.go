@@ -0,0 +1,20 @@
+package reader
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestBackup(t *testing.T) {
+	reader := newTestReader(t)
+	// A quote in the path must not break the VACUUM INTO statement.
+	reader.config.Backup.Dir = filepath.Join(t.TempDir(), "it's")
+	file, err := reader.Backup()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info, err := os.Stat(file); err != nil || info.Size() == 0 {
+		t.Errorf("no backup written to %s: %v", file, err)
+	}
+}
@@ -0,0 +1,136 @@
+package reader
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const (
+	csrfCookie = "csrf"
+	csrfField  = "csrf_token"
+	csrfHeader = "X-CSRF-Token"
+)
+
+// csrfExemptPaths authenticate every request with an explicit credential
+// instead of a cookie, so they do not need a CSRF token.
+var csrfExemptPaths = []string{
+	"/fever/",
+	"/api/v1/",
+	"/accounts/ClientLogin",
+	"/reader/api/0/",
+	"/index.php/apps/news/api/",
+	"/share/",
+}
+
+type csrfKey struct{}
+
+func isSafeMethod(method string) bool {
+	return method == "GET" || method == "HEAD" || method == "OPTIONS"
+}
+
+// hasAmbientCredentials reports whether the browser attached credentials on
+// its own, which is what a forged cross-site request relies on.
+func (reader *Reader) hasAmbientCredentials(r *http.Request) bool {
+	if _, err := r.Cookie(sessionCookie); err == nil {
+		return true
+	}
+	if reader.proxyAuth != nil && reader.proxyAuth.username(r) != "" {
+		return true
+	}
+	_, _, ok := r.BasicAuth()
+	return ok
+}
+
+// isCrossOrigin uses Sec-Fetch-Site, or Origin for older browsers, to detect
+// requests started by another site.
+func isCrossOrigin(r *http.Request) bool {
+	switch r.Header.Get("Sec-Fetch-Site") {
+	case "same-origin", "none":
+		return false
+	case "":
+	default:
+		return true
+	}
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return false
+	}
+	u, err := url.Parse(origin)
+	return err != nil || u.Host != r.Host
+}
+
+// csrfToken returns the request's CSRF token, issuing a new cookie when
+// the browser does not have one yet.
+func (reader *Reader) csrfToken(w http.ResponseWriter, r *http.Request) (string, error) {
+	if cookie, err := r.Cookie(csrfCookie); err == nil && len(cookie.Value) == 64 {
+		return cookie.Value, nil
+	}
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	token := hex.EncodeToString(buf)
+	http.SetCookie(w, &http.Cookie{
+		Name:     csrfCookie,
+		Value:    token,
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   reader.isSecureRequest(r),
+		SameSite: http.SameSiteStrictMode,
+	})
+	return token, nil
+}
+
+// CSRFProtect rejects cross-origin mutations made with cookies or Basic Auth
+// and requires web forms and fetch calls to echo the token from the csrf
+// cookie. Only POST and DELETE are accepted as mutations.
+func (reader *Reader) CSRFProtect(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !isSafeMethod(r.Method) && reader.hasAmbientCredentials(r) && isCrossOrigin(r) {
+			http.Error(w, "Cross-origin request rejected", http.StatusForbidden)
+			return
+		}
+		if matchPath(csrfExemptPaths, r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		token, err := reader.csrfToken(w, r)
+		if err != nil {
+			reader.Error(w, err)
+			return
+		}
+		r = r.WithContext(context.WithValue(r.Context(), csrfKey{}, token))
+		if !isSafeMethod(r.Method) {
+			if r.Method != "POST" && r.Method != "DELETE" {
+				http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+				return
+			}
+			sent := r.Header.Get(csrfHeader)
+			if sent == "" {
+				sent = r.PostFormValue(csrfField)
+			}
+			if subtle.ConstantTimeCompare([]byte(sent), []byte(token)) != 1 {
+				http.Error(w, "Invalid CSRF token", http.StatusForbidden)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// requireMethod answers 405 unless the request uses one of the given methods.
+func requireMethod(w http.ResponseWriter, r *http.Request, methods ...string) bool {
+	for _, method := range methods {
+		if r.Method == method {
+			return true
+		}
+	}
+	w.Header().Set("Allow", strings.Join(methods, ", "))
+	http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	return false
+}
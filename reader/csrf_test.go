@@ -0,0 +1,48 @@
+package reader
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestCSRFProtect(t *testing.T) {
+	reader := newTestReader(t)
+	handler := reader.CSRFProtect(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	token := strings.Repeat("a", 64)
+	tests := []struct {
+		name, method, path string
+		header             http.Header
+		form               url.Values
+		want               int
+	}{
+		{"same site post", "POST", "/posts/mark", http.Header{csrfHeader: {token}}, nil, http.StatusOK},
+		{"form token", "POST", "/posts/mark", nil, url.Values{csrfField: {token}}, http.StatusOK},
+		{"cross site", "POST", "/posts/mark", http.Header{csrfHeader: {token}, "Sec-Fetch-Site": {"cross-site"}}, nil, http.StatusForbidden},
+		{"foreign origin", "POST", "/posts/mark", http.Header{csrfHeader: {token}, "Origin": {"https://evil.example"}}, nil, http.StatusForbidden},
+		{"missing token", "POST", "/posts/mark", nil, nil, http.StatusForbidden},
+		{"wrong token", "POST", "/posts/mark", http.Header{csrfHeader: {strings.Repeat("b", 64)}}, nil, http.StatusForbidden},
+		{"put", "PUT", "/posts/mark", http.Header{csrfHeader: {token}}, nil, http.StatusMethodNotAllowed},
+		{"patch", "PATCH", "/posts/mark", http.Header{csrfHeader: {token}}, nil, http.StatusMethodNotAllowed},
+		{"get", "GET", "/posts", nil, nil, http.StatusOK},
+		{"api", "PATCH", "/api/v1/posts/1", nil, nil, http.StatusOK},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest(test.method, test.path, strings.NewReader(test.form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		for name, values := range test.header {
+			r.Header.Set(name, values[0])
+		}
+		r.AddCookie(&http.Cookie{Name: sessionCookie, Value: "session"})
+		r.AddCookie(&http.Cookie{Name: csrfCookie, Value: token})
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		if w.Code != test.want {
+			t.Errorf("%s: %d, want %d", test.name, w.Code, test.want)
+		}
+	}
+}
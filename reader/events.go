@@ -0,0 +1,213 @@
+package reader
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Event types published on the event bus.
+const (
+	EventPostCreated     = "post.created"
+	EventPostUpdated     = "post.updated"
+	EventFeedStatus      = "feed.status"
+	EventRefreshProgress = "refresh.progress"
+	EventImportProgress  = "import.progress"
+)
+
+// Event is something that happened in the reader, for live views.
+type Event struct {
+	Type string `json:"type"`
+	// Users limits delivery to these user ids, nil means everyone.
+	Users []int `json:"-"`
+	Data  any   `json:"data"`
+}
+
+// For reports whether the event should be delivered to the user.
+func (event Event) For(userId int) bool {
+	if event.Users == nil {
+		return true
+	}
+	for _, id := range event.Users {
+		if id == userId {
+			return true
+		}
+	}
+	return false
+}
+
+// EventBus fans events out to subscribers without blocking publishers; a
+// subscriber that falls behind misses events.
+type EventBus struct {
+	mu          sync.Mutex
+	subscribers map[chan Event]struct{}
+}
+
+func NewEventBus() *EventBus {
+	return &EventBus{subscribers: make(map[chan Event]struct{})}
+}
+
+// Subscribe returns a channel of events and a function to stop receiving them.
+func (bus *EventBus) Subscribe() (<-chan Event, func()) {
+	ch := make(chan Event, 64)
+	bus.mu.Lock()
+	bus.subscribers[ch] = struct{}{}
+	bus.mu.Unlock()
+	return ch, func() {
+		bus.mu.Lock()
+		delete(bus.subscribers, ch)
+		bus.mu.Unlock()
+	}
+}
+
+func (bus *EventBus) Publish(event Event) {
+	bus.mu.Lock()
+	defer bus.mu.Unlock()
+	for ch := range bus.subscribers {
+		select {
+		case ch <- event:
+		default:
+		}
+	}
+}
+
+// feedSubscribers returns the ids of the users subscribed to a feed.
+func (reader *Reader) feedSubscribers(feedId string) (users []int, err error) {
+	rows, err := reader.db.Query("SELECT user_id FROM subscriptions WHERE feed_id = ?", feedId)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	users = []int{}
+	for rows.Next() {
+		var id int
+		if err = rows.Scan(&id); err != nil {
+			return
+		}
+		users = append(users, id)
+	}
+	return users, rows.Err()
+}
+
+// publishFeed publishes an event to the subscribers of a feed.
+func (reader *Reader) publishFeed(feedId string, eventType string, data H) {
+	users, err := reader.feedSubscribers(feedId)
+	if err != nil {
+		log.Println("Error listing feed subscribers:", err)
+		return
+	}
+	reader.events.Publish(Event{Type: eventType, Users: users, Data: data})
+}
+
+// postChanges turns updates such as "is_read = 1" into {"is_read": true}.
+func postChanges(updates []string) map[string]bool {
+	changes := make(map[string]bool)
+	for _, update := range updates {
+		if column, value, ok := strings.Cut(update, "="); ok {
+			changes[strings.TrimSpace(column)] = strings.TrimSpace(value) == "1"
+		}
+	}
+	return changes
+}
+
+// unreadData summarizes the user's unread posts per category and feed.
+func (reader *Reader) unreadData() (H, error) {
+	categories, err := reader.GetCategories()
+	if err != nil {
+		return nil, err
+	}
+	feeds, err := reader.UnreadCounts()
+	if err != nil {
+		return nil, err
+	}
+	total := 0
+	byCategory := make(map[int]int)
+	for _, category := range categories {
+		byCategory[category.Id] = category.Unread
+		total += category.Unread
+	}
+	return H{"total": total, "categories": byCategory, "feeds": feeds}, nil
+}
+
+// EventsView streams the user's events as Server-Sent Events, followed by
+// an "unread" event with fresh counts whenever posts change.
+func (reader *Reader) EventsView(w http.ResponseWriter, r *http.Request) {
+	reader = reader.forRequest(r)
+	if !requireMethod(w, r, "GET") {
+		return
+	}
+	if reader.user == nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	events, unsubscribe := reader.events.Subscribe()
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	send := func(eventType string, data any) error {
+		payload, err := json.Marshal(data)
+		if err != nil {
+			return err
+		}
+		if _, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", eventType, payload); err != nil {
+			return err
+		}
+		flusher.Flush()
+		return nil
+	}
+	sendUnread := func() error {
+		data, err := reader.unreadData()
+		if err != nil {
+			return err
+		}
+		return send("unread", data)
+	}
+	if err := sendUnread(); err != nil {
+		log.Println("Events:", err)
+		return
+	}
+
+	heartbeat := time.NewTicker(30 * time.Second)
+	defer heartbeat.Stop()
+	// Post events arrive in bursts while feeds refresh, so unread counts are
+	// recomputed at most once a second.
+	var recount <-chan time.Time
+	userId := reader.userId()
+	for {
+		var err error
+		select {
+		case <-r.Context().Done():
+			return
+		case <-heartbeat.C:
+			_, err = fmt.Fprint(w, ": ping\n\n")
+			flusher.Flush()
+		case <-recount:
+			recount = nil
+			err = sendUnread()
+		case event := <-events:
+			if !event.For(userId) {
+				continue
+			}
+			if event.Type == EventPostCreated || event.Type == EventPostUpdated {
+				if recount == nil {
+					recount = time.After(time.Second)
+				}
+			}
+			err = send(event.Type, event.Data)
+		}
+		if err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,676 @@
+package reader
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Google Reader stream and state identifiers.
+const (
+	greaderReadingList = "user/-/state/com.google/reading-list"
+	greaderRead        = "user/-/state/com.google/read"
+	greaderStarred     = "user/-/state/com.google/starred"
+	greaderKeptUnread  = "user/-/state/com.google/kept-unread"
+	greaderLabelPrefix = "user/-/label/"
+	greaderFeedPrefix  = "feed/"
+	greaderItemPrefix  = "tag:google.com,2005:reader/item/"
+)
+
+type GReaderCategory struct {
+	Id    string `json:"id"`
+	Label string `json:"label,omitempty"`
+	Type  string `json:"type,omitempty"`
+}
+
+type GReaderSubscription struct {
+	Id         string            `json:"id"`
+	Title      string            `json:"title"`
+	Categories []GReaderCategory `json:"categories"`
+	Url        string            `json:"url"`
+	HtmlUrl    string            `json:"htmlUrl"`
+	IconUrl    string            `json:"iconUrl"`
+}
+
+type GReaderLink struct {
+	Href string `json:"href"`
+	Type string `json:"type,omitempty"`
+}
+
+type GReaderContent struct {
+	Direction string `json:"direction"`
+	Content   string `json:"content"`
+}
+
+type GReaderOrigin struct {
+	StreamId string `json:"streamId"`
+	Title    string `json:"title"`
+	HtmlUrl  string `json:"htmlUrl"`
+}
+
+type GReaderItem struct {
+	Id            string         `json:"id"`
+	CrawlTimeMsec string         `json:"crawlTimeMsec"`
+	TimestampUsec string         `json:"timestampUsec"`
+	Published     int64          `json:"published"`
+	Updated       int64          `json:"updated"`
+	Title         string         `json:"title"`
+	Author        string         `json:"author"`
+	Canonical     []GReaderLink  `json:"canonical"`
+	Alternate     []GReaderLink  `json:"alternate"`
+	Categories    []string       `json:"categories"`
+	Origin        GReaderOrigin  `json:"origin"`
+	Summary       GReaderContent `json:"summary"`
+}
+
+type GReaderItemRef struct {
+	Id              string   `json:"id"`
+	DirectStreamIds []string `json:"directStreamIds"`
+	TimestampUsec   string   `json:"timestampUsec"`
+}
+
+// greaderTokenTTL is how long a ClientLogin token stays valid.
+const greaderTokenTTL = 365 * 24 * time.Hour
+
+// CreateGReaderToken issues a ClientLogin token for the user and returns its
+// secret. Only a hash is stored, and the token is only accepted by the
+// Google Reader API.
+func (reader *Reader) CreateGReaderToken(username string) (secret string, err error) {
+	buf := make([]byte, 32)
+	if _, err = rand.Read(buf); err != nil {
+		return
+	}
+	secret = hex.EncodeToString(buf)
+	_, err = reader.db.Exec(`
+		INSERT INTO greader_tokens (token_hash, username, expires_at) VALUES (?, ?, ?)
+	`, hashToken(secret), username, time.Now().Add(greaderTokenTTL))
+	// Sweep expired tokens while we are here.
+	reader.db.Exec("DELETE FROM greader_tokens WHERE expires_at < ?", time.Now())
+	return
+}
+
+// greaderUser resolves the user from a "GoogleLogin auth=..." header, whose
+// token was issued by ClientLogin.
+func (reader *Reader) greaderUser(r *http.Request) *User {
+	header := r.Header.Get("Authorization")
+	token, ok := strings.CutPrefix(header, "GoogleLogin auth=")
+	if !ok {
+		return nil
+	}
+	var username string
+	var expires time.Time
+	err := reader.db.QueryRow(`
+		SELECT username, expires_at FROM greader_tokens WHERE token_hash = ?
+	`, hashToken(token)).Scan(&username, &expires)
+	if err != nil || time.Now().After(expires) {
+		return nil
+	}
+	return reader.FindUser(username)
+}
+
+func (reader *Reader) greaderJson(w http.ResponseWriter, data any) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(w).Encode(data)
+}
+
+func (reader *Reader) greaderText(w http.ResponseWriter, status int, text string) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(status)
+	fmt.Fprintln(w, text)
+}
+
+// GReaderLoginView handles /accounts/ClientLogin.
+func (reader *Reader) GReaderLoginView(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	email := r.Form.Get("Email")
+	user := reader.Login(email, r.Form.Get("Passwd"))
+	if user == nil {
+		log.Println("GReader login failed for", email)
+		reader.greaderText(w, http.StatusUnauthorized, "Error=BadAuthentication")
+		return
+	}
+	token, err := reader.CreateGReaderToken(user.Username)
+	if err != nil {
+		reader.greaderText(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if r.Form.Get("output") == "json" {
+		reader.greaderJson(w, H{"SID": token, "LSID": token, "Auth": token})
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	fmt.Fprintf(w, "SID=%s\nLSID=%s\nAuth=%s\n", token, token, token)
+}
+
+// GReaderView serves the Google Reader compatible API under /reader/api/0/.
+func (reader *Reader) GReaderView(w http.ResponseWriter, r *http.Request) {
+	user := reader.greaderUser(r)
+	if user == nil {
+		reader.greaderText(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	reader = reader.As(user)
+	r.ParseForm()
+	endpoint := strings.TrimPrefix(r.URL.EscapedPath(), "/reader/api/0/")
+	start := time.Now()
+	var err error
+	switch {
+	case endpoint == "token":
+		// write requests are authenticated by header, so this token is informational
+		reader.greaderText(w, http.StatusOK, hashToken(r.Header.Get("Authorization"))[:57])
+	case endpoint == "user-info":
+		reader.greaderJson(w, H{
+			"userId":        user.Username,
+			"userName":      user.Username,
+			"userProfileId": user.Username,
+			"userEmail":     user.Username,
+		})
+	case endpoint == "subscription/list":
+		err = reader.greaderSubscriptionList(w, r)
+	case endpoint == "subscription/edit":
+		err = reader.greaderSubscriptionEdit(w, r)
+	case endpoint == "subscription/quickadd":
+		err = reader.greaderQuickAdd(w, r)
+	case endpoint == "tag/list":
+		err = reader.greaderTagList(w, r)
+	case endpoint == "unread-count":
+		err = reader.greaderUnreadCount(w, r)
+	case strings.HasPrefix(endpoint, "stream/contents"):
+		streamId, _ := url.PathUnescape(strings.TrimPrefix(strings.TrimPrefix(endpoint, "stream/contents"), "/"))
+		if streamId == "" {
+			streamId = r.Form.Get("s")
+		}
+		err = reader.greaderStreamContents(w, r, streamId)
+	case endpoint == "stream/items/ids":
+		err = reader.greaderItemIds(w, r)
+	case endpoint == "stream/items/contents":
+		err = reader.greaderItemContents(w, r)
+	case endpoint == "edit-tag":
+		err = reader.greaderEditTag(w, r)
+	case endpoint == "mark-all-as-read":
+		err = reader.greaderMarkAllAsRead(w, r)
+	default:
+		reader.greaderText(w, http.StatusNotFound, "Not Found")
+	}
+	metric := endpoint
+	if strings.HasPrefix(metric, "stream/contents") {
+		metric = "stream/contents"
+	}
+	reader.metrics.Observe("greader."+metric, time.Since(start), err != nil)
+	if err != nil {
+		log.Printf("greader %s %s: %v", r.Method, endpoint, err)
+		reader.greaderText(w, http.StatusBadRequest, err.Error())
+	}
+}
+
+// normalizeStreamId rewrites user/<id>/... into the user/-/... form.
+func normalizeStreamId(streamId string) string {
+	if rest, ok := strings.CutPrefix(streamId, "user/"); ok {
+		if i := strings.Index(rest, "/"); i >= 0 {
+			return "user/-" + rest[i:]
+		}
+	}
+	return streamId
+}
+
+// greaderFeed finds the subscription referenced by a feed/ stream id,
+// which may carry either the numeric id or the feed URL.
+func (reader *Reader) greaderFeed(streamId string) (*Feed, error) {
+	ref := strings.TrimPrefix(streamId, greaderFeedPrefix)
+	feeds, err := reader.GetFeeds(nil)
+	if err != nil {
+		return nil, err
+	}
+	for _, feed := range feeds {
+		if strconv.Itoa(feed.Id) == ref || feed.Link == ref {
+			return feed, nil
+		}
+	}
+	return nil, fmt.Errorf("feed not found: %s", ref)
+}
+
+func (reader *Reader) greaderCategory(label string) (*Category, error) {
+	categories, err := reader.GetCategories()
+	if err != nil {
+		return nil, err
+	}
+	for _, category := range categories {
+		if category.Name == label {
+			return category, nil
+		}
+	}
+	return nil, fmt.Errorf("label not found: %s", label)
+}
+
+// greaderConditions translates a stream id into GetPosts conditions.
+func (reader *Reader) greaderConditions(streamId string, exclude bool) (conditions []string, err error) {
+	streamId = normalizeStreamId(streamId)
+	switch {
+	case streamId == greaderReadingList:
+		if exclude {
+			return nil, fmt.Errorf("cannot exclude %s", streamId)
+		}
+	case streamId == greaderRead:
+		conditions = append(conditions, fmt.Sprintf("p.is_read = %d", b2i(!exclude)))
+	case streamId == greaderKeptUnread:
+		conditions = append(conditions, fmt.Sprintf("p.is_read = %d", b2i(exclude)))
+	case streamId == greaderStarred:
+		conditions = append(conditions, fmt.Sprintf("p.is_saved = %d", b2i(!exclude)))
+	case strings.HasPrefix(streamId, greaderLabelPrefix):
+		category, err := reader.greaderCategory(strings.TrimPrefix(streamId, greaderLabelPrefix))
+		if err != nil {
+			return nil, err
+		}
+		op := "="
+		if exclude {
+			op = "!="
+		}
+		conditions = append(conditions, fmt.Sprintf("g.id %s %d", op, category.Id))
+	case strings.HasPrefix(streamId, greaderFeedPrefix):
+		feed, err := reader.greaderFeed(streamId)
+		if err != nil {
+			return nil, err
+		}
+		op := "="
+		if exclude {
+			op = "!="
+		}
+		conditions = append(conditions, fmt.Sprintf("p.feed_id %s %d", op, feed.Id))
+	default:
+		return nil, fmt.Errorf("unknown stream: %s", streamId)
+	}
+	return
+}
+
+func b2i(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// greaderQuery builds the conditions and pagination shared by the stream endpoints.
+func (reader *Reader) greaderQuery(r *http.Request, streamId string) (conditions []string, limit *Pagination, err error) {
+	conditions, err = reader.greaderConditions(streamId, false)
+	if err != nil {
+		return
+	}
+	for _, xt := range r.Form["xt"] {
+		more, err := reader.greaderConditions(xt, true)
+		if err != nil {
+			return nil, nil, err
+		}
+		conditions = append(conditions, more...)
+	}
+	for _, it := range r.Form["it"] {
+		more, err := reader.greaderConditions(it, false)
+		if err != nil {
+			return nil, nil, err
+		}
+		conditions = append(conditions, more...)
+	}
+	if ot, err := strconv.ParseInt(r.Form.Get("ot"), 10, 64); err == nil {
+		conditions = append(conditions, fmt.Sprintf("%s >= %d", postTimeKey, ot))
+	}
+	if nt, err := strconv.ParseInt(r.Form.Get("nt"), 10, 64); err == nil {
+		conditions = append(conditions, fmt.Sprintf("%s <= %d", postTimeKey, nt))
+	}
+	limit = &Pagination{Page: 1, Size: 20}
+	if n, err := strconv.Atoi(r.Form.Get("n")); err == nil && n > 0 {
+		limit.Size = n
+	}
+	if c, err := strconv.Atoi(r.Form.Get("c")); err == nil && c > 0 {
+		limit.Page = c
+	}
+	return
+}
+
+func continuation(limit *Pagination) string {
+	if limit.HasMore() {
+		return strconv.Itoa(limit.Next())
+	}
+	return ""
+}
+
+// parseItemId accepts the long tag: form, the 16 digit hex form and plain decimal ids.
+func parseItemId(id string) (int64, error) {
+	if hex, ok := strings.CutPrefix(id, greaderItemPrefix); ok {
+		return strconv.ParseInt(hex, 16, 64)
+	}
+	if len(id) == 16 {
+		if n, err := strconv.ParseUint(id, 16, 64); err == nil {
+			return int64(n), nil
+		}
+	}
+	return strconv.ParseInt(id, 10, 64)
+}
+
+func (reader *Reader) greaderItemConditions(r *http.Request) ([]string, error) {
+	ids := make([]string, 0, len(r.Form["i"]))
+	for _, i := range r.Form["i"] {
+		id, err := parseItemId(i)
+		if err != nil {
+			return nil, fmt.Errorf("invalid item id: %s", i)
+		}
+		ids = append(ids, strconv.FormatInt(id, 10))
+	}
+	if len(ids) == 0 {
+		return nil, fmt.Errorf("missing item ids")
+	}
+	return []string{fmt.Sprintf("p.id IN (%s)", strings.Join(ids, ","))}, nil
+}
+
+func greaderItem(post Post) GReaderItem {
+	categories := []string{greaderReadingList, greaderLabelPrefix + post.Feed.Category.Name}
+	if post.IsRead {
+		categories = append(categories, greaderRead)
+	}
+	if post.IsSaved {
+		categories = append(categories, greaderStarred)
+	}
+	links := []GReaderLink{{Href: post.Link, Type: "text/html"}}
+	return GReaderItem{
+		Id:            fmt.Sprintf("%s%016x", greaderItemPrefix, post.Id),
+		CrawlTimeMsec: strconv.FormatInt(post.CreatedAt.UnixMilli(), 10),
+		TimestampUsec: strconv.FormatInt(post.PubDate.UnixMicro(), 10),
+		Published:     post.PubDate.Unix(),
+		Updated:       post.PubDate.Unix(),
+		Title:         post.Title,
+		Author:        post.Feed.Name,
+		Canonical:     links,
+		Alternate:     links,
+		Categories:    categories,
+		Origin: GReaderOrigin{
+			StreamId: fmt.Sprintf("%s%d", greaderFeedPrefix, post.Feed.Id),
+			Title:    post.Feed.Name,
+			HtmlUrl:  post.Feed.Home,
+		},
+		Summary: GReaderContent{Direction: "ltr", Content: post.Content},
+	}
+}
+
+func (reader *Reader) greaderSubscriptionList(w http.ResponseWriter, r *http.Request) error {
+	feeds, err := reader.GetFeeds(nil)
+	if err != nil {
+		return err
+	}
+	subscriptions := make([]GReaderSubscription, 0, len(feeds))
+	for _, feed := range feeds {
+		subscriptions = append(subscriptions, GReaderSubscription{
+			Id:    fmt.Sprintf("%s%d", greaderFeedPrefix, feed.Id),
+			Title: feed.Name,
+			Categories: []GReaderCategory{{
+				Id:    greaderLabelPrefix + feed.Category.Name,
+				Label: feed.Category.Name,
+			}},
+			Url:     feed.Link,
+			HtmlUrl: feed.Home,
+		})
+	}
+	reader.greaderJson(w, H{"subscriptions": subscriptions})
+	return nil
+}
+
+func (reader *Reader) greaderSubscriptionEdit(w http.ResponseWriter, r *http.Request) error {
+	action := r.Form.Get("ac")
+	title := r.Form.Get("t")
+	categoryId, err := reader.DefaultCategoryId()
+	if err != nil {
+		return err
+	}
+	if add := r.Form.Get("a"); add != "" {
+		id, err := reader.GetOrCreateCategory(strings.TrimPrefix(normalizeStreamId(add), greaderLabelPrefix))
+		if err != nil {
+			return err
+		}
+		categoryId = id
+	}
+	for _, streamId := range r.Form["s"] {
+		switch action {
+		case "subscribe":
+			link := strings.TrimPrefix(streamId, greaderFeedPrefix)
+			if _, err := reader.SubscribeFeed(link, title, categoryId); err != nil {
+				return err
+			}
+		case "unsubscribe":
+			feed, err := reader.greaderFeed(streamId)
+			if err != nil {
+				return err
+			}
+			if err := reader.DeleteFeed(strconv.Itoa(feed.Id)); err != nil {
+				return err
+			}
+		case "edit":
+			feed, err := reader.greaderFeed(streamId)
+			if err != nil {
+				return err
+			}
+			var update FeedUpdate
+			if title != "" {
+				update.Name = &title
+			}
+			if r.Form.Has("a") || r.Form.Has("r") {
+				update.CategoryId = &categoryId
+			}
+			if _, err := reader.UpdateFeed(strconv.Itoa(feed.Id), update); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("unknown action: %s", action)
+		}
+	}
+	reader.greaderText(w, http.StatusOK, "OK")
+	return nil
+}
+
+func (reader *Reader) greaderQuickAdd(w http.ResponseWriter, r *http.Request) error {
+	link := strings.TrimPrefix(r.Form.Get("quickadd"), greaderFeedPrefix)
+	categoryId, err := reader.DefaultCategoryId()
+	if err != nil {
+		return err
+	}
+	id, err := reader.SubscribeFeed(link, "", categoryId)
+	if err != nil {
+		return err
+	}
+	reader.greaderJson(w, H{
+		"numResults": 1,
+		"query":      link,
+		"streamId":   fmt.Sprintf("%s%d", greaderFeedPrefix, id),
+	})
+	return nil
+}
+
+func (reader *Reader) greaderTagList(w http.ResponseWriter, r *http.Request) error {
+	categories, err := reader.GetCategories()
+	if err != nil {
+		return err
+	}
+	tags := []GReaderCategory{{Id: greaderStarred}}
+	for _, category := range categories {
+		tags = append(tags, GReaderCategory{
+			Id:    greaderLabelPrefix + category.Name,
+			Label: category.Name,
+			Type:  "folder",
+		})
+	}
+	reader.greaderJson(w, H{"tags": tags})
+	return nil
+}
+
+func (reader *Reader) greaderUnreadCount(w http.ResponseWriter, r *http.Request) error {
+	posts, err := reader.GetPosts([]string{"p.is_read = 0"}, nil)
+	if err != nil {
+		return err
+	}
+	type count struct {
+		Id     string `json:"id"`
+		Count  int    `json:"count"`
+		Newest string `json:"newestItemTimestampUsec"`
+	}
+	counts := make(map[string]*count)
+	var order []string
+	add := func(id string, post Post) {
+		c, ok := counts[id]
+		if !ok {
+			c = &count{Id: id}
+			counts[id] = c
+			order = append(order, id)
+		}
+		c.Count++
+		// posts are sorted newest first
+		if c.Newest == "" {
+			c.Newest = strconv.FormatInt(post.PubDate.UnixMicro(), 10)
+		}
+	}
+	for _, post := range posts {
+		add(greaderReadingList, post)
+		add(fmt.Sprintf("%s%d", greaderFeedPrefix, post.Feed.Id), post)
+		add(greaderLabelPrefix+post.Feed.Category.Name, post)
+	}
+	unreadcounts := make([]*count, 0, len(order))
+	for _, id := range order {
+		unreadcounts = append(unreadcounts, counts[id])
+	}
+	reader.greaderJson(w, H{"max": len(posts), "unreadcounts": unreadcounts})
+	return nil
+}
+
+func (reader *Reader) greaderStreamContents(w http.ResponseWriter, r *http.Request, streamId string) error {
+	if streamId == "" {
+		streamId = greaderReadingList
+	}
+	conditions, limit, err := reader.greaderQuery(r, streamId)
+	if err != nil {
+		return err
+	}
+	posts, err := reader.GetPosts(conditions, limit)
+	if err != nil {
+		return err
+	}
+	items := make([]GReaderItem, 0, len(posts))
+	for _, post := range posts {
+		items = append(items, greaderItem(post))
+	}
+	reader.greaderJson(w, H{
+		"id":           streamId,
+		"updated":      time.Now().Unix(),
+		"items":        items,
+		"continuation": continuation(limit),
+	})
+	return nil
+}
+
+func (reader *Reader) greaderItemIds(w http.ResponseWriter, r *http.Request) error {
+	streamId := r.Form.Get("s")
+	if streamId == "" {
+		streamId = greaderReadingList
+	}
+	conditions, limit, err := reader.greaderQuery(r, streamId)
+	if err != nil {
+		return err
+	}
+	posts, err := reader.GetPosts(conditions, limit)
+	if err != nil {
+		return err
+	}
+	refs := make([]GReaderItemRef, 0, len(posts))
+	for _, post := range posts {
+		refs = append(refs, GReaderItemRef{
+			Id:              strconv.Itoa(post.Id),
+			DirectStreamIds: []string{fmt.Sprintf("%s%d", greaderFeedPrefix, post.Feed.Id)},
+			TimestampUsec:   strconv.FormatInt(post.PubDate.UnixMicro(), 10),
+		})
+	}
+	reader.greaderJson(w, H{
+		"itemRefs":     refs,
+		"continuation": continuation(limit),
+	})
+	return nil
+}
+
+func (reader *Reader) greaderItemContents(w http.ResponseWriter, r *http.Request) error {
+	conditions, err := reader.greaderItemConditions(r)
+	if err != nil {
+		return err
+	}
+	posts, err := reader.GetPosts(conditions, nil)
+	if err != nil {
+		return err
+	}
+	items := make([]GReaderItem, 0, len(posts))
+	for _, post := range posts {
+		items = append(items, greaderItem(post))
+	}
+	reader.greaderJson(w, H{
+		"id":      greaderReadingList,
+		"updated": time.Now().Unix(),
+		"items":   items,
+	})
+	return nil
+}
+
+// greaderStateUpdate maps a read or starred tag to a posts column update.
+func greaderStateUpdate(tag string, set bool) (string, error) {
+	switch normalizeStreamId(tag) {
+	case greaderRead:
+		return fmt.Sprintf("is_read = %d", b2i(set)), nil
+	case greaderKeptUnread:
+		return fmt.Sprintf("is_read = %d", b2i(!set)), nil
+	case greaderStarred:
+		return fmt.Sprintf("is_saved = %d", b2i(set)), nil
+	}
+	return "", fmt.Errorf("unsupported tag: %s", tag)
+}
+
+func (reader *Reader) greaderEditTag(w http.ResponseWriter, r *http.Request) error {
+	conditions, err := reader.greaderItemConditions(r)
+	if err != nil {
+		return err
+	}
+	var updates []string
+	for _, tag := range r.Form["a"] {
+		update, err := greaderStateUpdate(tag, true)
+		if err != nil {
+			return err
+		}
+		updates = append(updates, update)
+	}
+	for _, tag := range r.Form["r"] {
+		update, err := greaderStateUpdate(tag, false)
+		if err != nil {
+			return err
+		}
+		updates = append(updates, update)
+	}
+	if len(updates) > 0 {
+		if err := reader.UpdatePosts(conditions, updates); err != nil {
+			return err
+		}
+	}
+	reader.greaderText(w, http.StatusOK, "OK")
+	return nil
+}
+
+func (reader *Reader) greaderMarkAllAsRead(w http.ResponseWriter, r *http.Request) error {
+	conditions, err := reader.greaderConditions(r.Form.Get("s"), false)
+	if err != nil {
+		return err
+	}
+	// ts is given in microseconds
+	if ts, err := strconv.ParseInt(r.Form.Get("ts"), 10, 64); err == nil && ts > 0 {
+		conditions = append(conditions, fmt.Sprintf("%s <= %d", postTimeKey, ts/1e6))
+	}
+	if err := reader.UpdatePosts(conditions, []string{"is_read = 1"}); err != nil {
+		return err
+	}
+	reader.greaderText(w, http.StatusOK, "OK")
+	return nil
+}
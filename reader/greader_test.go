@@ -0,0 +1,70 @@
+package reader
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func greaderLogin(t *testing.T, reader *Reader) string {
+	t.Helper()
+	form := url.Values{"Email": {"admin"}, "Passwd": {"admin123"}}
+	r := httptest.NewRequest("POST", "/accounts/ClientLogin", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	reader.GReaderLoginView(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("ClientLogin: %d %s", w.Code, w.Body)
+	}
+	for _, line := range strings.Split(w.Body.String(), "\n") {
+		if token, ok := strings.CutPrefix(line, "Auth="); ok {
+			return token
+		}
+	}
+	t.Fatalf("ClientLogin returned no token: %s", w.Body)
+	return ""
+}
+
+func TestGReaderTokenIsNotASession(t *testing.T) {
+	reader := newTestReader(t)
+	token := greaderLogin(t, reader)
+
+	r := httptest.NewRequest("GET", "/reader/api/0/user-info", nil)
+	r.Header.Set("Authorization", "GoogleLogin auth="+token)
+	w := httptest.NewRecorder()
+	reader.GReaderView(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("user-info: %d %s", w.Code, w.Body)
+	}
+
+	r = httptest.NewRequest("GET", "/reader/api/0/token", nil)
+	r.Header.Set("Authorization", "GoogleLogin auth="+token)
+	w = httptest.NewRecorder()
+	reader.GReaderView(w, r)
+	if body := w.Body.String(); strings.Contains(body, token) || strings.Contains(strings.ToLower(body), strings.ToLower(reader.config.Users[0].FeverAuthKey())) {
+		t.Errorf("token endpoint returned a credential: %q", body)
+	}
+
+	r = httptest.NewRequest("GET", "/feeds", nil)
+	r.AddCookie(&http.Cookie{Name: sessionCookie, Value: token})
+	if user := reader.CurrentUser(r); user != nil {
+		t.Errorf("ClientLogin token logged %q into the web UI", user.Username)
+	}
+}
+
+func TestSessionIsNotAGReaderToken(t *testing.T) {
+	reader := newTestReader(t)
+	secret, err := reader.CreateSession("admin", reader.config.SessionDuration())
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := httptest.NewRequest("GET", "/reader/api/0/user-info", nil)
+	r.Header.Set("Authorization", "GoogleLogin auth="+secret)
+	w := httptest.NewRecorder()
+	reader.GReaderView(w, r)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("session secret accepted by the Google Reader API: %d", w.Code)
+	}
+}
@@ -0,0 +1,262 @@
+package reader
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/lsongdev/feedreader/feed"
+)
+
+// readLaterServices are the feeds that hold the pages saved in read-later
+// services, which have no feed of their own.
+var readLaterServices = map[string]feed.HistoryFeed{
+	feed.HistoryPocket:     {Title: "Pocket", Home: "https://getpocket.com/"},
+	feed.HistoryInstapaper: {Title: "Instapaper", Home: "https://www.instapaper.com/"},
+}
+
+// HistoryReport counts what importing the history of another reader did.
+type HistoryReport struct {
+	Format string `json:"format"`
+	Items  int    `json:"items"`
+	// Feeds is the number of subscriptions created for the items.
+	Feeds int `json:"feeds"`
+	// Posts is the number of items that were not stored yet.
+	Posts int `json:"posts"`
+	Read  int `json:"read"`
+	Saved int `json:"saved"`
+	// Skipped items have no link.
+	Skipped int `json:"skipped"`
+}
+
+// ImportHistory imports the starred and read items exported by another
+// reader or read-later service. It subscribes to their feeds, in categories
+// named after their labels, and stores them with their original dates and
+// state. Items the user already has only gain the exported state.
+func (reader *Reader) ImportHistory(data []byte) (*HistoryReport, error) {
+	history, err := feed.ParseHistory(data)
+	if err != nil {
+		return nil, err
+	}
+	report := &HistoryReport{Format: history.Format, Items: len(history.Items)}
+	feeds := map[string]postTarget{}
+	targets := make([]postTarget, len(history.Items))
+	for i, item := range history.Items {
+		if item.Link == "" {
+			continue
+		}
+		source, readLater := item.Feed, item.Feed == nil
+		if readLater {
+			service := readLaterServices[history.Format]
+			service.Link = fmt.Sprintf("%s:%s", history.Format, reader.user.Username)
+			source = &service
+		}
+		target, ok := feeds[source.Link]
+		if !ok {
+			if target, err = reader.historyFeed(source, item.Category, readLater, report); err != nil {
+				return nil, err
+			}
+			feeds[source.Link] = target
+		}
+		targets[i] = target
+	}
+
+	tx, err := reader.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	created := map[int]int{}
+	for i, item := range history.Items {
+		target := targets[i]
+		if target.id == 0 {
+			report.Skipped++
+			continue
+		}
+		entryId := item.ID
+		if entryId == "" {
+			entryId = item.Link
+		}
+		published := item.Published
+		if published.IsZero() {
+			published = time.Now().UTC()
+		}
+		var postId int
+		isNew := false
+		if target.shared != 0 {
+			postId, err = findPost(tx, target.shared, entryId, item.Link)
+		}
+		if target.shared == 0 || err == sql.ErrNoRows {
+			content := feed.SanitizeHTML(item.Content)
+			postId, isNew, err = storePost(tx, target.id, entryId, item.Title, content, item.Link, item.Author, published)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if isNew {
+			report.Posts++
+			created[target.id]++
+		}
+		if _, err = reader.storePostState(tx, postId, item.Read, item.Saved); err != nil {
+			return nil, err
+		}
+		if item.Read {
+			report.Read++
+		}
+		if item.Saved {
+			report.Saved++
+		}
+	}
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+	for feedId, count := range created {
+		reader.publishFeed(strconv.Itoa(feedId), EventPostCreated, H{"feed_id": feedId, "count": count})
+	}
+	return report, nil
+}
+
+// postTarget is the feed imported or restored posts are stored in, and the
+// shared feed the user follows whose posts they are looked up in first.
+type postTarget struct {
+	id, shared int
+}
+
+// historyFeed returns the feed imported items are stored in, subscribing to
+// the feed in the named category if the user does not follow it yet. The
+// items go to a paused private copy, so other subscribers never see them and
+// the feed is still fetched once. Read-later services have no feed to fetch,
+// so they get a paused private feed of their own.
+func (reader *Reader) historyFeed(source *feed.HistoryFeed, category string, readLater bool, report *HistoryReport) (target postTarget, err error) {
+	var id int
+	err = reader.db.QueryRow(fmt.Sprintf("SELECT f.id FROM %s f WHERE f.link = ?", reader.feedsTable()), source.Link).Scan(&id)
+	if err != nil && err != sql.ErrNoRows {
+		return
+	}
+	if err == sql.ErrNoRows {
+		var categoryId int
+		if category != "" {
+			categoryId, err = reader.GetOrCreateCategory(category)
+		} else {
+			categoryId, err = reader.DefaultCategoryId()
+		}
+		if err != nil {
+			return
+		}
+		title := source.Title
+		if title == "" {
+			title = source.Link
+		}
+		if readLater {
+			return reader.historyService(title, source, categoryId, report)
+		}
+		if id, err = reader.CreateFeed("", title, source.Home, source.Link, categoryId); err != nil {
+			return
+		}
+		report.Feeds++
+	}
+	tx, err := reader.db.Begin()
+	if err != nil {
+		return
+	}
+	defer tx.Rollback()
+	if target, _, err = reader.ownFeed(tx, id); err != nil {
+		return
+	}
+	return target, tx.Commit()
+}
+
+// historyService subscribes the user to the paused private feed holding the
+// pages saved in a read-later service.
+func (reader *Reader) historyService(title string, source *feed.HistoryFeed, categoryId int, report *HistoryReport) (target postTarget, err error) {
+	tx, err := reader.db.Begin()
+	if err != nil {
+		return
+	}
+	defer tx.Rollback()
+	if target.id, err = reader.subscribePrivate(tx, title, source.Home, source.Link, categoryId, true); err != nil {
+		return
+	}
+	report.Feeds++
+	return target, tx.Commit()
+}
+
+// ownFeed returns where posts may be added to the user's subscription to a
+// feed without reaching other subscribers: the feed itself when the user
+// owns it, and otherwise a paused private copy that is never fetched. It
+// reports whether the copy was created.
+func (reader *Reader) ownFeed(tx *sql.Tx, id int) (target postTarget, created bool, err error) {
+	target.id = id
+	var owner, categoryId int
+	var name, home, link string
+	err = tx.QueryRow(`
+		SELECT f.owner_id, sub.category_id, COALESCE(NULLIF(sub.name, ''), f.name, ''), COALESCE(f.home, ''), f.link
+		FROM subscriptions sub JOIN feeds f ON f.id = sub.feed_id
+		WHERE sub.feed_id = ? AND sub.user_id = ?`, id, reader.userId()).Scan(&owner, &categoryId, &name, &home, &link)
+	if err != nil || owner == reader.userId() {
+		return
+	}
+	target.shared = id
+	link = "history:" + link
+	err = tx.QueryRow("SELECT id FROM feeds WHERE link = ? AND owner_id = ?", link, reader.userId()).Scan(&target.id)
+	if err != sql.ErrNoRows {
+		return
+	}
+	target.id, err = reader.subscribePrivate(tx, name, home, link, categoryId, true)
+	return target, err == nil, err
+}
+
+// subscribePrivate subscribes the user to a new feed only they follow.
+func (reader *Reader) subscribePrivate(tx *sql.Tx, name, home, link string, categoryId int, disabled bool) (id int, err error) {
+	err = tx.QueryRow(`
+		INSERT INTO feeds (type, name, home, link, owner_id) VALUES ('', ?, ?, ?, ?) RETURNING id
+	`, name, home, link, reader.userId()).Scan(&id)
+	if err != nil {
+		return
+	}
+	_, err = tx.Exec(`
+		INSERT INTO subscriptions (user_id, feed_id, category_id, name, disabled) VALUES (?, ?, ?, ?, ?)
+	`, reader.userId(), id, categoryId, name, disabled)
+	return
+}
+
+// storePost returns the id of a feed's post with the entry id or link,
+// adding the post when the feed has none.
+func storePost(tx *sql.Tx, feedId int, entryId, title, content, link, author string, published time.Time) (id int, created bool, err error) {
+	if id, err = findPost(tx, feedId, entryId, link); err != sql.ErrNoRows {
+		return
+	}
+	err = tx.QueryRow(`
+		INSERT INTO posts (entry_id, title, content, link, author, pub_date, feed_id)
+		VALUES (?, ?, ?, ?, ?, ?, ?) RETURNING id
+	`, entryId, title, content, link, author, published, feedId).Scan(&id)
+	return id, err == nil, err
+}
+
+// findPost returns the id of a feed's post with the entry id or link.
+func findPost(tx *sql.Tx, feedId int, entryId, link string) (id int, err error) {
+	err = tx.QueryRow(`
+		SELECT id FROM posts WHERE feed_id = ? AND (entry_id = ? OR link = ?) ORDER BY id LIMIT 1
+	`, feedId, entryId, link).Scan(&id)
+	return
+}
+
+// storePostState marks a post read or saved for the user, and reports
+// whether that changed its state. It never marks a post unread or unsaved.
+func (reader *Reader) storePostState(tx *sql.Tx, postId int, read, saved bool) (bool, error) {
+	if !read && !saved {
+		return false, nil
+	}
+	res, err := tx.Exec(`
+		INSERT INTO post_states (user_id, post_id, is_read, is_saved, updated_at) VALUES (?, ?, ?, ?, CURRENT_TIMESTAMP)
+		ON CONFLICT (user_id, post_id) DO UPDATE
+		SET is_read = MAX(is_read, excluded.is_read), is_saved = MAX(is_saved, excluded.is_saved), updated_at = excluded.updated_at
+		WHERE is_read < excluded.is_read OR is_saved < excluded.is_saved
+	`, reader.userId(), postId, read, saved)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
@@ -0,0 +1,76 @@
+package reader
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestImportHistoryKeepsItemsPrivate(t *testing.T) {
+	reader := newTestReader(t)
+	server, _ := serveTestFeed(t)
+	bob, err := reader.ProvisionUser("bob", "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	admin, other := reader.As(&reader.config.Users[0]), reader.As(bob)
+	for _, user := range []*Reader{admin, other} {
+		categoryId, err := user.DefaultCategoryId()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := user.CreateFeed("rss", "Test", "", server.URL, categoryId); err != nil {
+			t.Fatal(err)
+		}
+	}
+	export := fmt.Sprintf(`{"total": 2, "entries": [{
+		"title": "Shared", "url": "https://example.com/1", "content": "<p onclick=\"x()\">Hi<script>alert(1)</script></p>",
+		"status": "read", "starred": true, "feed": {"title": "Test", "feed_url": %q}
+	}, {
+		"title": "New", "url": "https://example.org/1", "content": "body", "status": "read",
+		"feed": {"title": "Other", "site_url": "https://example.org/", "feed_url": "https://example.org/feed"}
+	}]}`, server.URL)
+	report, err := admin.ImportHistory([]byte(export))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Posts != 2 || report.Feeds != 1 {
+		t.Errorf("report: %+v", report)
+	}
+	rows, err := reader.db.Query(`
+		SELECT p.link, p.content, f.owner_id, (SELECT COUNT(*) FROM subscriptions WHERE feed_id = f.id AND user_id = ?)
+		FROM posts p JOIN feeds f ON f.id = p.feed_id`, bob.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	posts := 0
+	for rows.Next() {
+		posts++
+		var link, content string
+		var owner, bobs int
+		if err := rows.Scan(&link, &content, &owner, &bobs); err != nil {
+			t.Fatal(err)
+		}
+		if owner != admin.userId() || bobs != 0 {
+			t.Errorf("%s imported into a feed of owner %d with %d subscriptions of bob", link, owner, bobs)
+		}
+		if link == "https://example.com/1" && content != "<p>Hi</p>" {
+			t.Errorf("imported content not sanitized: %q", content)
+		}
+	}
+	if err := rows.Err(); err != nil || posts != 2 {
+		t.Fatalf("%d posts: %v", posts, err)
+	}
+	// The feeds themselves stay shared, so each is still fetched once.
+	var private int
+	if err := reader.db.QueryRow(`SELECT COUNT(*) FROM feeds WHERE owner_id != 0 AND link NOT LIKE 'history:%'`).Scan(&private); err != nil || private != 0 {
+		t.Errorf("%d feeds taken private: %v", private, err)
+	}
+	var shared int
+	err = reader.db.QueryRow(`
+		SELECT COUNT(*) FROM subscriptions sub JOIN feeds f ON f.id = sub.feed_id
+		WHERE sub.user_id = ? AND f.link = 'https://example.org/feed' AND f.owner_id = 0 AND NOT sub.disabled`, admin.userId()).Scan(&shared)
+	if err != nil || shared != 1 {
+		t.Errorf("not subscribed to the shared imported feed: %v", err)
+	}
+}
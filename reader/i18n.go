@@ -0,0 +1,258 @@
+package reader
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultLanguage is used when no catalog matches, and fills in messages
+// missing from the other catalogs.
+const defaultLanguage = "en"
+
+// locale is a message catalog loaded from templates/locales/<lang>.json.
+// Messages are fmt formats; counted messages have ".one" and ".other"
+// variants, a catalog without plural forms only needs ".other".
+type locale struct {
+	Lang     string
+	Name     string
+	messages map[string]string
+}
+
+func loadLocales(files fs.FS) (map[string]*locale, error) {
+	names, err := fs.Glob(files, "locales/*.json")
+	if err != nil {
+		return nil, err
+	}
+	catalogs := make(map[string]map[string]string)
+	for _, name := range names {
+		data, err := fs.ReadFile(files, name)
+		if err != nil {
+			return nil, err
+		}
+		var messages map[string]string
+		if err := json.Unmarshal(data, &messages); err != nil {
+			return nil, fmt.Errorf("parse %s: %w", name, err)
+		}
+		catalogs[strings.TrimSuffix(path.Base(name), ".json")] = messages
+	}
+	base, ok := catalogs[defaultLanguage]
+	if !ok {
+		return nil, fmt.Errorf("missing catalog locales/%s.json", defaultLanguage)
+	}
+	locales := make(map[string]*locale)
+	for lang, own := range catalogs {
+		messages := make(map[string]string, len(base))
+		for key, message := range base {
+			if other, ok := own[strings.TrimSuffix(key, ".one")+".other"]; ok && strings.HasSuffix(key, ".one") {
+				message = other
+			}
+			messages[key] = message
+		}
+		for key, message := range own {
+			messages[key] = message
+		}
+		locales[lang] = &locale{Lang: lang, Name: messages["language.name"], messages: messages}
+	}
+	return locales, nil
+}
+
+// T formats the message for key, or returns the key if there is none.
+func (l *locale) T(key string, args ...any) string {
+	message, ok := l.messages[key]
+	if !ok {
+		return key
+	}
+	if len(args) == 0 {
+		return message
+	}
+	return fmt.Sprintf(message, args...)
+}
+
+// N formats the ".one" or ".other" message for key with n and args.
+func (l *locale) N(key string, n int, args ...any) string {
+	if n == 1 {
+		key += ".one"
+	} else {
+		key += ".other"
+	}
+	return l.T(key, append([]any{n}, args...)...)
+}
+
+// Date formats t with the catalog's date and time layout.
+func (l *locale) Date(t time.Time) string {
+	return t.Local().Format(l.T("format.datetime"))
+}
+
+// Ago describes t relative to now, falling back to the date after a month.
+func (l *locale) Ago(t time.Time) string {
+	since := time.Since(t)
+	switch {
+	case since < 0:
+		return l.Date(t)
+	case since < time.Minute:
+		return l.T("time.now")
+	case since < time.Hour:
+		return l.N("time.minutes", int(since/time.Minute))
+	case since < 24*time.Hour:
+		return l.N("time.hours", int(since/time.Hour))
+	case since < 30*24*time.Hour:
+		return l.N("time.days", int(since/(24*time.Hour)))
+	}
+	return t.Local().Format(l.T("format.date"))
+}
+
+// scriptMessages are the catalog keys that page scripts format with t and
+// tn, and the only ones embedded in every page. Counted messages are listed
+// without their ".one" and ".other" suffix.
+var scriptMessages = []string{
+	"action.undo",
+	"offline.empty",
+	"offline.offline",
+	"offline.online",
+	"offline.pending",
+	"offline.saved",
+	"offline.syncing",
+	"post.keep_unread",
+	"post.mark_read",
+	"post.save",
+	"post.unsave",
+	"posts.fetch_failed",
+	"posts.marked",
+	"read.empty",
+	"refresh.progress",
+}
+
+// scriptMessages returns the messages of scriptMessages.
+func (l *locale) scriptMessages() map[string]string {
+	messages := make(map[string]string)
+	for _, key := range scriptMessages {
+		for _, variant := range []string{key, key + ".one", key + ".other"} {
+			if message, ok := l.messages[variant]; ok {
+				messages[variant] = message
+			}
+		}
+	}
+	return messages
+}
+
+// funcs are the template functions bound to the locale.
+func (l *locale) funcs() template.FuncMap {
+	return template.FuncMap{
+		"t":        l.T,
+		"tn":       l.N,
+		"date":     l.Date,
+		"ago":      l.Ago,
+		"messages": l.scriptMessages,
+	}
+}
+
+// parseAcceptLanguage returns the language tags of an Accept-Language
+// header, most preferred first.
+func parseAcceptLanguage(header string) []string {
+	type weighted struct {
+		tag string
+		q   float64
+	}
+	var tags []weighted
+	for _, part := range strings.Split(header, ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		if tag == "" || tag == "*" {
+			continue
+		}
+		q := 1.0
+		if value, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			var err error
+			if q, err = strconv.ParseFloat(value, 64); err != nil {
+				continue
+			}
+		}
+		if q > 0 {
+			tags = append(tags, weighted{tag, q})
+		}
+	}
+	sort.SliceStable(tags, func(i, j int) bool { return tags[i].q > tags[j].q })
+	result := make([]string, len(tags))
+	for i, tag := range tags {
+		result[i] = tag.tag
+	}
+	return result
+}
+
+// match returns the first catalog matching one of the tags exactly, or by
+// primary language, so that "zh" and "zh-Hans-CN" pick "zh-CN".
+func (v *views) match(tags ...string) string {
+	langs := v.languages()
+	for _, tag := range tags {
+		for _, lang := range langs {
+			if strings.EqualFold(lang, tag) {
+				return lang
+			}
+		}
+		primary, _, _ := strings.Cut(tag, "-")
+		for _, lang := range langs {
+			candidate, _, _ := strings.Cut(lang, "-")
+			if strings.EqualFold(candidate, primary) {
+				return lang
+			}
+		}
+	}
+	return defaultLanguage
+}
+
+// languages lists the catalog names, sorted.
+func (v *views) languages() []string {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	langs := make([]string, 0, len(v.locales))
+	for lang := range v.locales {
+		langs = append(langs, lang)
+	}
+	sort.Strings(langs)
+	return langs
+}
+
+// language picks the user's language, then the browser's.
+func (reader *Reader) language() string {
+	if lang := reader.UserLanguage(); lang != "" {
+		return reader.views.match(lang)
+	}
+	return reader.views.match(parseAcceptLanguage(reader.acceptLanguage)...)
+}
+
+// UserLanguage returns the user's chosen language, empty to follow the browser.
+func (reader *Reader) UserLanguage() (lang string) {
+	if reader.user == nil {
+		return ""
+	}
+	reader.db.QueryRow("SELECT COALESCE(language, '') FROM users WHERE id = ?", reader.userId()).Scan(&lang)
+	return
+}
+
+// SetUserLanguage stores the user's language, empty to follow the browser.
+func (reader *Reader) SetUserLanguage(lang string) error {
+	if lang != "" {
+		if _, ok := reader.views.locale(lang); !ok {
+			return fmt.Errorf("unknown language: %q", lang)
+		}
+	}
+	_, err := reader.db.Exec("UPDATE users SET language = NULLIF(?, '') WHERE id = ?", lang, reader.userId())
+	return err
+}
+
+// Languages returns every available catalog for the settings page.
+func (reader *Reader) Languages() []*locale {
+	var locales []*locale
+	for _, lang := range reader.views.languages() {
+		if l, ok := reader.views.locale(lang); ok {
+			locales = append(locales, l)
+		}
+	}
+	return locales
+}
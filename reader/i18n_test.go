@@ -0,0 +1,31 @@
+package reader
+
+import (
+	"io/fs"
+	"regexp"
+	"slices"
+	"testing"
+
+	"github.com/lsongdev/feedreader/templates"
+)
+
+// TestScriptMessages checks that every message formatted by a page script
+// is embedded in the pages.
+func TestScriptMessages(t *testing.T) {
+	call := regexp.MustCompile(`\btn?\('([a-z_.]+)'`)
+	names, err := fs.Glob(templates.Files, "*.[hj][ts]*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range names {
+		data, err := fs.ReadFile(templates.Files, name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, match := range call.FindAllStringSubmatch(string(data), -1) {
+			if !slices.Contains(scriptMessages, match[1]) {
+				t.Errorf("%s: %q is missing from scriptMessages", name, match[1])
+			}
+		}
+	}
+}
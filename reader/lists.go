@@ -0,0 +1,308 @@
+package reader
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/lsongdev/feedreader/feed"
+)
+
+// listSyncInterval is how often feed lists are fetched again.
+const listSyncInterval = time.Hour
+
+// What a sync does with the subscriptions of feeds dropped from a list.
+const (
+	// ListKeep keeps them as ordinary subscriptions.
+	ListKeep = "keep"
+	// ListDisable stops fetching them until they are listed again.
+	ListDisable = "disable"
+	// ListRemove unsubscribes from them.
+	ListRemove = "remove"
+)
+
+// FeedList is a remote OPML file, typically shared by a team, whose feeds
+// the user stays subscribed to in one category.
+type FeedList struct {
+	Id        int        `json:"id"`
+	Name      string     `json:"name"`
+	URL       string     `json:"url,omitempty"`
+	Category  *Category  `json:"category,omitempty"`
+	Dropped   string     `json:"dropped,omitempty"`
+	Feeds     int        `json:"feed_count"`
+	SyncedAt  *time.Time `json:"synced_at,omitempty"`
+	SyncError string     `json:"sync_error,omitempty"`
+}
+
+// CreateList subscribes to a remote OPML file and syncs it in the background.
+func (reader *Reader) CreateList(name, link string, categoryId int, dropped string) (id int, err error) {
+	if u, err := url.Parse(link); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return 0, fmt.Errorf("%q is not an http(s) URL", link)
+	}
+	if dropped != ListKeep && dropped != ListDisable && dropped != ListRemove {
+		return 0, fmt.Errorf("unknown action for dropped feeds: %q", dropped)
+	}
+	if err = reader.checkCategory(categoryId); err != nil {
+		return
+	}
+	if name == "" {
+		name = link
+	}
+	err = reader.db.QueryRow(`
+		INSERT INTO feed_lists (user_id, name, url, category_id, dropped) VALUES (?, ?, ?, ?, ?) RETURNING id
+	`, reader.userId(), name, link, categoryId, dropped).Scan(&id)
+	if err != nil {
+		return
+	}
+	go reader.SyncList(id)
+	return
+}
+
+// GetLists returns the user's feed lists with the number of feeds each one
+// manages.
+func (reader *Reader) GetLists() (lists []*FeedList, err error) {
+	rows, err := reader.db.Query(`
+		SELECT l.id, l.name, l.url, g.id, g.name, l.dropped, l.synced_at, l.sync_error,
+			(SELECT COUNT(*) FROM subscriptions sub WHERE sub.list_id = l.id)
+		FROM feed_lists l JOIN categories g ON g.id = l.category_id
+		WHERE l.user_id = ?
+		ORDER BY l.id`, reader.userId())
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		list := FeedList{Category: &Category{}}
+		var syncedAt sql.NullTime
+		var syncError sql.NullString
+		err := rows.Scan(&list.Id, &list.Name, &list.URL, &list.Category.Id, &list.Category.Name, &list.Dropped,
+			&syncedAt, &syncError, &list.Feeds)
+		if err != nil {
+			return nil, err
+		}
+		if syncedAt.Valid {
+			list.SyncedAt = &syncedAt.Time
+		}
+		list.SyncError = syncError.String
+		lists = append(lists, &list)
+	}
+	return lists, rows.Err()
+}
+
+// DeleteList stops syncing a list. Its feeds stay subscribed.
+func (reader *Reader) DeleteList(id int) error {
+	res, err := reader.db.Exec("DELETE FROM feed_lists WHERE id = ? AND user_id = ?", id, reader.userId())
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("list %d not found", id)
+	}
+	_, err = reader.db.Exec(
+		"UPDATE subscriptions SET list_id = NULL, list_link = NULL WHERE list_id = ? AND user_id = ?", id, reader.userId(),
+	)
+	return err
+}
+
+// SyncList fetches a list, subscribes to the feeds added to it and handles
+// the ones dropped from it. Feeds the user already follows on their own are
+// left alone.
+func (reader *Reader) SyncList(id int) error {
+	var link, dropped, category string
+	var categoryId int
+	err := reader.db.QueryRow(`
+		SELECT l.url, l.dropped, l.category_id, g.name
+		FROM feed_lists l JOIN categories g ON g.id = l.category_id
+		WHERE l.id = ? AND l.user_id = ?`, id, reader.userId()).Scan(&link, &dropped, &categoryId, &category)
+	if err != nil {
+		return err
+	}
+	added, removed, err := reader.syncList(id, link, dropped, importItem{category: category, categoryId: categoryId})
+	var syncError any
+	if err != nil {
+		syncError = err.Error()
+		log.Printf("Error syncing list %d: %v", id, err)
+	} else {
+		log.Printf("Synced list %d: %d feeds added, %d dropped", id, added, removed)
+	}
+	reader.db.Exec("UPDATE feed_lists SET synced_at = ?, sync_error = ? WHERE id = ?", time.Now().UTC(), syncError, id)
+	return err
+}
+
+func (reader *Reader) syncList(id int, link, dropped string, target importItem) (added, removed int, err error) {
+	opml, err := feed.FetchOPML(link, nil)
+	if err != nil {
+		return
+	}
+	// The managed subscriptions by the link they are listed with, which
+	// differs from the feed's link when it was discovered from a page.
+	managed := map[string]int{}
+	rows, err := reader.db.Query(`
+		SELECT sub.feed_id, COALESCE(sub.list_link, f.link)
+		FROM subscriptions sub JOIN feeds f ON f.id = sub.feed_id
+		WHERE sub.list_id = ? AND sub.user_id = ?`, id, reader.userId())
+	if err != nil {
+		return
+	}
+	for rows.Next() {
+		var feedId int
+		var listed string
+		if err = rows.Scan(&feedId, &listed); err != nil {
+			rows.Close()
+			return
+		}
+		managed[listed] = feedId
+	}
+	rows.Close()
+
+	var subscribing sync.Mutex
+	listed := map[string]bool{}
+	for _, item := range listItems(opml.Outlines, target) {
+		listed[item.link] = true
+		if feedId, ok := managed[item.link]; ok {
+			// Only feeds this list disabled are enabled again, not the ones
+			// the user paused.
+			reader.db.Exec(`
+				UPDATE subscriptions SET disabled = 0, disabled_by_list = 0
+				WHERE feed_id = ? AND user_id = ? AND disabled_by_list = 1
+			`, feedId, reader.userId())
+			continue
+		}
+		outcome, result := reader.importFeed(item, &subscribing)
+		switch outcome {
+		case ImportAdded:
+			added++
+			reader.db.Exec(`
+				UPDATE subscriptions SET list_id = ?, list_link = ?
+				WHERE user_id = ? AND feed_id IN (SELECT id FROM feeds WHERE link = ?)
+			`, id, item.link, reader.userId(), result.URL)
+		case ImportFailed:
+			log.Printf("List %d: cannot subscribe to %s: %s", id, item.link, result.Error)
+		}
+	}
+	for link, feedId := range managed {
+		if listed[link] {
+			continue
+		}
+		removed++
+		switch dropped {
+		case ListRemove:
+			err = reader.DeleteFeed(strconv.Itoa(feedId))
+		case ListDisable:
+			_, err = reader.db.Exec(`
+				UPDATE subscriptions SET disabled = 1, disabled_by_list = 1
+				WHERE feed_id = ? AND user_id = ? AND disabled = 0
+			`, feedId, reader.userId())
+		default:
+			_, err = reader.db.Exec(
+				"UPDATE subscriptions SET list_id = NULL, list_link = NULL WHERE feed_id = ? AND user_id = ?", feedId, reader.userId(),
+			)
+		}
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// listItems flattens every feed of a list, ignoring its folders, into the
+// list's category.
+func listItems(outlines []feed.Outline, target importItem) (items []importItem) {
+	for _, outline := range outlines {
+		link := outline.XMLURL
+		if link == "" && len(outline.Outlines) == 0 {
+			link = outline.HTMLURL
+		}
+		if link == "" {
+			items = append(items, listItems(outline.Outlines, target)...)
+			continue
+		}
+		item := target
+		item.outline, item.link = outline, link
+		items = append(items, item)
+	}
+	return
+}
+
+// syncListsPeriodically syncs the lists that are due every minute. It runs
+// apart from the feed refresh, so a slow list server never delays it.
+func (reader *Reader) syncListsPeriodically() {
+	for range time.Tick(time.Minute) {
+		reader.syncDueLists()
+	}
+}
+
+// syncDueLists syncs the lists of every user not synced for listSyncInterval.
+func (reader *Reader) syncDueLists() {
+	rows, err := reader.db.Query(`
+		SELECT l.id, u.username FROM feed_lists l JOIN users u ON u.id = l.user_id
+		WHERE l.synced_at IS NULL OR l.synced_at < ?`, time.Now().UTC().Add(-listSyncInterval))
+	if err != nil {
+		log.Println("Error getting feed lists:", err)
+		return
+	}
+	due := map[int]string{}
+	for rows.Next() {
+		var id int
+		var username string
+		if rows.Scan(&id, &username) == nil {
+			due[id] = username
+		}
+	}
+	rows.Close()
+	for id, username := range due {
+		if user := reader.FindUser(username); user != nil {
+			reader.As(user).SyncList(id)
+		}
+	}
+}
+
+// ListsView shows the user's feed lists and handles adding, syncing and
+// deleting them.
+func (reader *Reader) ListsView(w http.ResponseWriter, r *http.Request) {
+	reader = reader.forRequest(r)
+	if r.Method == "POST" {
+		if !reader.CheckAuth(w, r) {
+			return
+		}
+		id, _ := strconv.Atoi(r.FormValue("id"))
+		var err error
+		switch r.FormValue("action") {
+		case "create":
+			categoryId, _ := strconv.Atoi(r.FormValue("category"))
+			_, err = reader.CreateList(r.FormValue("name"), r.FormValue("url"), categoryId, r.FormValue("dropped"))
+		case "sync":
+			err = reader.SyncList(id)
+		case "delete":
+			err = reader.DeleteList(id)
+		default:
+			err = fmt.Errorf("unknown action: %q", r.FormValue("action"))
+		}
+		if err != nil {
+			reader.Error(w, err)
+			return
+		}
+		http.Redirect(w, r, "/lists", http.StatusFound)
+		return
+	}
+	lists, err := reader.GetLists()
+	if err != nil {
+		reader.Error(w, err)
+		return
+	}
+	categories, err := reader.GetCategories()
+	if err != nil {
+		reader.Error(w, err)
+		return
+	}
+	reader.Render(w, "lists", H{
+		"lists":      lists,
+		"categories": categories,
+		"dropped":    []string{ListKeep, ListDisable, ListRemove},
+	})
+}
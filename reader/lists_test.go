@@ -0,0 +1,75 @@
+package reader
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+)
+
+func TestListResumesOnlyFeedsItDisabled(t *testing.T) {
+	reader := newTestReader(t)
+	admin := reader.As(&reader.config.Users[0])
+	listed := true
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/list.opml" {
+			w.Header().Set("Content-Type", "application/rss+xml")
+			fmt.Fprintf(w, `<?xml version="1.0"?><rss version="2.0"><channel><title>%s</title><link>https://example.com/</link></channel></rss>`, r.URL.Path)
+			return
+		}
+		fmt.Fprint(w, `<?xml version="1.0"?><opml version="2.0"><body>`)
+		if listed {
+			fmt.Fprintf(w, `<outline text="A" xmlUrl="%[1]s/a"/><outline text="B" xmlUrl="%[1]s/b"/>`, "http://"+r.Host)
+		}
+		fmt.Fprint(w, `</body></opml>`)
+	}))
+	t.Cleanup(server.Close)
+	categoryId, err := admin.DefaultCategoryId()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var id int
+	err = reader.db.QueryRow(`
+		INSERT INTO feed_lists (user_id, name, url, category_id, dropped) VALUES (?, 'Team', ?, ?, ?) RETURNING id
+	`, admin.userId(), server.URL+"/list.opml", categoryId, ListDisable).Scan(&id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := admin.SyncList(id); err != nil {
+		t.Fatal(err)
+	}
+	disabled := func(path string) (feedId int, disabled bool) {
+		t.Helper()
+		err := reader.db.QueryRow(`
+			SELECT sub.feed_id, sub.disabled FROM subscriptions sub JOIN feeds f ON f.id = sub.feed_id
+			WHERE sub.user_id = ? AND f.link = ?`, admin.userId(), server.URL+path).Scan(&feedId, &disabled)
+		if err != nil {
+			t.Fatalf("%s: %v", path, err)
+		}
+		return
+	}
+	paused, _ := disabled("/a")
+	yes := true
+	if _, err := admin.UpdateFeed(strconv.Itoa(paused), FeedUpdate{Disabled: &yes}); err != nil {
+		t.Fatal(err)
+	}
+
+	listed = false
+	if err := admin.SyncList(id); err != nil {
+		t.Fatal(err)
+	}
+	if _, off := disabled("/b"); !off {
+		t.Error("a feed dropped from the list is still enabled")
+	}
+	listed = true
+	if err := admin.SyncList(id); err != nil {
+		t.Fatal(err)
+	}
+	if _, off := disabled("/b"); off {
+		t.Error("a feed listed again is still disabled")
+	}
+	if _, off := disabled("/a"); !off {
+		t.Error("a feed paused by hand was enabled by the list")
+	}
+}
@@ -0,0 +1,233 @@
+package reader
+
+import (
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// undoTTL is how long a bulk mark can be undone.
+const undoTTL = 10 * time.Minute
+
+// markUndo remembers the posts changed by a bulk mark.
+type markUndo struct {
+	userId  int
+	ids     []int
+	expires time.Time
+}
+
+// undoStore keeps recent bulk marks in memory, keyed by a random token.
+type undoStore struct {
+	mu      sync.Mutex
+	entries map[string]*markUndo
+}
+
+func newUndoStore() *undoStore {
+	return &undoStore{entries: make(map[string]*markUndo)}
+}
+
+func (store *undoStore) add(userId int, ids []int) string {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	now := time.Now()
+	for token, entry := range store.entries {
+		if now.After(entry.expires) {
+			delete(store.entries, token)
+		}
+	}
+	token := randomString(16)
+	store.entries[token] = &markUndo{userId: userId, ids: ids, expires: now.Add(undoTTL)}
+	return token
+}
+
+// get returns the user's pending undo, optionally removing it.
+func (store *undoStore) get(token string, userId int, remove bool) *markUndo {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	entry, ok := store.entries[token]
+	if !ok || entry.userId != userId || time.Now().After(entry.expires) {
+		return nil
+	}
+	if remove {
+		delete(store.entries, token)
+	}
+	return entry
+}
+
+// MarkAllRead marks the unread posts matching the conditions as read and
+// returns their ids, so the change can be undone.
+func (reader *Reader) MarkAllRead(conditions []string, args ...any) ([]int, error) {
+	ids, err := reader.PostIds(append(conditions, "p.is_read = 0"), args...)
+	if err != nil || len(ids) == 0 {
+		return nil, err
+	}
+	err = reader.UpdatePosts([]string{fmt.Sprintf("p.id IN (%s)", joinIds(ids))}, []string{"is_read = 1"})
+	return ids, err
+}
+
+// postConditions turns the unread, readed, saved, feed, category, tag and
+// q (search) filters of a query into GetPosts conditions and their arguments.
+func postConditions(query url.Values) (conditions []string, args []any, err error) {
+	if query.Has("unread") {
+		conditions = append(conditions, "is_read = 0")
+	}
+	if query.Has("readed") {
+		conditions = append(conditions, "is_read = 1")
+	}
+	if query.Has("saved") {
+		conditions = append(conditions, "is_saved = 1")
+	}
+	for _, name := range []string{"feed", "category"} {
+		if !query.Has(name) {
+			continue
+		}
+		id, err := strconv.Atoi(query.Get(name))
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid %s id", name)
+		}
+		column := "s.id"
+		if name == "category" {
+			column = "g.id"
+		}
+		conditions = append(conditions, fmt.Sprintf("%s = %d", column, id))
+	}
+	// Tags are category names, as labels are in the Google Reader API.
+	if query.Has("tag") {
+		conditions = append(conditions, "g.name = ?")
+		args = append(args, query.Get("tag"))
+	}
+	if q := query.Get("q"); q != "" {
+		conditions = append(conditions, `(p.title LIKE ? ESCAPE '\' OR p.content LIKE ? ESCAPE '\')`)
+		args = append(args, likePattern(q), likePattern(q))
+	}
+	return
+}
+
+// postFilterQuery keeps only the filters understood by postConditions.
+func postFilterQuery(query url.Values) string {
+	filter := url.Values{}
+	for _, name := range []string{"unread", "readed", "saved", "feed", "category", "tag", "q"} {
+		if query.Has(name) {
+			filter.Set(name, query.Get(name))
+		}
+	}
+	return filter.Encode()
+}
+
+// filterURL marks an encoded filter query as safe to place after "?" in a
+// template, which would otherwise escape its "=" and "&".
+func filterURL(filter string) template.URL {
+	return template.URL(filter)
+}
+
+// pageURI returns the request's URI without a pending undo token.
+func pageURI(r *http.Request) string {
+	query := r.URL.Query()
+	query.Del("undo")
+	if len(query) == 0 {
+		return r.URL.Path
+	}
+	return r.URL.Path + "?" + query.Encode()
+}
+
+// undoData returns the template data for the undo notice of a bulk mark.
+func (reader *Reader) undoData(r *http.Request, data H) H {
+	token := r.URL.Query().Get("undo")
+	if token == "" {
+		return data
+	}
+	if entry := reader.undo.get(token, reader.userId(), false); entry != nil {
+		data["undo"] = token
+		data["marked"] = len(entry.ids)
+	}
+	return data
+}
+
+// MarkView toggles the read and saved state of a post, marks every post
+// matching the filters in its query as read, or undoes such a bulk mark.
+// Scripts asking for JSON get the outcome instead of a redirect.
+func (reader *Reader) MarkView(w http.ResponseWriter, r *http.Request) {
+	reader = reader.forRequest(r)
+	if !requireMethod(w, r, "POST") {
+		return
+	}
+	if !reader.CheckAuth(w, r) {
+		return
+	}
+	fail := func(err error) {
+		if wantsJson(r) {
+			reader.apiJson(w, http.StatusBadRequest, H{"error": err.Error()})
+			return
+		}
+		reader.Error(w, err)
+	}
+	next := safeRedirect(r.FormValue("next"))
+	result := H{}
+	action := r.FormValue("action")
+	switch action {
+	case "read", "unread", "save", "unsave":
+		updates := map[string]string{
+			"read":   "is_read = 1",
+			"unread": "is_read = 0",
+			"save":   "is_saved = 1",
+			"unsave": "is_saved = 0",
+		}
+		if err := reader.UpdatePost(r.FormValue("id"), []string{updates[action]}); err != nil {
+			fail(err)
+			return
+		}
+	case "all":
+		conditions, args, err := postConditions(r.URL.Query())
+		if err != nil {
+			fail(err)
+			return
+		}
+		if days, err := formInt(r, "older_than"); err != nil || days < 0 {
+			fail(fmt.Errorf("invalid older_than: %q", r.FormValue("older_than")))
+			return
+		} else if days > 0 {
+			before := time.Now().AddDate(0, 0, -days).Unix()
+			conditions = append(conditions, fmt.Sprintf("CAST(strftime('%%s', p.pub_date) AS INTEGER) < %d", before))
+		}
+		ids, err := reader.MarkAllRead(conditions, args...)
+		if err != nil {
+			fail(err)
+			return
+		}
+		result["marked"] = len(ids)
+		if len(ids) > 0 {
+			token := reader.undo.add(reader.userId(), ids)
+			result["undo"] = token
+			separator := "?"
+			if strings.Contains(next, "?") {
+				separator = "&"
+			}
+			next += separator + "undo=" + token
+		}
+	case "undo":
+		entry := reader.undo.get(r.FormValue("token"), reader.userId(), true)
+		if entry == nil {
+			fail(fmt.Errorf("nothing to undo"))
+			return
+		}
+		err := reader.UpdatePosts([]string{fmt.Sprintf("p.id IN (%s)", joinIds(entry.ids))}, []string{"is_read = 0"})
+		if err != nil {
+			fail(err)
+			return
+		}
+		result["restored"] = len(entry.ids)
+	default:
+		fail(fmt.Errorf("unknown action: %q", action))
+		return
+	}
+	if wantsJson(r) {
+		reader.apiJson(w, http.StatusOK, result)
+		return
+	}
+	http.Redirect(w, r, next, http.StatusFound)
+}
@@ -0,0 +1,42 @@
+package reader
+
+import (
+	"net/url"
+	"testing"
+)
+
+func TestPostConditionsSearch(t *testing.T) {
+	reader := newTestReader(t)
+	user := reader.As(&reader.config.Users[0])
+	feedId, _ := addTestPost(t, user)
+	if _, err := reader.db.Exec(`
+		INSERT INTO posts (entry_id, title, content, link, pub_date, feed_id)
+		VALUES ('2', '50% off', 'it''s on sale', 'https://example.com/2', '2021-01-01 00:00:00', ?)
+	`, feedId); err != nil {
+		t.Fatal(err)
+	}
+	for query, want := range map[string]int{
+		"q=50%25":          1,
+		"q=_":              0,
+		"q=%25":            1,
+		"q=it's":           1,
+		"q=post":           1,
+		"tag=it's":         0,
+		"tag=Default&q=50": 1,
+	} {
+		values, _ := url.ParseQuery(query)
+		conditions, args, err := postConditions(values)
+		if err != nil {
+			t.Errorf("%s: %v", query, err)
+			continue
+		}
+		posts, err := user.GetPosts(conditions, nil, args...)
+		if err != nil {
+			t.Errorf("%s: %v", query, err)
+			continue
+		}
+		if len(posts) != want {
+			t.Errorf("%s: %d posts, want %d", query, len(posts), want)
+		}
+	}
+}
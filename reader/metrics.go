@@ -0,0 +1,59 @@
+package reader
+
+import (
+	"sync"
+	"time"
+)
+
+// EndpointStats holds request counters and latency for a single endpoint.
+type EndpointStats struct {
+	Count   int64   `json:"count"`
+	Errors  int64   `json:"errors"`
+	TotalMs float64 `json:"total_ms"`
+	MaxMs   float64 `json:"max_ms"`
+	AvgMs   float64 `json:"avg_ms"`
+}
+
+// Metrics collects per-endpoint request statistics.
+type Metrics struct {
+	mu        sync.Mutex
+	endpoints map[string]*EndpointStats
+}
+
+func NewMetrics() *Metrics {
+	return &Metrics{
+		endpoints: make(map[string]*EndpointStats),
+	}
+}
+
+// Observe records one request against the named endpoint.
+func (m *Metrics) Observe(endpoint string, elapsed time.Duration, failed bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	stats, ok := m.endpoints[endpoint]
+	if !ok {
+		stats = &EndpointStats{}
+		m.endpoints[endpoint] = stats
+	}
+	ms := float64(elapsed) / float64(time.Millisecond)
+	stats.Count++
+	stats.TotalMs += ms
+	if ms > stats.MaxMs {
+		stats.MaxMs = ms
+	}
+	if failed {
+		stats.Errors++
+	}
+	stats.AvgMs = stats.TotalMs / float64(stats.Count)
+}
+
+// Snapshot returns a copy of the current statistics.
+func (m *Metrics) Snapshot() map[string]EndpointStats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	out := make(map[string]EndpointStats, len(m.endpoints))
+	for name, stats := range m.endpoints {
+		out[name] = *stats
+	}
+	return out
+}
@@ -0,0 +1,489 @@
+package reader
+
+import (
+	"crypto/md5"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const nextcloudPrefix = "/index.php/apps/news/api/v1-3/"
+
+// Nextcloud News item selection types.
+const (
+	nextcloudTypeFeed    = 0
+	nextcloudTypeFolder  = 1
+	nextcloudTypeStarred = 2
+	nextcloudTypeAll     = 3
+)
+
+type NextcloudFolder struct {
+	Id   int    `json:"id"`
+	Name string `json:"name"`
+}
+
+type NextcloudFeed struct {
+	Id               int    `json:"id"`
+	Url              string `json:"url"`
+	Title            string `json:"title"`
+	FaviconLink      string `json:"faviconLink"`
+	Added            int64  `json:"added"`
+	FolderId         int    `json:"folderId"`
+	UnreadCount      int    `json:"unreadCount"`
+	Ordering         int    `json:"ordering"`
+	Link             string `json:"link"`
+	Pinned           bool   `json:"pinned"`
+	UpdateErrorCount int    `json:"updateErrorCount"`
+	LastUpdateError  string `json:"lastUpdateError"`
+}
+
+type NextcloudItem struct {
+	Id            int    `json:"id"`
+	Guid          string `json:"guid"`
+	GuidHash      string `json:"guidHash"`
+	Url           string `json:"url"`
+	Title         string `json:"title"`
+	Author        string `json:"author"`
+	PubDate       int64  `json:"pubDate"`
+	UpdatedDate   int64  `json:"updatedDate"`
+	Body          string `json:"body"`
+	EnclosureMime string `json:"enclosureMime"`
+	EnclosureLink string `json:"enclosureLink"`
+	FeedId        int    `json:"feedId"`
+	Unread        bool   `json:"unread"`
+	Starred       bool   `json:"starred"`
+	Rtl           bool   `json:"rtl"`
+	LastModified  int64  `json:"lastModified"`
+	Fingerprint   string `json:"fingerprint"`
+}
+
+// nextcloudParams holds the union of parameters accepted by the write endpoints.
+type nextcloudParams struct {
+	Name         string `json:"name"`
+	Url          string `json:"url"`
+	FolderId     int    `json:"folderId"`
+	FeedTitle    string `json:"feedTitle"`
+	NewestItemId int    `json:"newestItemId"`
+	ItemIds      []int  `json:"itemIds"`
+}
+
+// nextcloudError is returned by endpoint handlers to choose the status code.
+type nextcloudError struct {
+	status int
+	err    error
+}
+
+func (e *nextcloudError) Error() string {
+	return e.err.Error()
+}
+
+func nextcloudNotFound(format string, args ...any) error {
+	return &nextcloudError{http.StatusNotFound, fmt.Errorf(format, args...)}
+}
+
+func nextcloudBadRequest(format string, args ...any) error {
+	return &nextcloudError{http.StatusUnprocessableEntity, fmt.Errorf(format, args...)}
+}
+
+func (reader *Reader) nextcloudJson(w http.ResponseWriter, data any) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(w).Encode(data)
+}
+
+func nextcloudReadParams(r *http.Request) (params nextcloudParams, err error) {
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		if err = json.NewDecoder(r.Body).Decode(&params); err != nil {
+			return params, nextcloudBadRequest("invalid body: %v", err)
+		}
+		return
+	}
+	r.ParseForm()
+	params.Name = r.Form.Get("name")
+	params.Url = r.Form.Get("url")
+	params.FeedTitle = r.Form.Get("feedTitle")
+	params.FolderId, _ = strconv.Atoi(r.Form.Get("folderId"))
+	params.NewestItemId, _ = strconv.Atoi(r.Form.Get("newestItemId"))
+	for _, id := range r.Form["itemIds"] {
+		if n, err := strconv.Atoi(id); err == nil {
+			params.ItemIds = append(params.ItemIds, n)
+		}
+	}
+	return
+}
+
+// NextcloudView serves the Nextcloud News API v1.3.
+func (reader *Reader) NextcloudView(w http.ResponseWriter, r *http.Request) {
+	user := reader.BasicAuthUser(r)
+	if user == nil {
+		w.Header().Set("WWW-Authenticate", "Basic realm=Restricted")
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	reader = reader.As(user)
+	start := time.Now()
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, nextcloudPrefix), "/")
+	segments := strings.Split(path, "/")
+	// endpoint replaces numeric ids with {id} so it can be used as a route key.
+	var ids []int
+	for i, segment := range segments {
+		if id, err := strconv.Atoi(segment); err == nil {
+			ids = append(ids, id)
+			segments[i] = "{id}"
+		}
+	}
+	endpoint := strings.Join(segments, "/")
+	id := 0
+	if len(ids) > 0 {
+		id = ids[0]
+	}
+	var err error
+	switch r.Method + " " + endpoint {
+	case "GET version":
+		reader.nextcloudJson(w, H{"version": "1.3.0"})
+	case "GET status":
+		reader.nextcloudJson(w, H{
+			"version": "1.3.0",
+			"warnings": H{
+				"improperlyConfiguredCron": false,
+				"incorrectDbCharset":       false,
+			},
+		})
+	case "GET user":
+		user := reader.BasicAuthUser(r)
+		reader.nextcloudJson(w, H{
+			"userId":             user.Username,
+			"displayName":        user.Username,
+			"lastLoginTimestamp": time.Now().Unix(),
+			"avatar":             nil,
+		})
+	case "GET folders":
+		err = reader.nextcloudFolders(w)
+	case "POST folders":
+		err = reader.nextcloudCreateFolder(w, r)
+	case "PUT folders/{id}":
+		err = reader.nextcloudRenameFolder(w, r, id)
+	case "DELETE folders/{id}":
+		err = reader.nextcloudDeleteFolder(w, id)
+	case "PUT folders/{id}/read", "POST folders/{id}/read":
+		err = reader.nextcloudMarkRead(w, r, fmt.Sprintf("g.id = %d", id))
+	case "GET feeds":
+		err = reader.nextcloudFeeds(w)
+	case "POST feeds":
+		err = reader.nextcloudCreateFeed(w, r)
+	case "DELETE feeds/{id}":
+		err = reader.DeleteFeed(strconv.Itoa(id))
+	case "PUT feeds/{id}/move", "POST feeds/{id}/move":
+		err = reader.nextcloudMoveFeed(w, r, id)
+	case "PUT feeds/{id}/rename", "POST feeds/{id}/rename":
+		err = reader.nextcloudRenameFeed(w, r, id)
+	case "PUT feeds/{id}/read", "POST feeds/{id}/read":
+		err = reader.nextcloudMarkRead(w, r, fmt.Sprintf("p.feed_id = %d", id))
+	case "GET items":
+		err = reader.nextcloudItems(w, r, false)
+	case "GET items/updated":
+		err = reader.nextcloudItems(w, r, true)
+	case "PUT items/read", "POST items/read":
+		err = reader.nextcloudMarkRead(w, r)
+	case "PUT items/{id}/read", "POST items/{id}/read":
+		err = reader.UpdatePost(strconv.Itoa(id), []string{"is_read = 1"})
+	case "PUT items/{id}/unread", "POST items/{id}/unread":
+		err = reader.UpdatePost(strconv.Itoa(id), []string{"is_read = 0"})
+	case "PUT items/{id}/star", "POST items/{id}/star":
+		err = reader.UpdatePost(strconv.Itoa(id), []string{"is_saved = 1"})
+	case "PUT items/{id}/unstar", "POST items/{id}/unstar":
+		err = reader.UpdatePost(strconv.Itoa(id), []string{"is_saved = 0"})
+	case "PUT items/read/multiple", "POST items/read/multiple":
+		err = reader.nextcloudMarkMultiple(r, "is_read = 1")
+	case "PUT items/unread/multiple", "POST items/unread/multiple":
+		err = reader.nextcloudMarkMultiple(r, "is_read = 0")
+	case "PUT items/star/multiple", "POST items/star/multiple":
+		err = reader.nextcloudMarkMultiple(r, "is_saved = 1")
+	case "PUT items/unstar/multiple", "POST items/unstar/multiple":
+		err = reader.nextcloudMarkMultiple(r, "is_saved = 0")
+	default:
+		err = nextcloudNotFound("unknown endpoint: %s %s", r.Method, path)
+	}
+	reader.metrics.Observe("nextcloud."+endpoint, time.Since(start), err != nil)
+	if err != nil {
+		log.Printf("nextcloud %s %s: %v", r.Method, path, err)
+		status := http.StatusInternalServerError
+		if e, ok := err.(*nextcloudError); ok {
+			status = e.status
+		}
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(H{"message": err.Error()})
+	}
+}
+
+func (reader *Reader) nextcloudFolders(w http.ResponseWriter) error {
+	categories, err := reader.GetCategories()
+	if err != nil {
+		return err
+	}
+	folders := make([]NextcloudFolder, 0, len(categories))
+	for _, category := range categories {
+		folders = append(folders, NextcloudFolder{Id: category.Id, Name: category.Name})
+	}
+	reader.nextcloudJson(w, H{"folders": folders})
+	return nil
+}
+
+func (reader *Reader) nextcloudCreateFolder(w http.ResponseWriter, r *http.Request) error {
+	params, err := nextcloudReadParams(r)
+	if err != nil {
+		return err
+	}
+	if params.Name == "" {
+		return nextcloudBadRequest("folder name is empty")
+	}
+	id, err := reader.CreateCategory(params.Name)
+	if err != nil {
+		return &nextcloudError{http.StatusConflict, err}
+	}
+	reader.nextcloudJson(w, H{"folders": []NextcloudFolder{{Id: id, Name: params.Name}}})
+	return nil
+}
+
+func (reader *Reader) nextcloudRenameFolder(w http.ResponseWriter, r *http.Request, id int) error {
+	params, err := nextcloudReadParams(r)
+	if err != nil {
+		return err
+	}
+	if params.Name == "" {
+		return nextcloudBadRequest("folder name is empty")
+	}
+	return reader.UpdateCategory(id, params.Name)
+}
+
+func (reader *Reader) nextcloudDeleteFolder(w http.ResponseWriter, id int) error {
+	defaultId, err := reader.DefaultCategoryId()
+	if err != nil {
+		return err
+	}
+	if id == defaultId {
+		return nextcloudBadRequest("the default folder cannot be deleted")
+	}
+	// Keep the folder's feeds reachable by moving them to the default category.
+	return reader.DeleteCategory(id, defaultId)
+}
+
+// nextcloudFolderId maps a Nextcloud folder id to a category id, where 0 means the root folder.
+func (reader *Reader) nextcloudFolderId(folderId int) (int, error) {
+	if folderId <= 0 {
+		return reader.DefaultCategoryId()
+	}
+	return folderId, nil
+}
+
+func (reader *Reader) nextcloudFeed(feed *Feed, unread map[int]int) NextcloudFeed {
+	return NextcloudFeed{
+		Id:          feed.Id,
+		Url:         feed.Link,
+		Title:       feed.Name,
+		Added:       feed.CreatedAt.Unix(),
+		FolderId:    feed.Category.Id,
+		UnreadCount: unread[feed.Id],
+		Link:        feed.Home,
+	}
+}
+
+func (reader *Reader) nextcloudFeeds(w http.ResponseWriter) error {
+	feeds, err := reader.GetFeeds(nil)
+	if err != nil {
+		return err
+	}
+	unread, err := reader.UnreadCounts()
+	if err != nil {
+		return err
+	}
+	newest, err := reader.NewestPostId()
+	if err != nil {
+		return err
+	}
+	starred, err := reader.CountPosts([]string{"p.is_saved = 1"})
+	if err != nil {
+		return err
+	}
+	out := make([]NextcloudFeed, 0, len(feeds))
+	for _, feed := range feeds {
+		out = append(out, reader.nextcloudFeed(feed, unread))
+	}
+	reader.nextcloudJson(w, H{
+		"feeds":        out,
+		"starredCount": starred,
+		"newestItemId": newest,
+	})
+	return nil
+}
+
+func (reader *Reader) nextcloudCreateFeed(w http.ResponseWriter, r *http.Request) error {
+	params, err := nextcloudReadParams(r)
+	if err != nil {
+		return err
+	}
+	if params.Url == "" {
+		return nextcloudBadRequest("feed url is empty")
+	}
+	categoryId, err := reader.nextcloudFolderId(params.FolderId)
+	if err != nil {
+		return err
+	}
+	id, err := reader.SubscribeFeed(params.Url, "", categoryId)
+	if err != nil {
+		return nextcloudBadRequest("cannot subscribe: %v", err)
+	}
+	feed, err := reader.GetFeed(strconv.Itoa(id))
+	if err != nil {
+		return err
+	}
+	newest, err := reader.NewestPostId()
+	if err != nil {
+		return err
+	}
+	reader.nextcloudJson(w, H{
+		"feeds":        []NextcloudFeed{reader.nextcloudFeed(feed, nil)},
+		"newestItemId": newest,
+	})
+	return nil
+}
+
+func (reader *Reader) nextcloudMoveFeed(w http.ResponseWriter, r *http.Request, id int) error {
+	params, err := nextcloudReadParams(r)
+	if err != nil {
+		return err
+	}
+	categoryId, err := reader.nextcloudFolderId(params.FolderId)
+	if err != nil {
+		return err
+	}
+	_, err = reader.UpdateFeed(strconv.Itoa(id), FeedUpdate{CategoryId: &categoryId})
+	return err
+}
+
+func (reader *Reader) nextcloudRenameFeed(w http.ResponseWriter, r *http.Request, id int) error {
+	params, err := nextcloudReadParams(r)
+	if err != nil {
+		return err
+	}
+	if params.FeedTitle == "" {
+		return nextcloudBadRequest("feed title is empty")
+	}
+	_, err = reader.UpdateFeed(strconv.Itoa(id), FeedUpdate{Name: &params.FeedTitle})
+	return err
+}
+
+// nextcloudMarkRead marks every matching post up to newestItemId as read.
+func (reader *Reader) nextcloudMarkRead(w http.ResponseWriter, r *http.Request, conditions ...string) error {
+	params, err := nextcloudReadParams(r)
+	if err != nil {
+		return err
+	}
+	conditions = append(conditions, fmt.Sprintf("p.id <= %d", params.NewestItemId))
+	return reader.UpdatePosts(conditions, []string{"is_read = 1"})
+}
+
+func (reader *Reader) nextcloudMarkMultiple(r *http.Request, update string) error {
+	params, err := nextcloudReadParams(r)
+	if err != nil {
+		return err
+	}
+	if len(params.ItemIds) == 0 {
+		return nil
+	}
+	ids := make([]string, 0, len(params.ItemIds))
+	for _, id := range params.ItemIds {
+		ids = append(ids, strconv.Itoa(id))
+	}
+	return reader.UpdatePosts([]string{fmt.Sprintf("p.id IN (%s)", strings.Join(ids, ","))}, []string{update})
+}
+
+func (reader *Reader) nextcloudItems(w http.ResponseWriter, r *http.Request, updated bool) error {
+	query := r.URL.Query()
+	intParam := func(name string, value int) int {
+		if n, err := strconv.Atoi(query.Get(name)); err == nil {
+			return n
+		}
+		return value
+	}
+	selection := intParam("type", nextcloudTypeAll)
+	id := intParam("id", 0)
+	var conditions []string
+	switch selection {
+	case nextcloudTypeFeed:
+		conditions = append(conditions, fmt.Sprintf("p.feed_id = %d", id))
+	case nextcloudTypeFolder:
+		folderId, err := reader.nextcloudFolderId(id)
+		if err != nil {
+			return err
+		}
+		conditions = append(conditions, fmt.Sprintf("g.id = %d", folderId))
+	case nextcloudTypeStarred:
+		conditions = append(conditions, "p.is_saved = 1")
+	case nextcloudTypeAll:
+	default:
+		return nextcloudBadRequest("invalid type: %d", selection)
+	}
+	limit := &Pagination{Page: 1, Size: -1, Order: "p.id DESC"}
+	if updated {
+		lastModified, err := strconv.ParseInt(query.Get("lastModified"), 10, 64)
+		if err != nil {
+			return nextcloudBadRequest("invalid lastModified")
+		}
+		// newer clients send microseconds
+		if lastModified > 1e12 {
+			lastModified /= 1e6
+		}
+		// items whose read or starred state changed count as updated too
+		conditions = append(conditions, fmt.Sprintf(
+			"(CAST(strftime('%%s', p.created_at) AS INTEGER) >= %d OR CAST(strftime('%%s', p.updated_at) AS INTEGER) >= %d)",
+			lastModified, lastModified))
+	} else {
+		if query.Get("getRead") == "false" {
+			conditions = append(conditions, "p.is_read = 0")
+		}
+		oldestFirst := query.Get("oldestFirst") == "true"
+		offset := intParam("offset", 0)
+		if oldestFirst {
+			limit.Order = "p.id ASC"
+			if offset > 0 {
+				conditions = append(conditions, fmt.Sprintf("p.id > %d", offset))
+			}
+		} else if offset > 0 {
+			conditions = append(conditions, fmt.Sprintf("p.id < %d", offset))
+		}
+		if size := intParam("batchSize", -1); size > 0 {
+			limit.Size = size
+		}
+	}
+	posts, err := reader.GetPosts(conditions, limit)
+	if err != nil {
+		return err
+	}
+	items := make([]NextcloudItem, 0, len(posts))
+	for _, post := range posts {
+		guidHash := fmt.Sprintf("%x", md5.Sum([]byte(post.Link)))
+		items = append(items, NextcloudItem{
+			Id:           post.Id,
+			Guid:         post.Link,
+			GuidHash:     guidHash,
+			Url:          post.Link,
+			Title:        post.Title,
+			Author:       post.Feed.Name,
+			PubDate:      post.PubDate.Unix(),
+			UpdatedDate:  post.PubDate.Unix(),
+			Body:         post.Content,
+			FeedId:       post.Feed.Id,
+			Unread:       !post.IsRead,
+			Starred:      post.IsSaved,
+			LastModified: post.UpdatedAt.Unix(),
+			Fingerprint:  guidHash,
+		})
+	}
+	reader.nextcloudJson(w, H{"items": items})
+	return nil
+}
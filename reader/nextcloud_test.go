@@ -0,0 +1,71 @@
+package reader
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// addTestPost subscribes the user to a feed and adds a post to it, created
+// long ago.
+func addTestPost(t *testing.T, reader *Reader) (feedId, postId int) {
+	t.Helper()
+	categoryId, err := reader.DefaultCategoryId()
+	if err != nil {
+		t.Fatal(err)
+	}
+	feedId, err = reader.CreateFeed("rss", "Example", "https://example.com/", "https://example.com/feed.xml", categoryId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = reader.db.QueryRow(`
+		INSERT INTO posts (entry_id, title, content, link, pub_date, created_at, feed_id)
+		VALUES ('1', 'Old post', '', 'https://example.com/1', '2020-01-01 00:00:00', '2020-01-01 00:00:00', ?) RETURNING id
+	`, feedId).Scan(&postId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return
+}
+
+func nextcloudUpdated(t *testing.T, reader *Reader, since time.Time) []NextcloudItem {
+	t.Helper()
+	r := httptest.NewRequest("GET", fmt.Sprintf("%sitems/updated?type=3&lastModified=%d", nextcloudPrefix, since.Unix()), nil)
+	r.SetBasicAuth("admin", "admin123")
+	w := httptest.NewRecorder()
+	reader.NextcloudView(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("items/updated: %d %s", w.Code, w.Body)
+	}
+	var body struct {
+		Items []NextcloudItem `json:"items"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	return body.Items
+}
+
+func TestNextcloudUpdatedItemsIncludeStateChanges(t *testing.T) {
+	reader := newTestReader(t)
+	user := reader.As(&reader.config.Users[0])
+	_, postId := addTestPost(t, user)
+	since := time.Now().Add(-time.Minute)
+	if items := nextcloudUpdated(t, reader, since); len(items) != 0 {
+		t.Fatalf("old post reported as updated: %+v", items)
+	}
+	if err := user.UpdatePost(strconv.Itoa(postId), []string{"is_read = 1"}); err != nil {
+		t.Fatal(err)
+	}
+	items := nextcloudUpdated(t, reader, since)
+	if len(items) != 1 || items[0].Id != postId || items[0].Unread {
+		t.Fatalf("want the post marked read, got %+v", items)
+	}
+	if items[0].LastModified < since.Unix() {
+		t.Errorf("lastModified %d is before the change", items[0].LastModified)
+	}
+}
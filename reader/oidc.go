@@ -0,0 +1,606 @@
+package reader
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"log"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	oidcStateCookie = "oidc_state"
+	oidcStateTTL    = 10 * time.Minute
+	oidcCacheTTL    = time.Hour
+)
+
+// OIDCConfig enables login through an OpenID Connect provider using the
+// authorization code flow with PKCE.
+type OIDCConfig struct {
+	// Name is shown on the login button.
+	Name         string `json:"name" yaml:"name"`
+	Issuer       string `json:"issuer" yaml:"issuer"`
+	ClientID     string `json:"client_id" yaml:"client_id"`
+	ClientSecret string `json:"client_secret" yaml:"client_secret"`
+	// RedirectURL defaults to /oidc/callback on the requested host.
+	RedirectURL string   `json:"redirect_url" yaml:"redirect_url"`
+	Scopes      []string `json:"scopes" yaml:"scopes"`
+	// UsernameClaim names users created by AutoProvision, and defaults to
+	// preferred_username, then a verified email, then sub.
+	UsernameClaim string `json:"username_claim" yaml:"username_claim"`
+	// AutoProvision creates users on their first login. It never links a
+	// login to an existing user, see User.OIDCSubject for that.
+	AutoProvision bool `json:"auto_provision" yaml:"auto_provision"`
+}
+
+type oidcDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JwksURI               string `json:"jwks_uri"`
+	UserinfoEndpoint      string `json:"userinfo_endpoint"`
+}
+
+// oidcLogin is a login in progress, keyed by its state parameter.
+type oidcLogin struct {
+	nonce    string
+	verifier string
+	redirect string
+	next     string
+	expires  time.Time
+}
+
+type oidcProvider struct {
+	config *OIDCConfig
+	client *http.Client
+
+	mu           sync.Mutex
+	discovery    *oidcDiscovery
+	discoveredAt time.Time
+	keys         map[string]crypto.PublicKey
+	logins       map[string]*oidcLogin
+}
+
+func newOIDCProvider(config *OIDCConfig) *oidcProvider {
+	return &oidcProvider{
+		config: config,
+		client: &http.Client{Timeout: 15 * time.Second},
+		logins: make(map[string]*oidcLogin),
+	}
+}
+
+func randomString(n int) string {
+	buf := make([]byte, n)
+	if _, err := rand.Read(buf); err != nil {
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(buf)
+}
+
+func (p *oidcProvider) getJson(link string, v any) error {
+	res, err := p.client.Get(link)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %s", link, res.Status)
+	}
+	return json.NewDecoder(res.Body).Decode(v)
+}
+
+// discover loads the provider metadata, cached for an hour.
+func (p *oidcProvider) discover() (*oidcDiscovery, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.discovery != nil && time.Since(p.discoveredAt) < oidcCacheTTL {
+		return p.discovery, nil
+	}
+	var discovery oidcDiscovery
+	issuer := strings.TrimSuffix(p.config.Issuer, "/")
+	if err := p.getJson(issuer+"/.well-known/openid-configuration", &discovery); err != nil {
+		return nil, err
+	}
+	if strings.TrimSuffix(discovery.Issuer, "/") != issuer {
+		return nil, fmt.Errorf("issuer mismatch: %q", discovery.Issuer)
+	}
+	p.discovery, p.discoveredAt, p.keys = &discovery, time.Now(), nil
+	return p.discovery, nil
+}
+
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	buf, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(buf), nil
+}
+
+func (key jsonWebKey) publicKey() (crypto.PublicKey, error) {
+	switch key.Kty {
+	case "RSA":
+		n, err := decodeBigInt(key.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(key.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		if key.Crv != "P-256" {
+			return nil, fmt.Errorf("unsupported curve: %s", key.Crv)
+		}
+		x, err := decodeBigInt(key.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(key.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}, nil
+	}
+	return nil, fmt.Errorf("unsupported key type: %s", key.Kty)
+}
+
+// key returns the signing key with the given id, refetching the key set
+// when the provider has rotated its keys.
+func (p *oidcProvider) key(discovery *oidcDiscovery, kid string) (crypto.PublicKey, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if key, ok := p.keys[kid]; ok {
+		return key, nil
+	}
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := p.getJson(discovery.JwksURI, &set); err != nil {
+		return nil, err
+	}
+	p.keys = make(map[string]crypto.PublicKey)
+	for _, jwk := range set.Keys {
+		key, err := jwk.publicKey()
+		if err != nil {
+			log.Printf("Ignoring OIDC key %q: %v", jwk.Kid, err)
+			continue
+		}
+		p.keys[jwk.Kid] = key
+	}
+	if key, ok := p.keys[kid]; ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("unknown signing key: %q", kid)
+}
+
+// verifySignature checks a JWS signature made with RS256/384/512 or ES256.
+func verifySignature(alg string, key crypto.PublicKey, signed, signature []byte) error {
+	var h hash.Hash
+	var id crypto.Hash
+	switch alg {
+	case "RS256", "ES256":
+		h, id = sha256.New(), crypto.SHA256
+	case "RS384":
+		h, id = sha512.New384(), crypto.SHA384
+	case "RS512":
+		h, id = sha512.New(), crypto.SHA512
+	default:
+		return fmt.Errorf("unsupported signing algorithm: %s", alg)
+	}
+	h.Write(signed)
+	digest := h.Sum(nil)
+	switch key := key.(type) {
+	case *rsa.PublicKey:
+		if !strings.HasPrefix(alg, "RS") {
+			break
+		}
+		return rsa.VerifyPKCS1v15(key, id, digest, signature)
+	case *ecdsa.PublicKey:
+		if alg != "ES256" || len(signature) != 64 {
+			break
+		}
+		r := new(big.Int).SetBytes(signature[:32])
+		s := new(big.Int).SetBytes(signature[32:])
+		if !ecdsa.Verify(key, digest, r, s) {
+			return fmt.Errorf("invalid signature")
+		}
+		return nil
+	}
+	return fmt.Errorf("key does not match algorithm %s", alg)
+}
+
+// verifyIDToken checks the ID token's signature, issuer, audience, expiry
+// and nonce, and returns its claims.
+func (p *oidcProvider) verifyIDToken(discovery *oidcDiscovery, raw, nonce string) (map[string]any, error) {
+	parts := strings.Split(raw, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("malformed id_token")
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, err
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, err
+	}
+	key, err := p.key(discovery, header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	if err := verifySignature(header.Alg, key, []byte(parts[0]+"."+parts[1]), signature); err != nil {
+		return nil, err
+	}
+	var claims map[string]any
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, err
+	}
+	if iss, _ := claims["iss"].(string); iss != discovery.Issuer {
+		return nil, fmt.Errorf("unexpected issuer: %q", iss)
+	}
+	if !audienceContains(claims["aud"], p.config.ClientID) {
+		return nil, fmt.Errorf("id_token is not issued for this client")
+	}
+	if exp, _ := claims["exp"].(float64); time.Now().After(time.Unix(int64(exp), 0).Add(time.Minute)) {
+		return nil, fmt.Errorf("id_token has expired")
+	}
+	if got, _ := claims["nonce"].(string); got != nonce {
+		return nil, fmt.Errorf("nonce mismatch")
+	}
+	return claims, nil
+}
+
+func decodeSegment(segment string, v any) error {
+	buf, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(buf, v)
+}
+
+func audienceContains(aud any, clientId string) bool {
+	switch aud := aud.(type) {
+	case string:
+		return aud == clientId
+	case []any:
+		for _, value := range aud {
+			if value == clientId {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// emailVerified reports whether the provider vouches for the email claim.
+// Some providers send the flag as a string.
+func emailVerified(claims map[string]any) bool {
+	switch verified := claims["email_verified"].(type) {
+	case bool:
+		return verified
+	case string:
+		return verified == "true"
+	}
+	return false
+}
+
+// username picks the configured claim, or the first of the usual ones. An
+// email address is only used once the provider has verified it.
+func (p *oidcProvider) username(claims map[string]any) string {
+	names := []string{"preferred_username", "email", "sub"}
+	if p.config.UsernameClaim != "" {
+		names = []string{p.config.UsernameClaim}
+	}
+	for _, name := range names {
+		if name == "email" && !emailVerified(claims) {
+			continue
+		}
+		if value, _ := claims[name].(string); value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+// begin records a new login and returns the provider's authorization URL.
+func (p *oidcProvider) begin(redirect, next string) (state, link string, err error) {
+	discovery, err := p.discover()
+	if err != nil {
+		return
+	}
+	login := &oidcLogin{
+		nonce:    randomString(16),
+		verifier: randomString(32),
+		redirect: redirect,
+		next:     next,
+		expires:  time.Now().Add(oidcStateTTL),
+	}
+	state = randomString(16)
+	p.mu.Lock()
+	for key, pending := range p.logins {
+		if time.Now().After(pending.expires) {
+			delete(p.logins, key)
+		}
+	}
+	p.logins[state] = login
+	p.mu.Unlock()
+
+	scopes := p.config.Scopes
+	if len(scopes) == 0 {
+		scopes = []string{"openid", "profile", "email"}
+	}
+	challenge := sha256.Sum256([]byte(login.verifier))
+	query := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {p.config.ClientID},
+		"redirect_uri":          {redirect},
+		"scope":                 {strings.Join(scopes, " ")},
+		"state":                 {state},
+		"nonce":                 {login.nonce},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(challenge[:])},
+		"code_challenge_method": {"S256"},
+	}
+	separator := "?"
+	if strings.Contains(discovery.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+	return state, discovery.AuthorizationEndpoint + separator + query.Encode(), nil
+}
+
+// finish exchanges the authorization code and returns the verified claims
+// together with the page to return to.
+func (p *oidcProvider) finish(state, code string) (claims map[string]any, next string, err error) {
+	p.mu.Lock()
+	login, ok := p.logins[state]
+	delete(p.logins, state)
+	p.mu.Unlock()
+	if !ok || time.Now().After(login.expires) {
+		return nil, "", fmt.Errorf("login expired, please try again")
+	}
+	discovery, err := p.discover()
+	if err != nil {
+		return
+	}
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {login.redirect},
+		"client_id":     {p.config.ClientID},
+		"code_verifier": {login.verifier},
+	}
+	req, err := http.NewRequest("POST", discovery.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if p.config.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(p.config.ClientID), url.QueryEscape(p.config.ClientSecret))
+	}
+	res, err := p.client.Do(req)
+	if err != nil {
+		return
+	}
+	defer res.Body.Close()
+	var token struct {
+		IDToken          string `json:"id_token"`
+		AccessToken      string `json:"access_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err = json.NewDecoder(res.Body).Decode(&token); err != nil {
+		return nil, "", fmt.Errorf("token endpoint: %s: %v", res.Status, err)
+	}
+	if token.Error != "" {
+		return nil, "", fmt.Errorf("token endpoint: %s %s", token.Error, token.ErrorDescription)
+	}
+	if token.IDToken == "" {
+		return nil, "", fmt.Errorf("token endpoint returned no id_token")
+	}
+	claims, err = p.verifyIDToken(discovery, token.IDToken, login.nonce)
+	if err != nil {
+		return nil, "", err
+	}
+	if discovery.UserinfoEndpoint != "" && token.AccessToken != "" {
+		if err = p.userinfo(discovery, token.AccessToken, claims); err != nil {
+			return nil, "", err
+		}
+	}
+	return claims, login.next, nil
+}
+
+// userinfo adds the claims from the userinfo endpoint that the ID token
+// lacks. The endpoint must answer for the same subject.
+func (p *oidcProvider) userinfo(discovery *oidcDiscovery, accessToken string, claims map[string]any) error {
+	req, err := http.NewRequest("GET", discovery.UserinfoEndpoint, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	req.Header.Set("Accept", "application/json")
+	res, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("userinfo endpoint: %s", res.Status)
+	}
+	var info map[string]any
+	if err := json.NewDecoder(res.Body).Decode(&info); err != nil {
+		return fmt.Errorf("userinfo endpoint: %v", err)
+	}
+	if info["sub"] != claims["sub"] {
+		return fmt.Errorf("userinfo subject mismatch")
+	}
+	for name, value := range info {
+		if _, ok := claims[name]; !ok {
+			claims[name] = value
+		}
+	}
+	return nil
+}
+
+// oidcName is the label of the login button, or empty when OIDC is disabled.
+func (reader *Reader) oidcName() string {
+	if reader.oidc == nil {
+		return ""
+	}
+	if reader.oidc.config.Name != "" {
+		return reader.oidc.config.Name
+	}
+	return "OpenID Connect"
+}
+
+func (reader *Reader) oidcRedirectURL(r *http.Request) string {
+	if reader.oidc.config.RedirectURL != "" {
+		return reader.oidc.config.RedirectURL
+	}
+	return reader.baseURL(r) + "/oidc/callback"
+}
+
+// oidcUser returns the user linked to the provider's subject. On the first
+// login the subject is linked to the configured user naming it in
+// oidc_subject, or with auto_provision to a new user. A login never takes
+// over an existing user because the names match.
+func (reader *Reader) oidcUser(issuer, subject, username string) (*User, error) {
+	var linked string
+	err := reader.db.QueryRow(`
+		SELECT u.username FROM oidc_identities i JOIN users u ON u.id = i.user_id
+		WHERE i.issuer = ? AND i.subject = ?
+	`, issuer, subject).Scan(&linked)
+	if err == nil {
+		return reader.FindUser(linked), nil
+	}
+	if err != sql.ErrNoRows {
+		return nil, err
+	}
+	var user *User
+	for i := range reader.config.Users {
+		if reader.config.Users[i].OIDCSubject == subject {
+			user = &reader.config.Users[i]
+			break
+		}
+	}
+	if user == nil {
+		if !reader.oidc.config.AutoProvision || username == "" {
+			return nil, nil
+		}
+		if user, err = reader.createUser(username, "oidc"); err != nil {
+			return nil, err
+		}
+	}
+	_, err = reader.db.Exec(`
+		INSERT INTO oidc_identities (issuer, subject, user_id) VALUES (?, ?, ?)
+	`, issuer, subject, user.Id)
+	return user, err
+}
+
+// OIDCLoginView sends the browser to the identity provider.
+func (reader *Reader) OIDCLoginView(w http.ResponseWriter, r *http.Request) {
+	reader = reader.forRequest(r)
+	if reader.oidc == nil {
+		http.NotFound(w, r)
+		return
+	}
+	state, link, err := reader.oidc.begin(reader.oidcRedirectURL(r), safeRedirect(r.FormValue("next")))
+	if err != nil {
+		log.Println("OIDC login:", err)
+		reader.Error(w, fmt.Errorf("cannot reach the identity provider"))
+		return
+	}
+	// The state cookie ties the callback to the browser that started the login.
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    hashToken(state),
+		Path:     "/oidc/",
+		MaxAge:   int(oidcStateTTL.Seconds()),
+		HttpOnly: true,
+		Secure:   reader.isSecureRequest(r),
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(w, r, link, http.StatusFound)
+}
+
+// OIDCCallbackView completes the login started by OIDCLoginView.
+func (reader *Reader) OIDCCallbackView(w http.ResponseWriter, r *http.Request) {
+	reader = reader.forRequest(r)
+	if reader.oidc == nil {
+		http.NotFound(w, r)
+		return
+	}
+	query := r.URL.Query()
+	if e := query.Get("error"); e != "" {
+		w.WriteHeader(http.StatusUnauthorized)
+		reader.Error(w, fmt.Errorf("login failed: %s %s", e, query.Get("error_description")))
+		return
+	}
+	state := query.Get("state")
+	cookie, err := r.Cookie(oidcStateCookie)
+	if err != nil || cookie.Value != hashToken(state) {
+		w.WriteHeader(http.StatusBadRequest)
+		reader.Error(w, fmt.Errorf("login state mismatch, please try again"))
+		return
+	}
+	http.SetCookie(w, &http.Cookie{Name: oidcStateCookie, Path: "/oidc/", MaxAge: -1})
+	claims, next, err := reader.oidc.finish(state, query.Get("code"))
+	if err != nil {
+		log.Println("OIDC callback:", err)
+		w.WriteHeader(http.StatusUnauthorized)
+		reader.Error(w, fmt.Errorf("login failed: %v", err))
+		return
+	}
+	issuer, _ := claims["iss"].(string)
+	subject, _ := claims["sub"].(string)
+	if subject == "" {
+		w.WriteHeader(http.StatusUnauthorized)
+		reader.Error(w, fmt.Errorf("login failed: id_token has no subject"))
+		return
+	}
+	user, err := reader.oidcUser(issuer, subject, reader.oidc.username(claims))
+	if err != nil {
+		log.Printf("OIDC login for %s %q: %v", issuer, subject, err)
+		w.WriteHeader(http.StatusForbidden)
+		reader.Error(w, err)
+		return
+	}
+	if user == nil {
+		log.Printf("OIDC login for unknown subject %s %q", issuer, subject)
+		w.WriteHeader(http.StatusForbidden)
+		reader.Error(w, fmt.Errorf("this account is not allowed to log in"))
+		return
+	}
+	if err := reader.startSession(w, r, user); err != nil {
+		reader.Error(w, err)
+		return
+	}
+	http.Redirect(w, r, next, http.StatusFound)
+}
@@ -0,0 +1,319 @@
+package reader
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+)
+
+const (
+	testClientID     = "feedreader"
+	testClientSecret = "secret"
+)
+
+// testIdP is a minimal OpenID Connect provider. It checks PKCE and client
+// credentials on the token endpoint like a real provider would.
+type testIdP struct {
+	*httptest.Server
+	t   *testing.T
+	key *rsa.PrivateKey
+
+	mu sync.Mutex
+	// claims go into the next ID token, userinfo is served for its access token.
+	claims   map[string]any
+	userinfo map[string]any
+	codes    map[string]url.Values
+	calls    map[string]int
+}
+
+func newTestIdP(t *testing.T) *testIdP {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	idp := &testIdP{t: t, key: key, codes: make(map[string]url.Values), calls: make(map[string]int)}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", idp.discovery)
+	mux.HandleFunc("/jwks", idp.jwks)
+	mux.HandleFunc("/authorize", idp.authorize)
+	mux.HandleFunc("/token", idp.token)
+	mux.HandleFunc("/userinfo", idp.userinfoEndpoint)
+	idp.Server = httptest.NewServer(mux)
+	t.Cleanup(idp.Close)
+	return idp
+}
+
+func (idp *testIdP) count(endpoint string) {
+	idp.mu.Lock()
+	defer idp.mu.Unlock()
+	idp.calls[endpoint]++
+}
+
+func (idp *testIdP) writeJson(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func (idp *testIdP) discovery(w http.ResponseWriter, r *http.Request) {
+	idp.count("discovery")
+	idp.writeJson(w, http.StatusOK, map[string]string{
+		"issuer":                 idp.URL,
+		"authorization_endpoint": idp.URL + "/authorize",
+		"token_endpoint":         idp.URL + "/token",
+		"jwks_uri":               idp.URL + "/jwks",
+		"userinfo_endpoint":      idp.URL + "/userinfo",
+	})
+}
+
+func (idp *testIdP) jwks(w http.ResponseWriter, r *http.Request) {
+	encode := func(buf []byte) string { return base64.RawURLEncoding.EncodeToString(buf) }
+	idp.writeJson(w, http.StatusOK, map[string]any{"keys": []map[string]string{{
+		"kty": "RSA",
+		"kid": "test",
+		"n":   encode(idp.key.N.Bytes()),
+		"e":   encode(big.NewInt(int64(idp.key.E)).Bytes()),
+	}}})
+}
+
+// authorize logs the user in at once and redirects back with a code.
+func (idp *testIdP) authorize(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	if query.Get("client_id") != testClientID || query.Get("response_type") != "code" ||
+		query.Get("code_challenge_method") != "S256" || query.Get("code_challenge") == "" {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+	code := randomString(16)
+	idp.mu.Lock()
+	idp.codes[code] = query
+	idp.mu.Unlock()
+	redirect := query.Get("redirect_uri") + "?" + url.Values{"code": {code}, "state": {query.Get("state")}}.Encode()
+	http.Redirect(w, r, redirect, http.StatusFound)
+}
+
+func (idp *testIdP) token(w http.ResponseWriter, r *http.Request) {
+	idp.count("token")
+	id, secret, _ := r.BasicAuth()
+	if id != testClientID || secret != testClientSecret {
+		idp.writeJson(w, http.StatusUnauthorized, map[string]string{"error": "invalid_client"})
+		return
+	}
+	code := r.PostFormValue("code")
+	idp.mu.Lock()
+	authorize, ok := idp.codes[code]
+	delete(idp.codes, code)
+	claims := idp.claims
+	idp.mu.Unlock()
+	challenge := sha256.Sum256([]byte(r.PostFormValue("code_verifier")))
+	if !ok || r.PostFormValue("grant_type") != "authorization_code" ||
+		r.PostFormValue("redirect_uri") != authorize.Get("redirect_uri") ||
+		base64.RawURLEncoding.EncodeToString(challenge[:]) != authorize.Get("code_challenge") {
+		idp.writeJson(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+		return
+	}
+	payload := map[string]any{
+		"iss":   idp.URL,
+		"aud":   testClientID,
+		"exp":   time.Now().Add(time.Hour).Unix(),
+		"nonce": authorize.Get("nonce"),
+	}
+	for name, value := range claims {
+		payload[name] = value
+	}
+	idp.writeJson(w, http.StatusOK, map[string]string{
+		"id_token":     idp.sign(payload),
+		"access_token": "access-" + code,
+		"token_type":   "Bearer",
+	})
+}
+
+func (idp *testIdP) userinfoEndpoint(w http.ResponseWriter, r *http.Request) {
+	idp.count("userinfo")
+	if len(r.Header.Get("Authorization")) <= len("Bearer access-") {
+		idp.writeJson(w, http.StatusUnauthorized, map[string]string{"error": "invalid_token"})
+		return
+	}
+	idp.mu.Lock()
+	defer idp.mu.Unlock()
+	idp.writeJson(w, http.StatusOK, idp.userinfo)
+}
+
+func (idp *testIdP) sign(claims map[string]any) string {
+	encode := func(v any) string {
+		buf, err := json.Marshal(v)
+		if err != nil {
+			idp.t.Fatal(err)
+		}
+		return base64.RawURLEncoding.EncodeToString(buf)
+	}
+	signed := encode(map[string]string{"alg": "RS256", "kid": "test"}) + "." + encode(claims)
+	digest := sha256.Sum256([]byte(signed))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, idp.key, crypto.SHA256, digest[:])
+	if err != nil {
+		idp.t.Fatal(err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+// login sets the claims of the next login.
+func (idp *testIdP) login(claims, userinfo map[string]any) {
+	idp.mu.Lock()
+	defer idp.mu.Unlock()
+	idp.claims, idp.userinfo = claims, userinfo
+}
+
+func newOIDCTestReader(t *testing.T, autoProvision bool) (*Reader, *testIdP) {
+	idp := newTestIdP(t)
+	reader := newTestReader(t)
+	reader.oidc = newOIDCProvider(&OIDCConfig{
+		Issuer:        idp.URL,
+		ClientID:      testClientID,
+		ClientSecret:  testClientSecret,
+		RedirectURL:   "https://reader.example.com/oidc/callback",
+		AutoProvision: autoProvision,
+	})
+	return reader, idp
+}
+
+// beginLogin starts a login and follows the provider's redirect, returning
+// the callback request the browser would make.
+func beginLogin(t *testing.T, reader *Reader, idp *testIdP) *http.Request {
+	t.Helper()
+	w := httptest.NewRecorder()
+	reader.OIDCLoginView(w, httptest.NewRequest("GET", "/oidc/login?next=/feeds", nil))
+	if w.Code != http.StatusFound {
+		t.Fatalf("login: %d %s", w.Code, w.Body)
+	}
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	res, err := client.Get(w.Header().Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusFound {
+		t.Fatalf("authorize: %s", res.Status)
+	}
+	callback, err := url.Parse(res.Header.Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := httptest.NewRequest("GET", "/oidc/callback?"+callback.RawQuery, nil)
+	for _, cookie := range w.Result().Cookies() {
+		r.AddCookie(cookie)
+	}
+	return r
+}
+
+// finishLogin completes the callback and returns the logged in user, if any.
+func finishLogin(t *testing.T, reader *Reader, r *http.Request) (*httptest.ResponseRecorder, *User) {
+	t.Helper()
+	w := httptest.NewRecorder()
+	reader.OIDCCallbackView(w, r)
+	for _, cookie := range w.Result().Cookies() {
+		if cookie.Name == sessionCookie {
+			r := httptest.NewRequest("GET", "/", nil)
+			r.AddCookie(cookie)
+			return w, reader.CurrentUser(r)
+		}
+	}
+	return w, nil
+}
+
+func TestOIDCLogin(t *testing.T) {
+	reader, idp := newOIDCTestReader(t, true)
+	idp.login(map[string]any{"sub": "u-1"}, map[string]any{"sub": "u-1", "preferred_username": "alice"})
+	w, user := finishLogin(t, reader, beginLogin(t, reader, idp))
+	if user == nil || user.Username != "alice" {
+		t.Fatalf("login: %d %s, user %+v", w.Code, w.Body, user)
+	}
+	if location := w.Header().Get("Location"); location != "/feeds" {
+		t.Errorf("redirected to %q, want /feeds", location)
+	}
+	if idp.calls["discovery"] != 1 || idp.calls["token"] != 1 || idp.calls["userinfo"] != 1 {
+		t.Errorf("provider calls: %v", idp.calls)
+	}
+	// The identity stays linked to alice when the account is renamed.
+	idp.login(map[string]any{"sub": "u-1", "preferred_username": "bob"}, map[string]any{"sub": "u-1"})
+	if _, user := finishLogin(t, reader, beginLogin(t, reader, idp)); user == nil || user.Username != "alice" {
+		t.Errorf("second login: user %+v, want alice", user)
+	}
+	// A code can only be exchanged once.
+	r := beginLogin(t, reader, idp)
+	replay := r.Clone(r.Context())
+	finishLogin(t, reader, r)
+	if w, user := finishLogin(t, reader, replay); user != nil || w.Code == http.StatusFound {
+		t.Errorf("replayed callback: %d, user %+v", w.Code, user)
+	}
+}
+
+func TestOIDCStateMismatch(t *testing.T) {
+	reader, idp := newOIDCTestReader(t, true)
+	idp.login(map[string]any{"sub": "u-1", "preferred_username": "alice"}, map[string]any{"sub": "u-1"})
+	first := beginLogin(t, reader, idp)
+	second := beginLogin(t, reader, idp)
+	// The callback carries the first login's state, the cookie the second's.
+	r := httptest.NewRequest("GET", first.URL.String(), nil)
+	r.AddCookie(second.Cookies()[0])
+	if w, user := finishLogin(t, reader, r); w.Code != http.StatusBadRequest || user != nil {
+		t.Errorf("mismatched state: %d, user %+v", w.Code, user)
+	}
+	r = httptest.NewRequest("GET", first.URL.String(), nil)
+	if w, user := finishLogin(t, reader, r); w.Code != http.StatusBadRequest || user != nil {
+		t.Errorf("missing state cookie: %d, user %+v", w.Code, user)
+	}
+	if idp.calls["token"] != 0 {
+		t.Errorf("code exchanged %d times after a state mismatch", idp.calls["token"])
+	}
+}
+
+func TestOIDCUserinfoSubjectMismatch(t *testing.T) {
+	reader, idp := newOIDCTestReader(t, true)
+	idp.login(map[string]any{"sub": "u-1"}, map[string]any{"sub": "u-2", "preferred_username": "alice"})
+	if w, user := finishLogin(t, reader, beginLogin(t, reader, idp)); w.Code != http.StatusUnauthorized || user != nil {
+		t.Errorf("userinfo for another subject: %d, user %+v", w.Code, user)
+	}
+}
+
+func TestOIDCDoesNotTakeOverLocalUsers(t *testing.T) {
+	reader, idp := newOIDCTestReader(t, true)
+	for _, claims := range []map[string]any{
+		{"sub": "evil-1", "preferred_username": "admin"},
+		{"sub": "evil-2", "email": "admin", "email_verified": true},
+	} {
+		idp.login(claims, map[string]any{"sub": claims["sub"]})
+		if w, user := finishLogin(t, reader, beginLogin(t, reader, idp)); w.Code != http.StatusForbidden || user != nil {
+			t.Errorf("%v: %d, user %+v", claims, w.Code, user)
+		}
+	}
+	// An unverified email is not used as the name.
+	idp.login(map[string]any{"sub": "u-3", "email": "admin"}, map[string]any{"sub": "u-3"})
+	if _, user := finishLogin(t, reader, beginLogin(t, reader, idp)); user == nil || user.Username != "u-3" {
+		t.Errorf("unverified email: user %+v, want u-3", user)
+	}
+}
+
+func TestOIDCSubjectLinksConfiguredUser(t *testing.T) {
+	reader, idp := newOIDCTestReader(t, false)
+	reader.config.Users[0].OIDCSubject = "admin-sub"
+	idp.login(map[string]any{"sub": "other", "preferred_username": "admin"}, map[string]any{"sub": "other"})
+	if w, user := finishLogin(t, reader, beginLogin(t, reader, idp)); w.Code != http.StatusForbidden || user != nil {
+		t.Errorf("unlinked account: %d, user %+v", w.Code, user)
+	}
+	idp.login(map[string]any{"sub": "admin-sub", "preferred_username": "someone"}, map[string]any{"sub": "admin-sub"})
+	if _, user := finishLogin(t, reader, beginLogin(t, reader, idp)); user == nil || user.Username != "admin" {
+		t.Errorf("linked account: user %+v, want admin", user)
+	}
+}
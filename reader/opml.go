@@ -0,0 +1,460 @@
+package reader
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"log"
+	"net/url"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lsongdev/feedreader/feed"
+)
+
+// Import jobs fetch importWorkers feeds at a time, and are kept in memory
+// for importJobTTL after they finish.
+const (
+	importWorkers = 4
+	importJobTTL  = 24 * time.Hour
+)
+
+// Import job states.
+const (
+	ImportRunning = "running"
+	ImportDone    = "done"
+)
+
+// Outcomes of importing a feed.
+const (
+	ImportAdded   = "added"
+	ImportSkipped = "skipped"
+	ImportFailed  = "failed"
+)
+
+// ImportResult is the outcome of importing one feed outline.
+type ImportResult struct {
+	Title    string `json:"title"`
+	URL      string `json:"url"`
+	Category string `json:"category"`
+	Error    string `json:"error,omitempty"`
+}
+
+// ImportReport lists the feeds an import subscribed to, the ones skipped
+// because the user already follows them and the ones that failed.
+type ImportReport struct {
+	Added   []ImportResult `json:"added"`
+	Skipped []ImportResult `json:"skipped"`
+	Failed  []ImportResult `json:"failed"`
+}
+
+func (report *ImportReport) add(outcome string, result ImportResult) {
+	switch outcome {
+	case ImportAdded:
+		report.Added = append(report.Added, result)
+	case ImportSkipped:
+		report.Skipped = append(report.Skipped, result)
+	default:
+		report.Failed = append(report.Failed, result)
+	}
+}
+
+// ImportJob is an OPML import running in the background.
+type ImportJob struct {
+	Id         string       `json:"id"`
+	Status     string       `json:"status"`
+	Total      int          `json:"total"`
+	Done       int          `json:"done"`
+	Report     ImportReport `json:"report"`
+	StartedAt  time.Time    `json:"started_at"`
+	FinishedAt *time.Time   `json:"finished_at,omitempty"`
+	userId     int
+}
+
+// importStore keeps the import jobs of every user.
+type importStore struct {
+	mu   sync.Mutex
+	jobs map[string]*ImportJob
+}
+
+func newImportStore() *importStore {
+	return &importStore{jobs: make(map[string]*ImportJob)}
+}
+
+func (store *importStore) add(job *ImportJob) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	for id, old := range store.jobs {
+		if old.FinishedAt != nil && time.Since(*old.FinishedAt) > importJobTTL {
+			delete(store.jobs, id)
+		}
+	}
+	store.jobs[job.Id] = job
+}
+
+// get returns a copy of one of the user's jobs, or nil.
+func (store *importStore) get(id string, userId int) *ImportJob {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	job, ok := store.jobs[id]
+	if !ok || job.userId != userId {
+		return nil
+	}
+	snapshot := *job
+	snapshot.Report = ImportReport{
+		Added:   slices.Clone(job.Report.Added),
+		Skipped: slices.Clone(job.Report.Skipped),
+		Failed:  slices.Clone(job.Report.Failed),
+	}
+	return &snapshot
+}
+
+// record adds the outcome of a feed to the job, finishing it after the last.
+func (store *importStore) record(job *ImportJob, outcome string, result ImportResult) (done int, finished bool) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	job.Report.add(outcome, result)
+	job.Done++
+	if job.Done == job.Total {
+		now := time.Now()
+		job.Status, job.FinishedAt = ImportDone, &now
+	}
+	return job.Done, job.FinishedAt != nil
+}
+
+// importItem is a feed outline to import into a category.
+type importItem struct {
+	outline    feed.Outline
+	link       string
+	category   string
+	categoryId int
+}
+
+// ImportOPML starts importing the feeds of an OPML document in the
+// background, in categories named after their folders. Nested folders are
+// joined with a slash, and feeds outside any folder use their category
+// attribute or the default category. Each feed is fetched, or discovered
+// from its page when the outline links a web site, and subscribed to with
+// its first posts. Importing the same document twice skips every feed.
+func (reader *Reader) ImportOPML(data []byte) (*ImportJob, error) {
+	opml, err := feed.ParseOPML(data)
+	if err != nil {
+		return nil, err
+	}
+	job := &ImportJob{
+		Id:        randomString(12),
+		Status:    ImportRunning,
+		Report:    ImportReport{Added: []ImportResult{}, Skipped: []ImportResult{}, Failed: []ImportResult{}},
+		StartedAt: time.Now(),
+		userId:    reader.userId(),
+	}
+	items, err := reader.importItems(opml.Outlines, "", map[string]int{})
+	if err != nil {
+		return nil, err
+	}
+	job.Total = len(items)
+	if job.Total == 0 {
+		job.Status, job.FinishedAt = ImportDone, &job.StartedAt
+	}
+	reader.imports.add(job)
+	go reader.runImport(job, items)
+	return reader.imports.get(job.Id, job.userId), nil
+}
+
+// importItems flattens the outlines into the feeds to import, creating the
+// categories they go into. Empty folders are kept as empty categories.
+func (reader *Reader) importItems(outlines []feed.Outline, folder string, categories map[string]int) (items []importItem, err error) {
+	categoryId := func(name string) (id int, err error) {
+		if id, ok := categories[name]; ok {
+			return id, nil
+		}
+		if name == "" {
+			id, err = reader.DefaultCategoryId()
+		} else {
+			id, err = reader.GetOrCreateCategory(name)
+		}
+		categories[name] = id
+		return
+	}
+	for _, outline := range outlines {
+		// Outlines with only a web site are discovered from the page.
+		link := outline.XMLURL
+		if link == "" && len(outline.Outlines) == 0 {
+			link = outline.HTMLURL
+		}
+		if link == "" {
+			name := outline.Name()
+			if folder != "" && name != "" {
+				name = folder + "/" + name
+			} else if name == "" {
+				name = folder
+			}
+			if len(outline.Outlines) == 0 && name != "" {
+				if _, err = categoryId(name); err != nil {
+					return
+				}
+				continue
+			}
+			children, err := reader.importItems(outline.Outlines, name, categories)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, children...)
+			continue
+		}
+		item := importItem{outline: outline, link: link, category: folder}
+		if item.category == "" {
+			if names := outline.Categories(); len(names) > 0 {
+				item.category = names[0]
+			}
+		}
+		if item.categoryId, err = categoryId(item.category); err != nil {
+			return
+		}
+		items = append(items, item)
+	}
+	return
+}
+
+// runImport imports the items with a few workers, publishing the outcome of
+// each feed to the user as it is known.
+func (reader *Reader) runImport(job *ImportJob, items []importItem) {
+	queue := make(chan importItem)
+	var subscribing sync.Mutex
+	var wg sync.WaitGroup
+	for i := 0; i < min(importWorkers, len(items)); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for item := range queue {
+				outcome, result := reader.importFeed(item, &subscribing)
+				done, finished := reader.imports.record(job, outcome, result)
+				reader.events.Publish(Event{Type: EventImportProgress, Users: []int{job.userId}, Data: H{
+					"id": job.Id, "done": done, "total": job.Total, "finished": finished,
+					"outcome": outcome, "result": result,
+				}})
+			}
+		}()
+	}
+	for _, item := range items {
+		queue <- item
+	}
+	close(queue)
+	wg.Wait()
+	log.Printf("Imported OPML for user %d: %d feeds", job.userId, job.Total)
+}
+
+// importFeed validates, fetches and subscribes to one feed. Subscribing
+// holds the lock so duplicates in the document are skipped.
+func (reader *Reader) importFeed(item importItem, subscribing *sync.Mutex) (string, ImportResult) {
+	outline := item.outline
+	result := ImportResult{Title: outline.Name(), URL: item.link, Category: item.category}
+	fail := func(err error) (string, ImportResult) {
+		result.Error = err.Error()
+		return ImportFailed, result
+	}
+	if u, err := url.Parse(item.link); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fail(fmt.Errorf("%w: %q is not an http(s) URL", ErrInvalidFeed, item.link))
+	}
+	if reader.subscribed(item.link) {
+		return ImportSkipped, result
+	}
+	data, link, err := feed.DiscoverFeed(item.link, nil)
+	if err != nil {
+		return fail(err)
+	}
+	result.URL = link
+	if result.Title == "" {
+		result.Title = data.Title
+	}
+	if result.Title == "" {
+		result.Title = link
+	}
+	home := outline.HTMLURL
+	if home == "" {
+		home = data.Link
+	}
+	subscribing.Lock()
+	if reader.subscribed(link) {
+		subscribing.Unlock()
+		return ImportSkipped, result
+	}
+	id, err := reader.CreateFeed(string(data.Type), result.Title, home, link, item.categoryId)
+	if err == nil {
+		err = reader.setSubscriptionAttributes(id, outlineAttributes(outline))
+	}
+	subscribing.Unlock()
+	if err != nil {
+		return fail(err)
+	}
+	reader.saveFetch(fmt.Sprint(id), data, nil)
+	return ImportAdded, result
+}
+
+// subscribed reports whether the user follows the feed at link.
+func (reader *Reader) subscribed(link string) bool {
+	var id int
+	err := reader.db.QueryRow(fmt.Sprintf("SELECT f.id FROM %s f WHERE f.link = ?", reader.feedsTable()), link).Scan(&id)
+	return err == nil
+}
+
+// setSubscriptionAttributes stores the extra OPML attributes of a feed.
+func (reader *Reader) setSubscriptionAttributes(feedId int, attributes map[string]string) error {
+	if len(attributes) == 0 {
+		return nil
+	}
+	data, err := json.Marshal(attributes)
+	if err != nil {
+		return err
+	}
+	_, err = reader.db.Exec(
+		"UPDATE subscriptions SET attributes = ? WHERE user_id = ? AND feed_id = ?", string(data), reader.userId(), feedId,
+	)
+	return err
+}
+
+// ImportJob returns one of the user's import jobs, or nil.
+func (reader *Reader) ImportJob(id string) *ImportJob {
+	return reader.imports.get(id, reader.userId())
+}
+
+// outlineAttributes collects the attributes of a feed outline that have no
+// column of their own, to write them back on export.
+func outlineAttributes(outline feed.Outline) map[string]string {
+	attributes := map[string]string{}
+	for name, value := range map[string]string{
+		"description": outline.Description,
+		"language":    outline.Language,
+		"category":    outline.Category,
+	} {
+		if value != "" {
+			attributes[name] = value
+		}
+	}
+	for _, attr := range outline.Attrs {
+		if attr.Name.Space == "" {
+			attributes[attr.Name.Local] = attr.Value
+		}
+	}
+	return attributes
+}
+
+// setOutlineAttributes is the reverse of outlineAttributes.
+func setOutlineAttributes(outline *feed.Outline, attributes map[string]string) {
+	names := make([]string, 0, len(attributes))
+	for name := range attributes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		value := attributes[name]
+		switch name {
+		case "description":
+			outline.Description = value
+		case "language":
+			outline.Language = value
+		case "category":
+			outline.Category = value
+		default:
+			outline.Attrs = append(outline.Attrs, xml.Attr{Name: xml.Name{Local: name}, Value: value})
+		}
+	}
+}
+
+// ExportOPML builds an OPML document with a folder of subscriptions for
+// each category. Categories named like "Tech/Go", as nested folders are
+// imported, are nested again.
+func (reader *Reader) ExportOPML() (out *feed.OPML, err error) {
+	categories, err := reader.GetCategories()
+	if err != nil {
+		return
+	}
+	subscriptions, err := reader.GetFeeds(nil)
+	if err != nil {
+		return
+	}
+	attributes, err := reader.subscriptionAttributes()
+	if err != nil {
+		return
+	}
+	out = &feed.OPML{
+		Version:     "2.0",
+		Title:       reader.config.Title,
+		DateCreated: time.Now().UTC().Format(time.RFC1123Z),
+	}
+	for _, category := range categories {
+		folder := outlineFolder(&out.Outlines, strings.Split(category.Name, "/"))
+		for _, subscription := range subscriptions {
+			if subscription.Category.Id != category.Id {
+				continue
+			}
+			outline := feed.Outline{
+				Type:    subscription.Type,
+				Title:   subscription.Name,
+				Text:    subscription.Name,
+				HTMLURL: subscription.Home,
+				XMLURL:  subscription.Link,
+			}
+			setOutlineAttributes(&outline, attributes[subscription.Id])
+			folder.Outlines = append(folder.Outlines, outline)
+		}
+	}
+	return
+}
+
+// outlineFolder returns the folder at the path below outlines, adding the
+// folders that are missing. Empty path segments are skipped.
+func outlineFolder(outlines *[]feed.Outline, path []string) *feed.Outline {
+	var folder *feed.Outline
+	for _, name := range path {
+		if name = strings.TrimSpace(name); name == "" {
+			continue
+		}
+		folder = nil
+		for i := range *outlines {
+			if outline := &(*outlines)[i]; outline.XMLURL == "" && outline.Text == name {
+				folder = outline
+				break
+			}
+		}
+		if folder == nil {
+			*outlines = append(*outlines, feed.Outline{Text: name, Title: name})
+			folder = &(*outlines)[len(*outlines)-1]
+		}
+		outlines = &folder.Outlines
+	}
+	if folder == nil {
+		// A category without a usable name still gets its own folder.
+		*outlines = append(*outlines, feed.Outline{})
+		folder = &(*outlines)[len(*outlines)-1]
+	}
+	return folder
+}
+
+// subscriptionAttributes returns the stored OPML attributes of the user's
+// subscriptions by feed id.
+func (reader *Reader) subscriptionAttributes() (map[int]map[string]string, error) {
+	rows, err := reader.db.Query(
+		"SELECT feed_id, attributes FROM subscriptions WHERE user_id = ? AND attributes IS NOT NULL", reader.userId(),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	attributes := map[int]map[string]string{}
+	for rows.Next() {
+		var id int
+		var data string
+		if err := rows.Scan(&id, &data); err != nil {
+			return nil, err
+		}
+		var values map[string]string
+		if json.Unmarshal([]byte(data), &values) == nil {
+			attributes[id] = values
+		}
+	}
+	return attributes, rows.Err()
+}
@@ -0,0 +1,84 @@
+package reader
+
+import (
+	"bytes"
+	"slices"
+	"testing"
+
+	"github.com/lsongdev/feedreader/feed"
+)
+
+func TestOPMLRoundTripKeepsNesting(t *testing.T) {
+	reader := newTestReader(t)
+	user := reader.As(&reader.config.Users[0])
+	for link, category := range map[string]string{
+		"https://example.com/news.xml": "Tech",
+		"https://example.com/go.xml":   "Tech/Go",
+		"https://example.com/rust.xml": "Tech/Rust",
+		"https://example.com/food.xml": "Food",
+	} {
+		categoryId, err := user.GetOrCreateCategory(category)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := user.CreateFeed("rss", link, "", link, categoryId); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	exported, err := user.ExportOPML()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := feed.WriteOPML(&buf, exported); err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := feed.ParseOPML(buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var tech *feed.Outline
+	for i, outline := range parsed.Outlines {
+		if outline.Text == "Tech/Go" || outline.Text == "Tech/Rust" {
+			t.Errorf("flat folder %q in the export", outline.Text)
+		}
+		if outline.Text == "Tech" {
+			tech = &parsed.Outlines[i]
+		}
+	}
+	if tech == nil {
+		t.Fatalf("no Tech folder in %+v", parsed.Outlines)
+	}
+	var children []string
+	for _, outline := range tech.Outlines {
+		children = append(children, outline.Text)
+	}
+	slices.Sort(children)
+	if want := []string{"Go", "Rust", "https://example.com/news.xml"}; !slices.Equal(children, want) {
+		t.Errorf("Tech holds %v, want %v", children, want)
+	}
+
+	// Importing the export for another user gives the same categories.
+	bob, err := reader.ProvisionUser("bob", "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	items, err := reader.As(bob).importItems(parsed.Outlines, "", map[string]int{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	imported := map[string]string{}
+	for _, item := range items {
+		imported[item.link] = item.category
+	}
+	subscriptions, err := user.GetFeeds(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, subscription := range subscriptions {
+		if got := imported[subscription.Link]; got != subscription.Category.Name {
+			t.Errorf("%s: imported into %q, want %q", subscription.Link, got, subscription.Category.Name)
+		}
+	}
+}
@@ -0,0 +1,236 @@
+package reader
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lsongdev/feedreader/feed"
+)
+
+// Output feeds hold outputDefaultLimit posts unless ?limit= asks for more.
+const (
+	outputDefaultLimit = 50
+	outputMaxLimit     = 500
+)
+
+const generatorURI = "https://github.com/lsongdev/feedreader"
+
+// outputFeed selects the posts for the query's filters as a channel for the
+// feed writers. Ids are tag: URIs of the base URL's host, dated when the user
+// and the post were created.
+func (reader *Reader) outputFeed(r *http.Request, query url.Values) (*feed.Channel, error) {
+	conditions, args, err := postConditions(query)
+	if err != nil {
+		return nil, err
+	}
+	limit := outputDefaultLimit
+	if query.Has("limit") {
+		if limit, err = strconv.Atoi(query.Get("limit")); err != nil || limit < 1 {
+			return nil, fmt.Errorf("invalid limit: %q", query.Get("limit"))
+		}
+		limit = min(limit, outputMaxLimit)
+	}
+	scope, err := reader.outputScope(query)
+	if err != nil {
+		return nil, err
+	}
+	posts, err := reader.GetPosts(conditions, &Pagination{Page: 1, Size: limit}, args...)
+	if err != nil {
+		return nil, err
+	}
+	base := reader.baseURL(r)
+	host := r.Host
+	if u, err := url.Parse(base); err == nil && u.Host != "" {
+		host = u.Host
+	}
+	filter := postFilterQuery(query)
+	channel := &feed.Channel{
+		ID:          feed.TagURI(host, reader.userCreatedAt(), "posts?"+filter),
+		Title:       reader.config.Title,
+		Description: "All posts",
+		Link:        base + "/posts",
+		Self:        base + r.URL.RequestURI(),
+		Updated:     time.Unix(0, 0).UTC(),
+		Generator:   generatorURI,
+	}
+	if len(scope) > 0 {
+		channel.Title += " - " + strings.Join(scope, ", ")
+		channel.Description = strings.Join(scope, ", ")
+	}
+	if filter != "" {
+		channel.Link += "?" + filter
+	}
+	for _, post := range posts {
+		if post.CreatedAt.After(channel.Updated) {
+			channel.Updated = post.CreatedAt.UTC()
+		}
+		channel.Entries = append(channel.Entries, feed.Entry{
+			ID:         feed.TagURI(host, post.CreatedAt, fmt.Sprintf("post/%d", post.Id)),
+			Title:      post.Title,
+			Link:       post.Link,
+			Content:    post.Content,
+			Author:     postAuthor(post),
+			Published:  post.PubDate,
+			Categories: []string{post.Feed.Category.Name},
+			Source: &feed.Source{
+				Title: post.Feed.Name,
+				Link:  post.Feed.Home,
+				Self:  post.Feed.Link,
+			},
+		})
+	}
+	return channel, nil
+}
+
+// userCreatedAt is when the current user was added, the date of feed ids.
+func (reader *Reader) userCreatedAt() (createdAt time.Time) {
+	reader.db.QueryRow("SELECT created_at FROM users WHERE id = ?", reader.userId()).Scan(&createdAt)
+	return
+}
+
+// outputScope names the filters of an output feed for its title. Unknown
+// feeds, categories and tags are reported as sql.ErrNoRows.
+func (reader *Reader) outputScope(query url.Values) (scope []string, err error) {
+	lookups := []struct {
+		param, table, column string
+	}{
+		{"feed", reader.feedsTable(), "id"},
+		{"category", reader.categoriesTable(), "id"},
+		{"tag", reader.categoriesTable(), "name"},
+	}
+	for _, lookup := range lookups {
+		if !query.Has(lookup.param) {
+			continue
+		}
+		var name string
+		err = reader.db.QueryRow(
+			fmt.Sprintf("SELECT name FROM %s WHERE %s = ?", lookup.table, lookup.column), query.Get(lookup.param),
+		).Scan(&name)
+		if err != nil {
+			return nil, fmt.Errorf("%s not found: %w", lookup.param, err)
+		}
+		scope = append(scope, name)
+	}
+	for _, filter := range []struct{ param, name string }{
+		{"unread", "Unread"}, {"readed", "Read"}, {"saved", "Saved"},
+	} {
+		if query.Has(filter.param) {
+			scope = append(scope, filter.name)
+		}
+	}
+	if q := query.Get("q"); q != "" {
+		scope = append(scope, fmt.Sprintf("Search %q", q))
+	}
+	return
+}
+
+// postAuthor falls back to the feed's name for posts without an author.
+func postAuthor(post Post) string {
+	if post.Author != "" {
+		return post.Author
+	}
+	return post.Feed.Name
+}
+
+// jsonFeed converts a channel to JSON Feed 1.1.
+func jsonFeed(channel *feed.Channel) feed.JSONFeed {
+	doc := feed.JSONFeed{
+		Version:     feed.JSONFeedVersion,
+		Title:       channel.Title,
+		HomePageURL: channel.Link,
+		FeedURL:     channel.Self,
+		Description: channel.Description,
+		Items:       []feed.JSONFeedItem{},
+	}
+	for _, entry := range channel.Entries {
+		item := feed.JSONFeedItem{
+			ID:            entry.ID,
+			URL:           entry.Link,
+			Title:         entry.Title,
+			ContentHTML:   entry.Content,
+			DatePublished: entry.Published.UTC().Format(time.RFC3339),
+			Authors:       []feed.JSONFeedAuthor{{Name: entry.Author}},
+			Tags:          entry.Categories,
+		}
+		if item.ID == "" {
+			item.ID = entry.Link
+		}
+		if source := entry.Source; source != nil {
+			item.Source = &feed.JSONFeedSource{Title: source.Title, HomePageURL: source.Link, FeedURL: source.Self}
+		}
+		doc.Items = append(doc.Items, item)
+	}
+	return doc
+}
+
+// outputFormat is a content type and a writer for output feeds.
+type outputFormat struct {
+	contentType string
+	encode      func(io.Writer, *feed.Channel) error
+}
+
+// outputFormats are the output feeds by file name.
+var outputFormats = map[string]outputFormat{
+	"rss.xml":   {"application/rss+xml; charset=utf-8", feed.WriteRSS},
+	"atom.xml":  {"application/atom+xml; charset=utf-8", feed.WriteAtom},
+	"feed.json": {"application/feed+json; charset=utf-8", writeJSONFeed},
+}
+
+func writeJSONFeed(w io.Writer, channel *feed.Channel) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(jsonFeed(channel))
+}
+
+// serveOutput writes the output feed for the query's filters with an ETag of
+// its content, and the time the newest post arrived as Last-Modified.
+func (reader *Reader) serveOutput(w http.ResponseWriter, r *http.Request, query url.Values, format outputFormat) {
+	if !requireMethod(w, r, "GET", "HEAD") {
+		return
+	}
+	channel, err := reader.outputFeed(r, query)
+	if err != nil {
+		status := http.StatusBadRequest
+		if errors.Is(err, sql.ErrNoRows) {
+			status = http.StatusNotFound
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+	var body bytes.Buffer
+	if err := format.encode(&body, channel); err != nil {
+		reader.Error(w, err)
+		return
+	}
+	sum := sha256.Sum256(body.Bytes())
+	w.Header().Set("Content-Type", format.contentType)
+	w.Header().Set("ETag", `"`+hex.EncodeToString(sum[:16])+`"`)
+	w.Header().Set("Cache-Control", "private, no-cache")
+	http.ServeContent(w, r, "", channel.Updated, bytes.NewReader(body.Bytes()))
+}
+
+// RssXml publishes the posts matching the /posts filters as RSS 2.0.
+func (reader *Reader) RssXml(w http.ResponseWriter, r *http.Request) {
+	reader.forRequest(r).serveOutput(w, r, r.URL.Query(), outputFormats["rss.xml"])
+}
+
+// AomXml publishes the posts matching the /posts filters as Atom 1.0.
+func (reader *Reader) AomXml(w http.ResponseWriter, r *http.Request) {
+	reader.forRequest(r).serveOutput(w, r, r.URL.Query(), outputFormats["atom.xml"])
+}
+
+// FeedJson publishes the posts matching the /posts filters as JSON Feed 1.1.
+func (reader *Reader) FeedJson(w http.ResponseWriter, r *http.Request) {
+	reader.forRequest(r).serveOutput(w, r, r.URL.Query(), outputFormats["feed.json"])
+}
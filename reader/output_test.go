@@ -0,0 +1,63 @@
+package reader
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// outputIds fetches feed.json as the admin and returns its feed URL and the
+// id of its first item.
+func outputIds(t *testing.T, reader *Reader, r *http.Request) (feedURL, itemId string) {
+	t.Helper()
+	w := httptest.NewRecorder()
+	reader.FeedJson(w, withUser(r, &reader.config.Users[0]))
+	if w.Code != http.StatusOK {
+		t.Fatalf("feed.json: %d %s", w.Code, w.Body)
+	}
+	var doc struct {
+		FeedURL string `json:"feed_url"`
+		Items   []struct {
+			ID string `json:"id"`
+		} `json:"items"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&doc); err != nil {
+		t.Fatal(err)
+	}
+	if len(doc.Items) == 0 {
+		t.Fatal("feed.json has no items")
+	}
+	return doc.FeedURL, doc.Items[0].ID
+}
+
+func TestOutputUsesBaseURL(t *testing.T) {
+	reader := newTestReader(t)
+	addTestPost(t, reader.As(&reader.config.Users[0]))
+	forged := func() *http.Request {
+		r := httptest.NewRequest("GET", "/feed.json", nil)
+		r.Host = "evil.example"
+		r.RemoteAddr = "203.0.113.7:4000"
+		r.Header.Set("X-Forwarded-Proto", "https")
+		return r
+	}
+
+	// Without a base URL the forwarded scheme is only believed from a trusted proxy.
+	if self, _ := outputIds(t, reader, forged()); !strings.HasPrefix(self, "http://") {
+		t.Errorf("untrusted X-Forwarded-Proto used: %s", self)
+	}
+	reader.proxies = parseNetworks([]string{"203.0.113.0/24"})
+	if self, _ := outputIds(t, reader, forged()); !strings.HasPrefix(self, "https://") {
+		t.Errorf("trusted X-Forwarded-Proto ignored: %s", self)
+	}
+
+	reader.config.BaseURL = "https://reader.example.com/"
+	self, id := outputIds(t, reader, forged())
+	if self != "https://reader.example.com/feed.json" {
+		t.Errorf("feed_url = %s", self)
+	}
+	if !strings.HasPrefix(id, "tag:reader.example.com,") {
+		t.Errorf("id = %s", id)
+	}
+}
@@ -0,0 +1,105 @@
+package reader
+
+import (
+	"log"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// ProxyAuthConfig trusts the username set in a header by an authenticating
+// reverse proxy, but only for requests coming from the proxy itself.
+type ProxyAuthConfig struct {
+	// Header defaults to Remote-User.
+	Header string `json:"header" yaml:"header"`
+	// TrustedProxies lists the CIDRs, or single IPs, of the proxies.
+	TrustedProxies []string `json:"trusted_proxies" yaml:"trusted_proxies"`
+	// AutoProvision creates users the proxy knows but config.yaml does not.
+	AutoProvision bool `json:"auto_provision" yaml:"auto_provision"`
+}
+
+type proxyAuth struct {
+	reader   *Reader
+	config   *ProxyAuthConfig
+	header   string
+	networks []*net.IPNet
+}
+
+func newProxyAuth(reader *Reader, config *ProxyAuthConfig) *proxyAuth {
+	auth := &proxyAuth{reader: reader, config: config, header: config.Header}
+	if auth.header == "" {
+		auth.header = "Remote-User"
+	}
+	auth.networks = parseNetworks(config.TrustedProxies)
+	if len(auth.networks) == 0 {
+		log.Printf("Proxy auth is configured without trusted_proxies, the %s header will be ignored", auth.header)
+	}
+	return auth
+}
+
+// parseNetworks parses a list of CIDRs or single IPs, skipping invalid ones.
+func parseNetworks(cidrs []string) (networks []*net.IPNet) {
+	for _, cidr := range cidrs {
+		if !strings.Contains(cidr, "/") {
+			if strings.Contains(cidr, ":") {
+				cidr += "/128"
+			} else {
+				cidr += "/32"
+			}
+		}
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			log.Printf("Ignoring invalid trusted proxy %q: %v", cidr, err)
+			continue
+		}
+		networks = append(networks, network)
+	}
+	return
+}
+
+// sentFrom reports whether the request comes from one of the networks.
+func sentFrom(r *http.Request, networks []*net.IPNet) bool {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	for _, network := range networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// trusted reports whether the request was sent by one of the trusted proxies.
+func (auth *proxyAuth) trusted(r *http.Request) bool {
+	return sentFrom(r, auth.networks)
+}
+
+// username returns the user named by a trusted proxy.
+func (auth *proxyAuth) username(r *http.Request) string {
+	if !auth.trusted(r) {
+		return ""
+	}
+	return strings.TrimSpace(r.Header.Get(auth.header))
+}
+
+func (auth *proxyAuth) Authenticate(r *http.Request) *User {
+	username := auth.username(r)
+	if username == "" {
+		return nil
+	}
+	if !auth.config.AutoProvision {
+		return auth.reader.FindUser(username)
+	}
+	user, err := auth.reader.ProvisionUser(username, "proxy")
+	if err != nil {
+		log.Println("Proxy auth:", err)
+		return nil
+	}
+	return user
+}
@@ -0,0 +1,180 @@
+package reader
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"io/fs"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// staticPaths are the PWA files and assets stored with the templates. They
+// hold no user data, and browsers fetch the manifest without cookies.
+var staticPaths = []string{
+	"/manifest.webmanifest",
+	"/sw.js",
+	"/offline.js",
+	"/icon.svg",
+	"/static/",
+}
+
+// maxProxiedImage caps the size of an image fetched through ImageProxyView.
+const maxProxiedImage = 10 << 20
+
+// imageProxyClient only connects to public addresses. The check runs on the
+// resolved address of every connection, redirects included, so a host name
+// cannot point the proxy at the server's own network.
+var imageProxyClient = &http.Client{
+	Timeout: 30 * time.Second,
+	Transport: &http.Transport{
+		DialContext: (&net.Dialer{
+			Timeout: 10 * time.Second,
+			Control: publicAddressOnly,
+		}).DialContext,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ResponseHeaderTimeout: 15 * time.Second,
+	},
+}
+
+var errPrivateAddress = errors.New("private address")
+
+// reservedPrefixes are special-purpose ranges that netip does not classify:
+// "this network", carrier-grade NAT, IETF protocol assignments, benchmarking,
+// the reserved class E, and the IPv6 translation prefixes that can embed any
+// IPv4 address.
+var reservedPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),
+	netip.MustParsePrefix("100.64.0.0/10"),
+	netip.MustParsePrefix("192.0.0.0/24"),
+	netip.MustParsePrefix("198.18.0.0/15"),
+	netip.MustParsePrefix("240.0.0.0/4"),
+	netip.MustParsePrefix("64:ff9b::/96"),
+	netip.MustParsePrefix("64:ff9b:1::/48"),
+	netip.MustParsePrefix("2002::/16"),
+}
+
+// isPublicAddress reports whether an address is routable on the internet,
+// rejecting loopback, private, link-local (cloud metadata) and other
+// special-purpose ranges.
+func isPublicAddress(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	if !addr.IsValid() || !addr.IsGlobalUnicast() || addr.IsPrivate() {
+		return false
+	}
+	for _, prefix := range reservedPrefixes {
+		if prefix.Contains(addr) {
+			return false
+		}
+	}
+	return true
+}
+
+// publicAddressOnly is a net.Dialer Control function that refuses to
+// connect to non-public addresses.
+func publicAddressOnly(network, address string, _ syscall.RawConn) error {
+	addrPort, err := netip.ParseAddrPort(address)
+	if err != nil || !isPublicAddress(addrPort.Addr()) {
+		return errPrivateAddress
+	}
+	return nil
+}
+
+// StaticView serves the PWA files, which config.Dir/templates can override.
+func (reader *Reader) StaticView(w http.ResponseWriter, r *http.Request) {
+	if !requireMethod(w, r, "GET", "HEAD") {
+		return
+	}
+	name := strings.TrimPrefix(r.URL.Path, "/")
+	switch name {
+	case "manifest.webmanifest":
+		reader.manifest(w)
+		return
+	case "sw.js":
+		// Browsers check for a new service worker on every visit.
+		w.Header().Set("Cache-Control", "no-cache")
+	}
+	http.ServeFileFS(w, r, reader.views.files, name)
+}
+
+// manifest serves the web app manifest named after the configured title.
+func (reader *Reader) manifest(w http.ResponseWriter) {
+	data, err := fs.ReadFile(reader.views.files, "manifest.webmanifest")
+	if err != nil {
+		reader.Error(w, err)
+		return
+	}
+	var manifest map[string]any
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		reader.Error(w, err)
+		return
+	}
+	manifest["name"] = reader.config.Title
+	manifest["short_name"] = reader.config.Title
+	w.Header().Set("Content-Type", "application/manifest+json")
+	json.NewEncoder(w).Encode(manifest)
+}
+
+// OfflineView renders the page that reads posts from the offline cache. The
+// service worker shows it for any page that cannot be loaded.
+func (reader *Reader) OfflineView(w http.ResponseWriter, r *http.Request) {
+	reader = reader.forRequest(r)
+	reader.Render(w, "offline", H{"autoMarkRead": reader.config.MarkReadOnOpen()})
+}
+
+// ImageProxyView fetches an image for a signed-in user, so that images in
+// posts can be cached for offline reading under this origin.
+func (reader *Reader) ImageProxyView(w http.ResponseWriter, r *http.Request) {
+	reader = reader.forRequest(r)
+	if !requireMethod(w, r, "GET") {
+		return
+	}
+	if reader.user == nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	link := r.URL.Query().Get("url")
+	u, err := url.Parse(link)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		http.Error(w, "Invalid image url", http.StatusBadRequest)
+		return
+	}
+	req, err := http.NewRequestWithContext(r.Context(), "GET", u.String(), nil)
+	if err != nil {
+		http.Error(w, "Invalid image url", http.StatusBadRequest)
+		return
+	}
+	req.Header.Set("Accept", "image/*")
+	// Failures look the same whatever the cause, so that the proxy cannot be
+	// used to probe which hosts the server can reach.
+	res, err := imageProxyClient.Do(req)
+	if err != nil {
+		http.Error(w, "Image fetch failed", http.StatusBadGateway)
+		return
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		http.Error(w, "Image fetch failed", http.StatusBadGateway)
+		return
+	}
+	contentType := res.Header.Get("Content-Type")
+	if !strings.HasPrefix(contentType, "image/") {
+		http.Error(w, "Not an image", http.StatusUnsupportedMediaType)
+		return
+	}
+	if res.ContentLength > maxProxiedImage {
+		http.Error(w, "Image too large", http.StatusBadGateway)
+		return
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Cache-Control", "private, max-age=86400")
+	// SVG can carry scripts, never let a proxied image act as a page.
+	w.Header().Set("Content-Security-Policy", "default-src 'none'; style-src 'unsafe-inline'; sandbox")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	io.Copy(w, io.LimitReader(res.Body, maxProxiedImage))
+}
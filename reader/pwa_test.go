@@ -0,0 +1,66 @@
+package reader
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestIsPublicAddress(t *testing.T) {
+	for addr, want := range map[string]bool{
+		"93.184.216.34":          true,
+		"2606:2800:220:1::248":   true,
+		"127.0.0.1":              false,
+		"::1":                    false,
+		"10.1.2.3":               false,
+		"172.16.0.1":             false,
+		"192.168.1.1":            false,
+		"169.254.169.254":        false,
+		"fe80::1":                false,
+		"fd00:ec2::254":          false,
+		"100.100.100.200":        false,
+		"0.0.0.0":                false,
+		"::":                     false,
+		"::ffff:127.0.0.1":       false,
+		"::ffff:169.254.169.254": false,
+		"64:ff9b::a9fe:a9fe":     false,
+		"2002:a9fe:a9fe::1":      false,
+		"224.0.0.1":              false,
+		"255.255.255.255":        false,
+	} {
+		if got := isPublicAddress(netip.MustParseAddr(addr)); got != want {
+			t.Errorf("%s: public %v, want %v", addr, got, want)
+		}
+	}
+}
+
+func TestImageProxyRejectsInternalHosts(t *testing.T) {
+	reader := newTestReader(t)
+	internal := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		w.Write([]byte("secret"))
+	}))
+	defer internal.Close()
+	// An internal host that answers and one that does not look the same.
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closed.Close()
+	var bodies []string
+	for _, link := range []string{internal.URL + "/image.png", closed.URL + "/image.png", "http://169.254.169.254/latest/meta-data/"} {
+		r := httptest.NewRequest("GET", "/proxy?url="+url.QueryEscape(link), nil)
+		r = withUser(r, &reader.config.Users[0])
+		w := httptest.NewRecorder()
+		reader.ImageProxyView(w, r)
+		if w.Code != http.StatusBadGateway || strings.Contains(w.Body.String(), "secret") {
+			t.Errorf("%s: %d %q", link, w.Code, w.Body)
+		}
+		bodies = append(bodies, w.Body.String())
+	}
+	for _, body := range bodies[1:] {
+		if body != bodies[0] {
+			t.Errorf("errors reveal the host: %q", bodies)
+		}
+	}
+}
@@ -2,10 +2,15 @@ package reader
 
 import (
 	"database/sql"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"net"
+	"net/url"
 	"os"
 	"path"
+	"strconv"
 	"strings"
 	"time"
 
@@ -14,8 +19,10 @@ import (
 )
 
 type Category struct {
-	Id   int    `json:"id"`
-	Name string `json:"name"`
+	Id       int    `json:"id"`
+	Name     string `json:"name"`
+	Position int    `json:"position"`
+	Unread   int    `json:"unread_count"`
 }
 
 type Feed struct {
@@ -26,8 +33,21 @@ type Feed struct {
 	Link      string    `json:"link"`
 	Category  *Category `json:"category"`
 	CreatedAt time.Time `json:"created_at"`
+	// RefreshInterval is in minutes, 0 uses the configured default.
+	RefreshInterval int `json:"refresh_interval,omitempty"`
+	// Disabled subscriptions are not fetched on their own.
+	Disabled bool `json:"disabled,omitempty"`
+	// FetchOptions never contains the stored password.
+	FetchOptions *feed.FetchOptions `json:"fetch_options,omitempty"`
+	FetchedAt    *time.Time         `json:"fetched_at,omitempty"`
+	FetchError   string             `json:"fetch_error,omitempty"`
+	// List is the feed list managing the subscription, if any.
+	List *FeedList `json:"list,omitempty"`
 }
 
+// ErrInvalidFeed is wrapped by errors about feed input that cannot be saved.
+var ErrInvalidFeed = errors.New("invalid feed")
+
 type Post struct {
 	Feed
 
@@ -35,17 +55,50 @@ type Post struct {
 	Title     string    `json:"title"`
 	Content   string    `json:"content"`
 	Link      string    `json:"link"`
+	Author    string    `json:"author,omitempty"`
 	IsSaved   bool      `json:"is_saved"`
 	IsRead    bool      `json:"is_read"`
 	PubDate   time.Time `json:"pub_date"`
 	CreatedAt time.Time `json:"created_at"`
+	// UpdatedAt is when the post was added or the user last changed its state.
+	UpdatedAt time.Time `json:"updated_at"`
 }
 
 // Reader represents the main application struct.
 type Reader struct {
-	db     *sql.DB
-	tick   *time.Ticker
-	config *Config
+	db      *sql.DB
+	tick    *time.Ticker
+	config  *Config
+	metrics *Metrics
+	// user scopes subscriptions, categories and post state, see As.
+	user *User
+	// csrf is the request's CSRF token, rendered into forms.
+	csrf string
+	// readOnly is set for requests made with an API token lacking the write
+	// scope, which must not change state even when they only look.
+	readOnly bool
+	// proxyAuth and oidc are the optional auth backends from config.Auth.
+	proxyAuth *proxyAuth
+	oidc      *oidcProvider
+	undo      *undoStore
+	imports   *importStore
+	events    *EventBus
+	views     *views
+	// proxies are the config.TrustedProxies networks.
+	proxies []*net.IPNet
+	// theme is the request's light, dark or auto theme.
+	theme string
+	// acceptLanguage is the request's Accept-Language header.
+	acceptLanguage string
+}
+
+// addColumn adds a column missing from tables created by older versions.
+func addColumn(db *sql.DB, table, column, definition string) error {
+	if _, err := db.Exec(fmt.Sprintf("SELECT %s FROM %s LIMIT 0", column, table)); err == nil {
+		return nil
+	}
+	_, err := db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition))
+	return err
 }
 
 // New initializes a new instance of the Reader application.
@@ -59,16 +112,8 @@ func NewReader(config *Config) (reader *Reader, err error) {
 	if err != nil {
 		return
 	}
-	if _, err = db.Exec(`
-		CREATE TABLE IF NOT EXISTS categories (
-			id INTEGER PRIMARY KEY AUTOINCREMENT,
-			name TEXT NOT NULL,
-			UNIQUE (name)
-		)
-	`); err != nil {
-		return
-	}
-	// Create subscriptions table
+	// Create feeds table, one row per unique feed URL shared by all subscribers,
+	// plus private copies of a feed for users who changed how it is fetched
 	if _, err = db.Exec(`
 		CREATE TABLE IF NOT EXISTS feeds (
 			id INTEGER PRIMARY KEY AUTOINCREMENT,
@@ -76,14 +121,27 @@ func NewReader(config *Config) (reader *Reader, err error) {
 			name TEXT,
 			home TEXT,
 			link TEXT,
-			category_id INTEGER default 1,
 			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
-			UNIQUE (name, home, link),
-			FOREIGN KEY (category_id) REFERENCES categories (id)
+			refresh_interval INTEGER DEFAULT 0,
+			fetch_options TEXT,
+			fetched_at DATETIME,
+			fetch_error TEXT,
+			owner_id INTEGER NOT NULL DEFAULT 0,
+			UNIQUE (link, owner_id)
 		)
 	`); err != nil {
 		return
 	}
+	for column, definition := range map[string]string{
+		"refresh_interval": "INTEGER DEFAULT 0",
+		"fetch_options":    "TEXT",
+		"fetched_at":       "DATETIME",
+		"fetch_error":      "TEXT",
+	} {
+		if err = addColumn(db, "feeds", column, definition); err != nil {
+			return
+		}
+	}
 	// Create posts table
 	if _, err = db.Exec(`
 		CREATE TABLE IF NOT EXISTS posts (
@@ -92,9 +150,8 @@ func NewReader(config *Config) (reader *Reader, err error) {
 			title TEXT,
 			content TEXT,
 			link TEXT,
+			author TEXT,
 			pub_date DATETIME,
-			is_read BOOLEAN DEFAULT 0,
-			is_saved BOOLEAN DEFAULT 0,
 			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
 			feed_id INTEGER,
 			FOREIGN KEY (feed_id) REFERENCES feeds (id),
@@ -103,89 +160,466 @@ func NewReader(config *Config) (reader *Reader, err error) {
 	`); err != nil {
 		return
 	}
+	if err = addColumn(db, "posts", "author", "TEXT"); err != nil {
+		return
+	}
+	// Create Google Reader ClientLogin tokens table, only hashes are stored
+	if _, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS greader_tokens (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			token_hash TEXT NOT NULL,
+			username TEXT NOT NULL,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			expires_at DATETIME NOT NULL,
+			UNIQUE (token_hash)
+		)
+	`); err != nil {
+		return
+	}
+	// Create API tokens table, only hashes of the secrets are stored
+	if _, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS api_tokens (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			name TEXT NOT NULL,
+			username TEXT NOT NULL,
+			token_hash TEXT NOT NULL,
+			fever_hash TEXT NOT NULL,
+			scopes TEXT NOT NULL,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			last_used_at DATETIME,
+			UNIQUE (username, name),
+			UNIQUE (token_hash)
+		)
+	`); err != nil {
+		return
+	}
+	// Create shared output feeds table, secrets are hashed like API tokens
+	if _, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS shares (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			name TEXT NOT NULL,
+			username TEXT NOT NULL,
+			token_hash TEXT NOT NULL,
+			filter TEXT NOT NULL,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			last_used_at DATETIME,
+			UNIQUE (username, name),
+			UNIQUE (token_hash)
+		)
+	`); err != nil {
+		return
+	}
+	// Create login sessions table
+	if _, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS sessions (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			token_hash TEXT NOT NULL,
+			username TEXT NOT NULL,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			expires_at DATETIME NOT NULL,
+			UNIQUE (token_hash)
+		)
+	`); err != nil {
+		return
+	}
+	// Create users, categories, subscriptions and per-user post state
+	if err = createUserTables(db, config); err != nil {
+		return
+	}
+	if err = migrateFeedOwners(db); err != nil {
+		return
+	}
+	if err = sanitizePosts(db); err != nil {
+		return
+	}
+	config.checkUsers()
 	// Initialize a ticker with a specified interval for periodic updates
 	tick := time.NewTicker(time.Minute * 1)
 	reader = &Reader{
 		config: config, db: db, tick: tick,
+		metrics: NewMetrics(),
+		undo:    newUndoStore(),
+		imports: newImportStore(),
+		events:  NewEventBus(),
+	}
+	reader.proxies = parseNetworks(config.TrustedProxies)
+	if config.Auth.Proxy != nil {
+		reader.proxyAuth = newProxyAuth(reader, config.Auth.Proxy)
+	}
+	if config.Auth.OIDC != nil {
+		reader.oidc = newOIDCProvider(config.Auth.OIDC)
+	}
+	if reader.views, err = newViews(config.Dir, config.Dev); err != nil {
+		return
+	}
+	if err = reader.ensureDefaultCategories(); err != nil {
+		return
 	}
-	reader.CreateCategory("Default")
 	go reader.updatePostsPeriodically()
+	go reader.syncListsPeriodically()
+	if interval := parseDuration(config.Backup.Interval, 0); interval > 0 {
+		go reader.backupPeriodically(interval)
+	}
 	return
 }
 
 func (reader *Reader) CreateCategory(name string) (id int, err error) {
 	err = reader.db.QueryRow(`
-		INSERT INTO categories (name) VALUES (?) RETURNING id
-	`, name).Scan(&id)
+		INSERT INTO categories (user_id, name, position)
+		SELECT ?, ?, COALESCE(MAX(position), 0) + 1 FROM categories WHERE user_id = ?
+		RETURNING id
+	`, reader.userId(), name, reader.userId()).Scan(&id)
 	return
 }
 
+// GetCategories returns the user's categories in their sort order, with the
+// number of unread posts in each.
 func (reader *Reader) GetCategories() (categories []*Category, err error) {
-	rows, err := reader.db.Query("SELECT id, name FROM categories")
+	rows, err := reader.db.Query(fmt.Sprintf(`
+		SELECT g.id, g.name, g.position, COUNT(p.id)
+		FROM categories g
+		LEFT JOIN %s s ON s.category_id = g.id
+		LEFT JOIN %s p ON p.feed_id = s.id AND p.is_read = 0
+		WHERE g.user_id = ?
+		GROUP BY g.id
+		ORDER BY g.position, g.id`, reader.feedsTable(), reader.postsTable()), reader.userId())
 	if err != nil {
 		return
 	}
 	defer rows.Close()
 	for rows.Next() {
 		var category Category
-		err := rows.Scan(&category.Id, &category.Name)
+		err := rows.Scan(&category.Id, &category.Name, &category.Position, &category.Unread)
 		if err != nil {
 			return nil, err
 		}
 		categories = append(categories, &category)
 	}
+	return categories, rows.Err()
+}
+
+// GetOrCreateCategory returns the id of the category with the given name, creating it if needed.
+func (reader *Reader) GetOrCreateCategory(name string) (id int, err error) {
+	err = reader.db.QueryRow("SELECT id FROM categories WHERE user_id = ? AND name = ?", reader.userId(), name).Scan(&id)
+	if err == sql.ErrNoRows {
+		return reader.CreateCategory(name)
+	}
 	return
 }
 
 func (reader *Reader) UpdateCategory(id int, name string) (err error) {
-	_, err = reader.db.Exec("UPDATE categories SET name = ? WHERE id = ?", name, id)
+	res, err := reader.db.Exec("UPDATE categories SET name = ? WHERE id = ? AND user_id = ?", name, id, reader.userId())
+	if err != nil {
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("category %d not found", id)
+	}
 	return
 }
 
-func (reader *Reader) DeleteCategory(id int) (err error) {
-	_, err = reader.db.Exec("DELETE FROM categories WHERE id = ?", id)
+// DeleteCategory deletes a category after moving its feeds to another one,
+// so no subscription is left without a category.
+func (reader *Reader) DeleteCategory(id, moveTo int) (err error) {
+	if id == moveTo {
+		return fmt.Errorf("cannot move feeds into the category being deleted")
+	}
+	if err = reader.checkCategory(id); err != nil {
+		return
+	}
+	if err = reader.checkCategory(moveTo); err != nil {
+		return
+	}
+	tx, err := reader.db.Begin()
+	if err != nil {
+		return
+	}
+	defer tx.Rollback()
+	if _, err = tx.Exec(`
+		UPDATE subscriptions SET category_id = ? WHERE category_id = ? AND user_id = ?
+	`, moveTo, id, reader.userId()); err != nil {
+		return
+	}
+	if _, err = tx.Exec("DELETE FROM categories WHERE id = ? AND user_id = ?", id, reader.userId()); err != nil {
+		return
+	}
+	return tx.Commit()
+}
+
+// ReorderCategories puts the given categories first, in that order, followed
+// by the remaining ones in their current order.
+func (reader *Reader) ReorderCategories(ids []int) (err error) {
+	categories, err := reader.GetCategories()
+	if err != nil {
+		return
+	}
+	owned := make(map[int]bool)
+	for _, category := range categories {
+		owned[category.Id] = true
+	}
+	var order []int
+	seen := make(map[int]bool)
+	for _, id := range ids {
+		if !owned[id] {
+			return fmt.Errorf("category %d not found", id)
+		}
+		if !seen[id] {
+			seen[id] = true
+			order = append(order, id)
+		}
+	}
+	for _, category := range categories {
+		if !seen[category.Id] {
+			order = append(order, category.Id)
+		}
+	}
+	tx, err := reader.db.Begin()
+	if err != nil {
+		return
+	}
+	defer tx.Rollback()
+	for i, id := range order {
+		if _, err = tx.Exec("UPDATE categories SET position = ? WHERE id = ? AND user_id = ?", i+1, id, reader.userId()); err != nil {
+			return
+		}
+	}
+	return tx.Commit()
+}
+
+// PositionCategory moves a category to a 1-based position in the sort order.
+func (reader *Reader) PositionCategory(id, position int) error {
+	categories, err := reader.GetCategories()
+	if err != nil {
+		return err
+	}
+	var order []int
+	for _, category := range categories {
+		if category.Id != id {
+			order = append(order, category.Id)
+		}
+	}
+	if len(order) == len(categories) {
+		return fmt.Errorf("category %d not found", id)
+	}
+	index := min(max(position-1, 0), len(order))
+	order = append(order[:index], append([]int{id}, order[index:]...)...)
+	return reader.ReorderCategories(order)
+}
+
+// MoveFeeds moves the user's subscriptions to a category.
+func (reader *Reader) MoveFeeds(feedIds []int, categoryId int) (moved int, err error) {
+	if err = reader.checkCategory(categoryId); err != nil || len(feedIds) == 0 {
+		return
+	}
+	res, err := reader.db.Exec(fmt.Sprintf(`
+		UPDATE subscriptions SET category_id = ? WHERE user_id = ? AND feed_id IN (%s)
+	`, joinIds(feedIds)), categoryId, reader.userId())
+	if err != nil {
+		return
+	}
+	n, err := res.RowsAffected()
+	return int(n), err
+}
+
+// checkCategory makes sure a category belongs to the user.
+func (reader *Reader) checkCategory(id int) error {
+	var found int
+	err := reader.db.QueryRow("SELECT id FROM categories WHERE id = ? AND user_id = ?", id, reader.userId()).Scan(&found)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("category %d not found", id)
+	}
+	return err
+}
+
+// findFeed returns the id of the shared feed with the given link.
+func (reader *Reader) findFeed(link string) (id int, err error) {
+	err = reader.db.QueryRow("SELECT id FROM feeds WHERE link = ? AND owner_id = 0 ORDER BY id LIMIT 1", link).Scan(&id)
 	return
 }
 
-// CreateFeed adds a new subscription to the database.
+// sanitizePosts sanitizes the content of posts stored before it was done on
+// fetch, once per database, tracked by its user_version.
+func sanitizePosts(db *sql.DB) (err error) {
+	var version int
+	if err = db.QueryRow("PRAGMA user_version").Scan(&version); err != nil || version >= 1 {
+		return
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return
+	}
+	defer tx.Rollback()
+	rows, err := tx.Query("SELECT id, content FROM posts WHERE content IS NOT NULL AND content != ''")
+	if err != nil {
+		return
+	}
+	contents := map[int]string{}
+	for rows.Next() {
+		var id int
+		var content string
+		if err = rows.Scan(&id, &content); err != nil {
+			rows.Close()
+			return
+		}
+		if clean := feed.SanitizeHTML(content); clean != content {
+			contents[id] = clean
+		}
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return
+	}
+	for id, content := range contents {
+		if _, err = tx.Exec("UPDATE posts SET content = ? WHERE id = ?", content, id); err != nil {
+			return
+		}
+	}
+	if _, err = tx.Exec("PRAGMA user_version = 1"); err != nil {
+		return
+	}
+	return tx.Commit()
+}
+
+// migrateFeedOwners rebuilds a feeds table whose links are unique, so that
+// users can keep a private copy of a feed next to the shared one. Shared
+// feeds that carry fetch options, which may hold credentials, are given to
+// their subscriber when there is only one.
+func migrateFeedOwners(db *sql.DB) (err error) {
+	if _, err := db.Exec("SELECT owner_id FROM feeds LIMIT 0"); err == nil {
+		return nil
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return
+	}
+	defer tx.Rollback()
+	statements := []string{
+		`CREATE TABLE feeds_owned (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			type TEXT,
+			name TEXT,
+			home TEXT,
+			link TEXT,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			refresh_interval INTEGER DEFAULT 0,
+			fetch_options TEXT,
+			fetched_at DATETIME,
+			fetch_error TEXT,
+			owner_id INTEGER NOT NULL DEFAULT 0,
+			UNIQUE (link, owner_id)
+		)`,
+		// Old databases may list a link more than once, only the first row stays shared.
+		`INSERT INTO feeds_owned (id, type, name, home, link, created_at, refresh_interval, fetch_options, fetched_at, fetch_error, owner_id)
+		SELECT id, type, name, home, link, created_at, refresh_interval, fetch_options, fetched_at, fetch_error,
+			CASE WHEN id = (SELECT MIN(id) FROM feeds d WHERE d.link = feeds.link) THEN 0 ELSE -id END
+		FROM feeds`,
+		"DROP TABLE feeds",
+		"ALTER TABLE feeds_owned RENAME TO feeds",
+		`UPDATE feeds SET owner_id = (SELECT user_id FROM subscriptions WHERE feed_id = feeds.id)
+		WHERE owner_id = 0 AND COALESCE(fetch_options, '') != ''
+			AND (SELECT COUNT(*) FROM subscriptions WHERE feed_id = feeds.id) = 1`,
+	}
+	for _, statement := range statements {
+		if _, err = tx.Exec(statement); err != nil {
+			return
+		}
+	}
+	return tx.Commit()
+}
+
+// CreateFeed subscribes the user to a feed, reusing the shared feed row
+// when another user already follows the same link.
 func (reader *Reader) CreateFeed(feedType, name, home, link string, category_id int) (id int, err error) {
-	err = reader.db.QueryRow(`
-		INSERT INTO feeds (type, name, home, link, category_id) VALUES (?, ?, ?, ?, ?) RETURNING id
-	`, feedType, name, home, link, category_id).Scan(&id)
+	if err = reader.checkCategory(category_id); err != nil {
+		return
+	}
+	id, err = reader.findFeed(link)
+	if err == sql.ErrNoRows {
+		err = reader.db.QueryRow(`
+			INSERT INTO feeds (type, name, home, link) VALUES (?, ?, ?, ?) RETURNING id
+		`, feedType, name, home, link).Scan(&id)
+	}
+	if err != nil {
+		return
+	}
+	_, err = reader.db.Exec(`
+		INSERT INTO subscriptions (user_id, feed_id, category_id, name) VALUES (?, ?, ?, ?)
+	`, reader.userId(), id, category_id, name)
 	return id, err
 }
 
+// SubscribeFeed subscribes to a feed URL in the given category. Feeds that
+// nobody follows yet are fetched to discover their title and first posts.
+func (reader *Reader) SubscribeFeed(link, title string, categoryId int) (id int, err error) {
+	if _, err = reader.findFeed(link); err == nil {
+		return reader.CreateFeed("", title, "", link, categoryId)
+	}
+	data, err := feed.FetchFeed(link)
+	if err != nil {
+		return
+	}
+	if title == "" {
+		title = data.Title
+	}
+	id, err = reader.CreateFeed(string(data.Type), title, data.Link, link, categoryId)
+	if err != nil {
+		return
+	}
+	go reader.updateFeedPosts(fmt.Sprint(id))
+	return
+}
+
 // CreatePost adds a new post to the database.
-func (reader *Reader) CreatePost(feedId, entryId, title, content, link string, pubDate time.Time) error {
+func (reader *Reader) CreatePost(feedId, entryId, title, content, link, author string, pubDate time.Time) error {
 	_, err := reader.db.Exec(`
-		INSERT INTO posts (entry_id, title, content, link, pub_date, feed_id) VALUES (?, ?, ?, ?, ?, ?)
-	`, entryId, title, content, link, pubDate, feedId)
+		INSERT INTO posts (entry_id, title, content, link, author, pub_date, feed_id) VALUES (?, ?, ?, ?, ?, ?, ?)
+	`, entryId, title, content, link, author, pubDate, feedId)
 	return err
 }
 
 // GetEntriesByCriteria retrieves entries (subscriptions or posts) based on the provided filter.
-func (reader *Reader) GetFeeds(conditions []string) (entries []*Feed, err error) {
+// Conditions may use ? placeholders, bound to args in order.
+func (reader *Reader) GetFeeds(conditions []string, args ...any) (entries []*Feed, err error) {
 	var filter string
 	conditions = append(conditions, "f.category_id = g.id")
 	if len(conditions) > 0 {
 		filter = strings.Join(conditions, " AND ")
 	}
 	rows, err := reader.db.Query(fmt.Sprintf(`
-		SELECT f.id, f.type, f.name, f.home, f.link, f.created_at, g.id, g.name
-		FROM feeds f, categories g
+		SELECT f.id, f.type, f.name, f.home, f.link, f.created_at, g.id, g.name,
+			f.refresh_interval, f.disabled, f.fetch_options, f.fetched_at, f.fetch_error, l.id, l.name
+		FROM %s f, %s g
+		LEFT JOIN feed_lists l ON l.id = f.list_id
 		WHERE  %s
-		ORDER BY f.created_at DESC`, filter))
+		ORDER BY f.created_at DESC`, reader.feedsTable(), reader.categoriesTable(), filter), args...)
 	if err != nil {
 		return
 	}
 	defer rows.Close()
 	for rows.Next() {
 		var feed Feed
+		var options, fetchError sql.NullString
+		var interval sql.NullInt64
+		var fetchedAt sql.NullTime
+		var listId sql.NullInt64
+		var listName sql.NullString
 		feed.Category = &Category{}
-		err := rows.Scan(&feed.Id, &feed.Type, &feed.Name, &feed.Home, &feed.Link, &feed.CreatedAt, &feed.Category.Id, &feed.Category.Name)
+		err := rows.Scan(&feed.Id, &feed.Type, &feed.Name, &feed.Home, &feed.Link, &feed.CreatedAt, &feed.Category.Id, &feed.Category.Name,
+			&interval, &feed.Disabled, &options, &fetchedAt, &fetchError, &listId, &listName)
 		if err != nil {
 			return nil, err
 		}
+		if listId.Valid {
+			feed.List = &FeedList{Id: int(listId.Int64), Name: listName.String}
+		}
+		feed.RefreshInterval = int(interval.Int64)
+		feed.FetchError = fetchError.String
+		if fetchedAt.Valid {
+			feed.FetchedAt = &fetchedAt.Time
+		}
+		if feed.FetchOptions = parseFetchOptions(options.String); feed.FetchOptions != nil {
+			feed.FetchOptions.Password = ""
+		}
 		entries = append(entries, &feed)
 	}
 	return
@@ -193,7 +627,11 @@ func (reader *Reader) GetFeeds(conditions []string) (entries []*Feed, err error)
 
 // GetFeed retrieves a specific subscription from the database.
 func (reader *Reader) GetFeed(id string) (feed *Feed, err error) {
-	entries, err := reader.GetFeeds([]string{fmt.Sprintf("f.id = %s", id)})
+	feedId, err := strconv.Atoi(id)
+	if err != nil {
+		return nil, fmt.Errorf("invalid feed id: %q", id)
+	}
+	entries, err := reader.GetFeeds([]string{fmt.Sprintf("f.id = %d", feedId)})
 	if err != nil {
 		return
 	}
@@ -205,57 +643,307 @@ func (reader *Reader) GetFeed(id string) (feed *Feed, err error) {
 	return
 }
 
+// FeedUpdate lists the subscription fields to change, nil fields are left as they are.
+type FeedUpdate struct {
+	Type            *string            `json:"type"`
+	Name            *string            `json:"name"`
+	Home            *string            `json:"home"`
+	Link            *string            `json:"link"`
+	CategoryId      *int               `json:"category_id"`
+	RefreshInterval *int               `json:"refresh_interval"`
+	Disabled        *bool              `json:"disabled"`
+	FetchOptions    *feed.FetchOptions `json:"fetch_options"`
+}
+
+func parseFetchOptions(str string) *feed.FetchOptions {
+	if str == "" {
+		return nil
+	}
+	var options feed.FetchOptions
+	if err := json.Unmarshal([]byte(str), &options); err != nil {
+		log.Println("Invalid fetch options:", err)
+		return nil
+	}
+	return &options
+}
+
+// feedFetchOptions returns the stored fetch options including the password.
+func (reader *Reader) feedFetchOptions(id string) (link string, options *feed.FetchOptions, err error) {
+	var str sql.NullString
+	err = reader.db.QueryRow("SELECT link, fetch_options FROM feeds WHERE id = ?", id).Scan(&link, &str)
+	return link, parseFetchOptions(str.String), err
+}
+
+// userFetchOptions returns the stored fetch options, including the password,
+// of the user's own copy of a feed. Shared feeds have none for the user.
+func (reader *Reader) userFetchOptions(id string) (*feed.FetchOptions, error) {
+	var str sql.NullString
+	err := reader.db.QueryRow("SELECT fetch_options FROM feeds WHERE id = ? AND owner_id = ?", id, reader.userId()).Scan(&str)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return parseFetchOptions(str.String), err
+}
+
+// UpdateFeed changes a subscription. Name, category and the disabled flag
+// belong to the user. The type, home page, refresh interval and fetch options
+// belong to the feed, and changing them gives the user a private copy of it:
+// the feed itself when nobody else follows it, a new one otherwise. A new
+// link or new fetch options are fetched first and only saved when that works.
+// A new link or a new copy moves the subscription, so the returned id may
+// differ from the given one.
+func (reader *Reader) UpdateFeed(id string, update FeedUpdate) (newId string, err error) {
+	newId = id
+	current, err := reader.GetFeed(id)
+	if err != nil {
+		return
+	}
+	if update.CategoryId != nil {
+		if err = reader.checkCategory(*update.CategoryId); err != nil {
+			return
+		}
+	}
+	if update.RefreshInterval != nil && *update.RefreshInterval < 0 {
+		return id, fmt.Errorf("%w: refresh interval cannot be negative", ErrInvalidFeed)
+	}
+	options, err := reader.userFetchOptions(id)
+	if err != nil {
+		return
+	}
+	if update.FetchOptions != nil {
+		next := *update.FetchOptions
+		// An empty password keeps the stored one for the same username.
+		if next.Username != "" && next.Password == "" && options != nil && options.Username == next.Username {
+			next.Password = options.Password
+		}
+		if next.Timeout < 0 {
+			return id, fmt.Errorf("%w: timeout cannot be negative", ErrInvalidFeed)
+		}
+		next.Timeout = min(next.Timeout, feed.MaxTimeout)
+		options = &next
+	}
+	link := current.Link
+	if update.Link != nil {
+		link = strings.TrimSpace(*update.Link)
+	}
+	var fetched *feed.Feed
+	if link != current.Link || update.FetchOptions != nil {
+		if u, err := url.Parse(link); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return id, fmt.Errorf("%w: %q is not an http(s) URL", ErrInvalidFeed, link)
+		}
+		if fetched, err = feed.FetchFeedWith(link, options); err != nil {
+			return id, fmt.Errorf("%w: %v", ErrInvalidFeed, err)
+		}
+	}
+	var owner, subscribers int
+	if err = reader.db.QueryRow(`
+		SELECT owner_id, (SELECT COUNT(*) FROM subscriptions WHERE feed_id = feeds.id) FROM feeds WHERE id = ?
+	`, id).Scan(&owner, &subscribers); err != nil {
+		return
+	}
+	settings := update.FetchOptions != nil ||
+		(update.Type != nil && *update.Type != current.Type) ||
+		(update.Home != nil && *update.Home != current.Home) ||
+		(update.RefreshInterval != nil && *update.RefreshInterval != current.RefreshInterval)
+	// private is the owner of the feed row the subscription ends up on.
+	private, claim := owner, false
+	if settings && owner != reader.userId() {
+		private, claim = reader.userId(), subscribers == 1 && link == current.Link
+	}
+	tx, err := reader.db.Begin()
+	if err != nil {
+		return
+	}
+	defer tx.Rollback()
+	if claim {
+		if _, err = tx.Exec("UPDATE feeds SET owner_id = ? WHERE id = ?", private, id); err != nil {
+			return
+		}
+	} else if link != current.Link || private != owner {
+		var linkId int
+		err = tx.QueryRow("SELECT id FROM feeds WHERE link = ? AND owner_id = ? ORDER BY id LIMIT 1", link, private).Scan(&linkId)
+		if err == sql.ErrNoRows {
+			// A private copy keeps the settings, a shared feed starts without any.
+			var feedType, title, home any
+			if fetched != nil {
+				feedType, title, home = string(fetched.Type), fetched.Title, fetched.Link
+			}
+			var interval int
+			var stored sql.NullString
+			if private != 0 {
+				interval = current.RefreshInterval
+				if options != nil {
+					data, _ := json.Marshal(options)
+					stored = sql.NullString{String: string(data), Valid: true}
+				}
+			}
+			err = tx.QueryRow(`
+				INSERT INTO feeds (type, name, home, link, refresh_interval, fetch_options, owner_id)
+				SELECT COALESCE(?, type), COALESCE(?, name), COALESCE(?, home), ?, ?, ?, ? FROM feeds WHERE id = ? RETURNING id
+			`, feedType, title, home, link, interval, stored, private, id).Scan(&linkId)
+		}
+		if err != nil {
+			return
+		}
+		if _, err = tx.Exec("UPDATE subscriptions SET feed_id = ? WHERE feed_id = ? AND user_id = ?", linkId, id, reader.userId()); err != nil {
+			return
+		}
+		newId = strconv.Itoa(linkId)
+	}
+	var subscription, shared []string
+	var subscriptionArgs, sharedArgs []any
+	if update.Name != nil {
+		subscription, subscriptionArgs = append(subscription, "name = ?"), append(subscriptionArgs, *update.Name)
+	}
+	if update.CategoryId != nil {
+		subscription, subscriptionArgs = append(subscription, "category_id = ?"), append(subscriptionArgs, *update.CategoryId)
+	}
+	if update.Disabled != nil {
+		// A feed resumed by hand is no longer the list's to resume, the
+		// right side of SET sees the old disabled value.
+		subscription = append(subscription, "disabled = ?", "disabled_by_list = (disabled_by_list AND disabled = ?)")
+		subscriptionArgs = append(subscriptionArgs, *update.Disabled, *update.Disabled)
+	}
+	if update.Type != nil {
+		shared, sharedArgs = append(shared, "type = ?"), append(sharedArgs, *update.Type)
+	}
+	if update.Home != nil {
+		shared, sharedArgs = append(shared, "home = ?"), append(sharedArgs, *update.Home)
+	}
+	if update.RefreshInterval != nil {
+		shared, sharedArgs = append(shared, "refresh_interval = ?"), append(sharedArgs, *update.RefreshInterval)
+	}
+	if update.FetchOptions != nil {
+		data, err := json.Marshal(options)
+		if err != nil {
+			return id, err
+		}
+		shared, sharedArgs = append(shared, "fetch_options = ?"), append(sharedArgs, string(data))
+	}
+	if len(subscription) > 0 {
+		query := fmt.Sprintf("UPDATE subscriptions SET %s WHERE feed_id = ? AND user_id = ?", strings.Join(subscription, ", "))
+		if _, err = tx.Exec(query, append(subscriptionArgs, newId, reader.userId())...); err != nil {
+			return
+		}
+	}
+	// Feeds shared with other users are never changed on behalf of one of them.
+	if len(shared) > 0 && private == reader.userId() {
+		query := fmt.Sprintf("UPDATE feeds SET %s WHERE id = ? AND owner_id = ?", strings.Join(shared, ", "))
+		if _, err = tx.Exec(query, append(sharedArgs, newId, private)...); err != nil {
+			return
+		}
+	}
+	if err = tx.Commit(); err != nil {
+		return
+	}
+	if newId != id {
+		reader.pruneFeed(id)
+		go reader.updateFeedPosts(newId)
+	}
+	return
+}
+
+// DeleteFeed unsubscribes the user from a feed.
 func (reader *Reader) DeleteFeed(id string) (err error) {
-	_, err = reader.db.Exec("DELETE FROM feeds WHERE id = ?", id)
+	_, err = reader.db.Exec("DELETE FROM subscriptions WHERE feed_id = ? AND user_id = ?", id, reader.userId())
+	if err != nil {
+		return
+	}
+	reader.db.Exec(`
+		DELETE FROM post_states WHERE user_id = ? AND post_id IN (SELECT id FROM posts WHERE feed_id = ?)
+	`, reader.userId(), id)
+	return reader.pruneFeed(id)
+}
+
+// pruneFeed removes a shared feed and its posts once nobody subscribes to it.
+func (reader *Reader) pruneFeed(id string) (err error) {
+	var subscribers int
+	if err = reader.db.QueryRow("SELECT COUNT(*) FROM subscriptions WHERE feed_id = ?", id).Scan(&subscribers); err != nil || subscribers > 0 {
+		return
+	}
+	statements := []string{
+		"DELETE FROM post_states WHERE post_id IN (SELECT id FROM posts WHERE feed_id = ?)",
+		"DELETE FROM posts WHERE feed_id = ?",
+		"DELETE FROM feeds WHERE id = ?",
+	}
+	for _, statement := range statements {
+		if _, err = reader.db.Exec(statement, id); err != nil {
+			return
+		}
+	}
 	return
 }
 
-func (reader *Reader) GetPosts(conditions []string, limit *Pagination) (posts []Post, err error) {
+// GetPosts returns the user's posts matching the conditions, whose ?
+// placeholders are bound to args in order.
+func (reader *Reader) GetPosts(conditions []string, limit *Pagination, args ...any) (posts []Post, err error) {
 	conditions = append(conditions, "p.feed_id = s.id")
 	conditions = append(conditions, "s.category_id = g.id")
-	sql := `SELECT p.id, p.title, p.content, p.link, p.is_read, p.is_saved, p.pub_date, p.created_at, 
-                s.id, s.name, s.home, g.id, g.name 
-                FROM posts p, feeds s, categories g`
+	sql := `SELECT p.id, p.title, p.content, p.link, COALESCE(p.author, ''), p.is_read, p.is_saved, p.pub_date, p.created_at, p.updated_at,
+                s.id, s.name, s.home, s.link, g.id, g.name 
+                FROM ` + reader.postsFrom()
 
 	whereClause := ""
 	if len(conditions) > 0 {
 		whereClause = fmt.Sprintf(" WHERE %s", strings.Join(conditions, " AND "))
 	}
 
-	sql = fmt.Sprintf("%s %s ORDER BY p.pub_date DESC", sql, whereClause)
+	order := "p.pub_date DESC"
+	if limit != nil && limit.Order != "" {
+		order = limit.Order
+	}
+	sql = fmt.Sprintf("%s %s ORDER BY %s", sql, whereClause, order)
 	if limit != nil {
 		sql = sql + limit.SQL()
-		err = reader.db.QueryRow(fmt.Sprintf("SELECT COUNT(*) FROM posts p, feeds s, categories g %s", whereClause)).Scan(&limit.Total)
+		err = reader.db.QueryRow(fmt.Sprintf("SELECT COUNT(*) FROM %s %s", reader.postsFrom(), whereClause), args...).Scan(&limit.Total)
 		if err != nil {
 			return
 		}
 	}
-	rows, err := reader.db.Query(sql)
+	rows, err := reader.db.Query(sql, args...)
 	if err != nil {
 		return
 	}
 	defer rows.Close()
 	for rows.Next() {
 		var post Post
+		var updated *time.Time
 		post.Feed = Feed{}
 		post.Feed.Category = &Category{}
 		err = rows.Scan(
-			&post.Id, &post.Title, &post.Content, &post.Link,
+			&post.Id, &post.Title, &post.Content, &post.Link, &post.Author,
 			&post.IsRead, &post.IsSaved,
-			&post.PubDate, &post.CreatedAt,
-			&post.Feed.Id, &post.Feed.Name, &post.Feed.Home,
+			&post.PubDate, &post.CreatedAt, &updated,
+			&post.Feed.Id, &post.Feed.Name, &post.Feed.Home, &post.Feed.Link,
 			&post.Feed.Category.Id, &post.Feed.Category.Name)
 		if err != nil {
 			return
 		}
+		post.UpdatedAt = post.CreatedAt
+		if updated != nil && updated.After(post.CreatedAt) {
+			post.UpdatedAt = *updated
+		}
 		posts = append(posts, post)
 	}
 	return
 }
 
+// joinIds formats ids for an SQL IN list.
+func joinIds(ids []int) string {
+	values := make([]string, len(ids))
+	for i, id := range ids {
+		values[i] = strconv.Itoa(id)
+	}
+	return strings.Join(values, ",")
+}
+
 // GetPost retrieves a specific post from the database.
 func (reader *Reader) GetPost(id string) (post Post, err error) {
-	posts, err := reader.GetPosts([]string{fmt.Sprintf("p.id = %s", id)}, nil)
+	postId, err := strconv.Atoi(id)
+	if err != nil {
+		return post, fmt.Errorf("invalid post id: %q", id)
+	}
+	posts, err := reader.GetPosts([]string{fmt.Sprintf("p.id = %d", postId)}, nil)
 	if err != nil {
 		return
 	}
@@ -271,73 +959,216 @@ func (reader *Reader) GetPostsByFeedId(id string, limit *Pagination) ([]Post, er
 	return reader.GetPosts([]string{fmt.Sprintf("p.feed_id = %s", id)}, limit)
 }
 
+// CountPosts returns the number of posts matching GetPosts style conditions.
+func (reader *Reader) CountPosts(conditions []string, args ...any) (count int, err error) {
+	conditions = append(conditions, "p.feed_id = s.id", "s.category_id = g.id")
+	err = reader.db.QueryRow(fmt.Sprintf(
+		"SELECT COUNT(*) FROM %s WHERE %s", reader.postsFrom(), strings.Join(conditions, " AND "),
+	), args...).Scan(&count)
+	return
+}
+
+// PostIds returns the ids of posts matching GetPosts style conditions.
+func (reader *Reader) PostIds(conditions []string, args ...any) (ids []int, err error) {
+	conditions = append(conditions, "p.feed_id = s.id", "s.category_id = g.id")
+	rows, err := reader.db.Query(fmt.Sprintf(
+		"SELECT p.id FROM %s WHERE %s ORDER BY p.id", reader.postsFrom(), strings.Join(conditions, " AND "),
+	), args...)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id int
+		if err = rows.Scan(&id); err != nil {
+			return
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// UnreadCounts returns the number of unread posts keyed by feed id.
+func (reader *Reader) UnreadCounts() (counts map[int]int, err error) {
+	rows, err := reader.db.Query(fmt.Sprintf(`
+		SELECT p.feed_id, COUNT(*) FROM %s WHERE p.is_read = 0 AND p.feed_id = s.id AND s.category_id = g.id GROUP BY p.feed_id
+	`, reader.postsFrom()))
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	counts = make(map[int]int)
+	for rows.Next() {
+		var feedId, count int
+		if err = rows.Scan(&feedId, &count); err != nil {
+			return
+		}
+		counts[feedId] = count
+	}
+	return counts, rows.Err()
+}
+
+// NewestPostId returns the highest post id, or 0 when there are no posts.
+func (reader *Reader) NewestPostId() (id int, err error) {
+	err = reader.db.QueryRow(fmt.Sprintf(
+		"SELECT COALESCE(MAX(p.id), 0) FROM %s WHERE p.feed_id = s.id AND s.category_id = g.id", reader.postsFrom(),
+	)).Scan(&id)
+	return
+}
+
 func (reader *Reader) UpdatePost(id string, updates []string) error {
-	sql := fmt.Sprintf(`UPDATE posts SET %s WHERE id = ?`, strings.Join(updates, ", "))
-	_, err := reader.db.Exec(sql, id)
-	return err
+	postId, err := strconv.Atoi(id)
+	if err != nil {
+		return fmt.Errorf("invalid post id: %q", id)
+	}
+	return reader.UpdatePosts([]string{fmt.Sprintf("p.id = %d", postId)}, updates)
+}
+
+// UpdatePosts applies updates such as "is_read = 1" to the user's state of
+// every post matching the GetPosts style conditions.
+func (reader *Reader) UpdatePosts(conditions []string, updates []string, args ...any) error {
+	postIds, err := reader.PostIds(conditions, args...)
+	if err != nil || len(postIds) == 0 {
+		return err
+	}
+	ids := joinIds(postIds)
+	tx, err := reader.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err = tx.Exec(fmt.Sprintf(`
+		INSERT OR IGNORE INTO post_states (user_id, post_id) SELECT %d, id FROM posts WHERE id IN (%s)
+	`, reader.userId(), ids)); err != nil {
+		return err
+	}
+	if _, err = tx.Exec(fmt.Sprintf(`
+		UPDATE post_states SET %s, updated_at = CURRENT_TIMESTAMP WHERE user_id = %d AND post_id IN (%s)
+	`, strings.Join(updates, ", "), reader.userId(), ids)); err != nil {
+		return err
+	}
+	if err = tx.Commit(); err != nil {
+		return err
+	}
+	reader.events.Publish(Event{Type: EventPostUpdated, Users: []int{reader.userId()}, Data: H{
+		"ids": postIds, "changes": postChanges(updates),
+	}})
+	return nil
 }
 
 // updateFeedPosts fetches new articles for a subscription and saves them to the database.
 func (reader *Reader) updateFeedPosts(feedId string) (err error) {
-	subscription, err := reader.GetFeed(feedId)
+	link, options, err := reader.feedFetchOptions(feedId)
 	if err != nil {
 		return
 	}
 
-	log.Println("Updating posts for subscription", subscription.Id, subscription.Link)
+	log.Println("Updating posts for feed", feedId, link)
 
 	// Use the new FetchFeed function which automatically detects feed type
-	feedData, err := feed.FetchFeed(subscription.Link)
+	feedData, err := feed.FetchFeedWith(link, options)
+	return reader.saveFetch(feedId, feedData, err)
+}
+
+// saveFetch records the outcome of fetching a feed and stores its new posts.
+func (reader *Reader) saveFetch(feedId string, feedData *feed.Feed, err error) error {
+	var fetchError any
+	if err != nil {
+		fetchError = err.Error()
+	}
+	fetchedAt := time.Now().UTC()
+	reader.db.Exec("UPDATE feeds SET fetched_at = ?, fetch_error = ? WHERE id = ?", fetchedAt, fetchError, feedId)
+	id, _ := strconv.Atoi(feedId)
+	reader.publishFeed(feedId, EventFeedStatus, H{"feed_id": id, "fetched_at": fetchedAt, "error": fetchError})
 	if err != nil {
 		return err
 	}
 
 	// Process all feed items
+	created := 0
 	for _, item := range feedData.Items {
-		// Create post in database
-		reader.CreatePost(
+		// Create post in database, with HTML that is safe to embed in pages
+		err := reader.CreatePost(
 			feedId,
 			item.ID,
 			item.Title,
-			item.Description,
+			feed.SanitizeHTML(item.Description),
 			item.Link,
+			item.Author,
 			item.PubDate,
 		)
+		if err == nil {
+			created++
+		}
+	}
+	if created > 0 {
+		reader.publishFeed(feedId, EventPostCreated, H{"feed_id": id, "count": created})
 	}
-
 	return nil
 }
 
-// updatePostsPeriodically periodically updates posts for all subscriptions.
-func (reader *Reader) updatePostsPeriodically() {
-	for range reader.tick.C {
-		// Get all subscriptions and update posts for each
-		subscriptions, err := reader.GetFeeds(nil)
-		if err != nil {
-			log.Println("Error getting subscriptions:", err)
-			continue
-		}
-
-		for _, subscription := range subscriptions {
-			// Assuming you have a function to fetch and save posts for each subscription
-			err := reader.updateFeedPosts(fmt.Sprint(subscription.Id))
-			if err != nil {
-				log.Printf("Error updating posts for subscription %d: %v\n", subscription.Id, err)
-			}
+// subscribedFeedIds lists every feed with an enabled subscription, regardless
+// of user. With dueOnly it skips feeds fetched within their refresh interval.
+func (reader *Reader) subscribedFeedIds(dueOnly bool) (ids []int, err error) {
+	query := "SELECT id FROM feeds WHERE id IN (SELECT feed_id FROM subscriptions WHERE disabled = 0)"
+	if dueOnly {
+		query += fmt.Sprintf(` AND (fetched_at IS NULL OR
+			CAST(strftime('%%s', 'now') AS INTEGER) - CAST(strftime('%%s', fetched_at) AS INTEGER)
+			>= COALESCE(NULLIF(refresh_interval, 0) * 60, %d))`, int(reader.config.RefreshDuration().Seconds()))
+	}
+	rows, err := reader.db.Query(query + " ORDER BY id")
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id int
+		if err = rows.Scan(&id); err != nil {
+			return
 		}
+		ids = append(ids, id)
 	}
+	return ids, rows.Err()
+}
+
+// RefreshFeeds fetches new posts for every subscribed feed, once per feed URL.
+func (reader *Reader) RefreshFeeds() {
+	reader.refreshFeeds(false)
 }
 
-func (reader *Reader) ImportOPML(data []byte) (err error) {
-	res, err := feed.ParseOPML(data)
+func (reader *Reader) refreshFeeds(dueOnly bool) {
+	ids, err := reader.subscribedFeedIds(dueOnly)
 	if err != nil {
+		log.Println("Error getting subscriptions:", err)
 		return
 	}
-	for _, outline := range res.Outlines {
-		_, err = reader.CreateFeed(outline.Type, outline.Text, outline.HTMLURL, outline.XMLURL, 1)
+
+	// A refresh started by a user reports its progress to that user only.
+	var users []int
+	if reader.user != nil {
+		users = []int{reader.userId()}
+	}
+	progress := func(done, failed int) {
+		reader.events.Publish(Event{Type: EventRefreshProgress, Users: users, Data: H{
+			"done": done, "total": len(ids), "failed": failed,
+		}})
+	}
+	failed := 0
+	progress(0, 0)
+	for i, id := range ids {
+		err := reader.updateFeedPosts(fmt.Sprint(id))
 		if err != nil {
-			return
+			failed++
+			log.Printf("Error updating posts for feed %d: %v\n", id, err)
 		}
+		progress(i+1, failed)
+	}
+}
+
+// updatePostsPeriodically periodically updates posts for subscriptions whose
+// refresh interval has passed.
+func (reader *Reader) updatePostsPeriodically() {
+	for range reader.tick.C {
+		reader.refreshFeeds(true)
 	}
-	return
 }
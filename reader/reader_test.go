@@ -0,0 +1,193 @@
+package reader
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"strconv"
+	"testing"
+
+	"github.com/lsongdev/feedreader/feed"
+)
+
+// newTestReader returns a reader backed by a fresh database in a temporary
+// directory, with the default admin user.
+func newTestReader(t *testing.T) *Reader {
+	t.Helper()
+	config := NewConfig()
+	config.Dir = t.TempDir()
+	reader, err := NewReader(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		reader.tick.Stop()
+		reader.db.Close()
+	})
+	return reader
+}
+
+// serveTestFeed serves an RSS feed and records the Authorization header of
+// the last request.
+func serveTestFeed(t *testing.T) (server *httptest.Server, auth *string) {
+	auth = new(string)
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*auth = r.Header.Get("Authorization")
+		w.Header().Set("Content-Type", "application/rss+xml")
+		fmt.Fprint(w, `<?xml version="1.0"?><rss version="2.0"><channel><title>Test</title><link>https://example.com/</link></channel></rss>`)
+	}))
+	t.Cleanup(server.Close)
+	return
+}
+
+func TestUpdateFeedKeepsSettingsPrivate(t *testing.T) {
+	reader := newTestReader(t)
+	server, auth := serveTestFeed(t)
+	bob, err := reader.ProvisionUser("bob", "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	admin, other := reader.As(&reader.config.Users[0]), reader.As(bob)
+	var ids []string
+	for _, user := range []*Reader{admin, other} {
+		categoryId, err := user.DefaultCategoryId()
+		if err != nil {
+			t.Fatal(err)
+		}
+		id, err := user.CreateFeed("rss", "Test", "", server.URL, categoryId)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, strconv.Itoa(id))
+	}
+	if ids[0] != ids[1] {
+		t.Fatalf("users do not share the feed: %v", ids)
+	}
+
+	interval := 5
+	newId, err := admin.UpdateFeed(ids[0], FeedUpdate{
+		RefreshInterval: &interval,
+		FetchOptions:    &feed.FetchOptions{Username: "admin", Password: "secret", Timeout: 3600},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if newId == ids[0] {
+		t.Fatal("settings were written to the shared feed")
+	}
+	if *auth == "" {
+		t.Error("the private copy was not fetched with the credentials")
+	}
+	mine, err := admin.GetFeed(newId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mine.RefreshInterval != 5 || mine.FetchOptions == nil || mine.FetchOptions.Timeout != feed.MaxTimeout {
+		t.Errorf("private copy: %+v %+v", mine, mine.FetchOptions)
+	}
+	theirs, err := other.GetFeed(ids[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if theirs.RefreshInterval != 0 || theirs.FetchOptions != nil {
+		t.Errorf("shared feed changed: %+v %+v", theirs, theirs.FetchOptions)
+	}
+	if _, err := other.GetFeed(newId); err == nil {
+		t.Error("the private copy is visible to another user")
+	}
+
+	// A feed the user alone follows is changed in place.
+	interval = 10
+	if id, err := other.UpdateFeed(ids[1], FeedUpdate{RefreshInterval: &interval}); err != nil || id != ids[1] {
+		t.Errorf("sole subscriber: %s %v, want %s", id, err, ids[1])
+	}
+	categoryId, _ := admin.DefaultCategoryId()
+	if id, err := admin.CreateFeed("rss", "Test", "", server.URL, categoryId); err != nil || strconv.Itoa(id) == ids[1] {
+		t.Errorf("subscribing again joined another user's feed: %d %v", id, err)
+	}
+}
+
+func TestMigrateFeedOwners(t *testing.T) {
+	config := NewConfig()
+	config.Dir = t.TempDir()
+	db, err := sql.Open("sqlite", path.Join(config.Dir, "reader.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, statement := range []string{
+		`CREATE TABLE feeds (id INTEGER PRIMARY KEY AUTOINCREMENT, type TEXT, name TEXT, home TEXT, link TEXT,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP, refresh_interval INTEGER DEFAULT 0, fetch_options TEXT,
+			fetched_at DATETIME, fetch_error TEXT, UNIQUE (link))`,
+		`INSERT INTO feeds (type, name, link, fetch_options) VALUES ('rss', 'Private', 'https://example.com/private', '{"username":"u","password":"p"}')`,
+		`INSERT INTO feeds (type, name, link) VALUES ('rss', 'Public', 'https://example.com/public')`,
+		`CREATE TABLE subscriptions (id INTEGER PRIMARY KEY AUTOINCREMENT, user_id INTEGER NOT NULL, feed_id INTEGER NOT NULL,
+			category_id INTEGER NOT NULL, name TEXT, created_at DATETIME DEFAULT CURRENT_TIMESTAMP, UNIQUE (user_id, feed_id))`,
+		`INSERT INTO subscriptions (user_id, feed_id, category_id) VALUES (1, 1, 1), (1, 2, 1)`,
+	} {
+		if _, err := db.Exec(statement); err != nil {
+			t.Fatal(err)
+		}
+	}
+	db.Close()
+	reader, err := NewReader(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		reader.tick.Stop()
+		reader.db.Close()
+	})
+	for link, want := range map[string]int{"https://example.com/private": 1, "https://example.com/public": 0} {
+		var owner int
+		if err := reader.db.QueryRow("SELECT owner_id FROM feeds WHERE link = ?", link).Scan(&owner); err != nil {
+			t.Fatal(err)
+		}
+		if owner != want {
+			t.Errorf("%s: owner %d, want %d", link, owner, want)
+		}
+	}
+}
+
+func TestFetchedPostsAreSanitized(t *testing.T) {
+	reader := newTestReader(t)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/rss+xml")
+		fmt.Fprint(w, `<?xml version="1.0"?><rss version="2.0"><channel><title>Test</title><link>https://example.com/</link>
+			<item><guid>1</guid><title>Hi</title><link>https://example.com/1</link>
+			<description><![CDATA[<p>Hi</p><img src=x onerror=alert(1)><script>alert(2)</script>]]></description></item>
+			</channel></rss>`)
+	}))
+	t.Cleanup(server.Close)
+	admin := reader.As(&reader.config.Users[0])
+	categoryId, err := admin.DefaultCategoryId()
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, err := admin.CreateFeed("rss", "Test", "", server.URL, categoryId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := admin.updateFeedPosts(strconv.Itoa(id)); err != nil {
+		t.Fatal(err)
+	}
+	var content string
+	if err := reader.db.QueryRow("SELECT content FROM posts WHERE feed_id = ?", id).Scan(&content); err != nil {
+		t.Fatal(err)
+	}
+	if content != `<p>Hi</p><img src="x">` {
+		t.Errorf("stored content: %q", content)
+	}
+
+	// Posts stored by earlier versions are sanitized once on startup.
+	reader.db.Exec("UPDATE posts SET content = '<b onclick=x()>old</b>'")
+	reader.db.Exec("PRAGMA user_version = 0")
+	if err := sanitizePosts(reader.db); err != nil {
+		t.Fatal(err)
+	}
+	reader.db.QueryRow("SELECT content FROM posts WHERE feed_id = ?", id).Scan(&content)
+	if content != "<b>old</b>" {
+		t.Errorf("migrated content: %q", content)
+	}
+}
@@ -0,0 +1,104 @@
+package reader
+
+import (
+	"net/http"
+	"strings"
+	"time"
+)
+
+// readItem is a post as sent to the reading view.
+type readItem struct {
+	Id       int       `json:"id"`
+	Title    string    `json:"title"`
+	Link     string    `json:"link"`
+	FeedId   int       `json:"feed_id"`
+	FeedName string    `json:"feed_name"`
+	IsRead   bool      `json:"is_read"`
+	IsSaved  bool      `json:"is_saved"`
+	PubDate  time.Time `json:"pub_date"`
+	Content  string    `json:"content,omitempty"`
+}
+
+func newReadItem(post Post) readItem {
+	return readItem{
+		Id: post.Id, Title: post.Title, Link: post.Link,
+		FeedId: post.Feed.Id, FeedName: post.Feed.Name,
+		IsRead: post.IsRead, IsSaved: post.IsSaved, PubDate: post.PubDate,
+	}
+}
+
+// wantsJson reports whether a web view was called by script expecting JSON.
+func wantsJson(r *http.Request) bool {
+	return strings.Contains(r.Header.Get("Accept"), "application/json")
+}
+
+// openPost returns a post being read, marking it read unless auto_mark_read
+// is off or the request comes with a token that may only read.
+func (reader *Reader) openPost(id string) (post Post, err error) {
+	post, err = reader.GetPost(id)
+	if err != nil || post.IsRead || reader.user == nil || reader.readOnly || !reader.config.MarkReadOnOpen() {
+		return
+	}
+	if err = reader.UpdatePost(id, []string{"is_read = 1"}); err != nil {
+		return
+	}
+	post.IsRead = true
+	return
+}
+
+// ReadView renders the keyboard driven reading view for the query's filters.
+func (reader *Reader) ReadView(w http.ResponseWriter, r *http.Request) {
+	reader = reader.forRequest(r)
+	if _, _, err := postConditions(r.URL.Query()); err != nil {
+		reader.Error(w, err)
+		return
+	}
+	reader.Render(w, "read", H{
+		"filter": filterURL(postFilterQuery(r.URL.Query())),
+	})
+}
+
+// ReadJson serves the reading view: a page of posts matching the filters,
+// or with ?id= a single post and its content.
+func (reader *Reader) ReadJson(w http.ResponseWriter, r *http.Request) {
+	reader = reader.forRequest(r)
+	query := r.URL.Query()
+	if query.Has("id") {
+		post, err := reader.openPost(query.Get("id"))
+		if err != nil {
+			reader.apiJson(w, http.StatusNotFound, H{"error": err.Error()})
+			return
+		}
+		item := newReadItem(post)
+		item.Content = post.Content
+		reader.apiJson(w, http.StatusOK, item)
+		return
+	}
+	conditions, args, err := postConditions(query)
+	if err != nil {
+		reader.apiJson(w, http.StatusBadRequest, H{"error": err.Error()})
+		return
+	}
+	limit := NewLimitFromQuery(query)
+	if limit.Page < 1 {
+		limit.Page = 1
+	}
+	if limit.Size < 1 || limit.Size > 100 {
+		limit.Size = 50
+	}
+	posts, err := reader.GetPosts(conditions, limit, args...)
+	if err != nil {
+		reader.apiJson(w, http.StatusInternalServerError, H{"error": err.Error()})
+		return
+	}
+	items := make([]readItem, len(posts))
+	for i, post := range posts {
+		items[i] = newReadItem(post)
+	}
+	reader.apiJson(w, http.StatusOK, H{
+		"posts":    items,
+		"page":     limit.Page,
+		"has_more": limit.HasMore(),
+		"total":    limit.Total,
+	})
+}
@@ -0,0 +1,962 @@
+package reader
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lsongdev/feedreader/feed"
+)
+
+//go:embed openapi.json
+var openapiDocument []byte
+
+const (
+	apiDefaultLimit = 50
+	apiMaxLimit     = 500
+)
+
+// APIError is the JSON error body returned by /api/v1.
+type APIError struct {
+	Status  int    `json:"-"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *APIError) Error() string {
+	return e.Message
+}
+
+func apiError(status int, code string, format string, args ...any) *APIError {
+	return &APIError{Status: status, Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+func apiNotFound(format string, args ...any) *APIError {
+	return apiError(http.StatusNotFound, "not_found", format, args...)
+}
+
+func apiBadRequest(format string, args ...any) *APIError {
+	return apiError(http.StatusBadRequest, "bad_request", format, args...)
+}
+
+// APIPost is the JSON representation of a post.
+type APIPost struct {
+	Id        int       `json:"id"`
+	Title     string    `json:"title"`
+	Content   string    `json:"content,omitempty"`
+	Link      string    `json:"link"`
+	Author    string    `json:"author,omitempty"`
+	IsRead    bool      `json:"is_read"`
+	IsSaved   bool      `json:"is_saved"`
+	PubDate   time.Time `json:"pub_date"`
+	CreatedAt time.Time `json:"created_at"`
+	Feed      APIRef    `json:"feed"`
+	Category  APIRef    `json:"category"`
+}
+
+type APIRef struct {
+	Id   int    `json:"id"`
+	Name string `json:"name"`
+}
+
+// APIPage wraps a list response with its pagination cursor.
+type APIPage struct {
+	Data       any    `json:"data"`
+	NextCursor string `json:"next_cursor,omitempty"`
+	Total      int64  `json:"total"`
+}
+
+func newAPIPost(post Post, content bool) APIPost {
+	out := APIPost{
+		Id:        post.Id,
+		Title:     post.Title,
+		Link:      post.Link,
+		Author:    post.Author,
+		IsRead:    post.IsRead,
+		IsSaved:   post.IsSaved,
+		PubDate:   post.PubDate,
+		CreatedAt: post.CreatedAt,
+		Feed:      APIRef{Id: post.Feed.Id, Name: post.Feed.Name},
+		Category:  APIRef{Id: post.Feed.Category.Id, Name: post.Feed.Category.Name},
+	}
+	if content {
+		out.Content = post.Content
+	}
+	return out
+}
+
+// apiHandlerFunc is an /api/v1 endpoint that reports failures by returning an error.
+// apiHandlerFunc receives a reader scoped to the authenticated user.
+type apiHandlerFunc func(reader *Reader, w http.ResponseWriter, r *http.Request) error
+
+func (reader *Reader) apiJson(w http.ResponseWriter, status int, data any) error {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	return json.NewEncoder(w).Encode(data)
+}
+
+// apiAuthorize checks Bearer tokens against the required scope and returns
+// the token's owner. Users authenticated with a session or Basic Auth are
+// granted every scope.
+func (reader *Reader) apiAuthorize(w http.ResponseWriter, r *http.Request, scope string) (*User, error) {
+	if secret, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		token := reader.AuthenticateToken(strings.TrimSpace(secret))
+		if token == nil {
+			w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+			return nil, apiError(http.StatusUnauthorized, "unauthorized", "invalid or revoked token")
+		}
+		if !token.Allows(scope) {
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer error="insufficient_scope", scope=%q`, scope))
+			return nil, apiError(http.StatusForbidden, "insufficient_scope", "token %q lacks the %s scope", token.Name, scope)
+		}
+		user := reader.FindUser(token.Username)
+		if user == nil {
+			return nil, apiError(http.StatusUnauthorized, "unauthorized", "token owner %q no longer exists", token.Username)
+		}
+		return user, nil
+	}
+	user := reader.CurrentUser(r)
+	if user == nil {
+		w.Header().Set("WWW-Authenticate", "Basic realm=Restricted")
+		return nil, apiError(http.StatusUnauthorized, "unauthorized", "authentication required")
+	}
+	return user, nil
+}
+
+// api wraps an endpoint with authentication, metrics and JSON error handling.
+// An empty scope makes the endpoint public.
+func (reader *Reader) api(name string, scope string, handler apiHandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		var user *User
+		var err error
+		if scope != "" {
+			user, err = reader.apiAuthorize(w, r, scope)
+		}
+		if err == nil {
+			err = handler(reader.As(user), w, r)
+		}
+		reader.metrics.Observe("api."+name, time.Since(start), err != nil)
+		if err == nil {
+			return
+		}
+		var apiErr *APIError
+		if !errors.As(err, &apiErr) {
+			log.Printf("api %s %s: %v", r.Method, r.URL.Path, err)
+			apiErr = apiError(http.StatusInternalServerError, "internal", "%v", err)
+		}
+		reader.apiJson(w, apiErr.Status, H{"error": apiErr})
+	}
+}
+
+// APIHandler returns the versioned JSON API mounted at /api/v1/.
+func (reader *Reader) APIHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/v1/openapi.json", reader.api("openapi", "", (*Reader).apiOpenAPI))
+	mux.HandleFunc("GET /api/v1/categories", reader.api("categories.list", ScopeRead, (*Reader).apiListCategories))
+	mux.HandleFunc("POST /api/v1/categories", reader.api("categories.create", ScopeWrite, (*Reader).apiCreateCategory))
+	mux.HandleFunc("GET /api/v1/categories/{id}", reader.api("categories.get", ScopeRead, (*Reader).apiGetCategory))
+	mux.HandleFunc("PATCH /api/v1/categories/{id}", reader.api("categories.update", ScopeWrite, (*Reader).apiUpdateCategory))
+	mux.HandleFunc("DELETE /api/v1/categories/{id}", reader.api("categories.delete", ScopeWrite, (*Reader).apiDeleteCategory))
+	mux.HandleFunc("PUT /api/v1/categories/order", reader.api("categories.order", ScopeWrite, (*Reader).apiOrderCategories))
+	mux.HandleFunc("GET /api/v1/feeds", reader.api("feeds.list", ScopeRead, (*Reader).apiListFeeds))
+	mux.HandleFunc("POST /api/v1/feeds", reader.api("feeds.create", ScopeWrite, (*Reader).apiCreateFeed))
+	mux.HandleFunc("GET /api/v1/feeds/{id}", reader.api("feeds.get", ScopeRead, (*Reader).apiGetFeed))
+	mux.HandleFunc("PATCH /api/v1/feeds/{id}", reader.api("feeds.update", ScopeWrite, (*Reader).apiUpdateFeed))
+	mux.HandleFunc("POST /api/v1/feeds/move", reader.api("feeds.move", ScopeWrite, (*Reader).apiMoveFeeds))
+	mux.HandleFunc("DELETE /api/v1/feeds/{id}", reader.api("feeds.delete", ScopeWrite, (*Reader).apiDeleteFeed))
+	mux.HandleFunc("POST /api/v1/feeds/{id}/refresh", reader.api("feeds.refresh", ScopeWrite, (*Reader).apiRefreshFeed))
+	mux.HandleFunc("POST /api/v1/refresh", reader.api("refresh", ScopeWrite, (*Reader).apiRefresh))
+	mux.HandleFunc("GET /api/v1/posts", reader.api("posts.list", ScopeRead, (*Reader).apiListPosts))
+	mux.HandleFunc("GET /api/v1/posts/{id}", reader.api("posts.get", ScopeRead, (*Reader).apiGetPost))
+	mux.HandleFunc("PATCH /api/v1/posts/{id}", reader.api("posts.update", ScopeWrite, (*Reader).apiUpdatePost))
+	mux.HandleFunc("POST /api/v1/posts/bulk", reader.api("posts.bulk", ScopeWrite, (*Reader).apiBulkPosts))
+	mux.HandleFunc("GET /api/v1/opml", reader.api("opml.export", ScopeRead, (*Reader).apiExportOPML))
+	mux.HandleFunc("POST /api/v1/opml", reader.api("opml.import", ScopeWrite, (*Reader).apiImportOPML))
+	mux.HandleFunc("GET /api/v1/opml/imports/{id}", reader.api("opml.imports.get", ScopeRead, (*Reader).apiGetImport))
+	mux.HandleFunc("POST /api/v1/history", reader.api("history.import", ScopeWrite, (*Reader).apiImportHistory))
+	mux.HandleFunc("GET /api/v1/archive", reader.api("archive.export", ScopeRead, (*Reader).apiExportArchive))
+	mux.HandleFunc("POST /api/v1/archive", reader.api("archive.restore", ScopeWrite, (*Reader).apiRestoreArchive))
+	mux.HandleFunc("GET /api/v1/tokens", reader.api("tokens.list", ScopeAdmin, (*Reader).apiListTokens))
+	mux.HandleFunc("POST /api/v1/tokens", reader.api("tokens.create", ScopeAdmin, (*Reader).apiCreateToken))
+	mux.HandleFunc("DELETE /api/v1/tokens/{id}", reader.api("tokens.delete", ScopeAdmin, (*Reader).apiRevokeToken))
+	mux.HandleFunc("/api/v1/", reader.api("unknown", "", func(reader *Reader, w http.ResponseWriter, r *http.Request) error {
+		return apiNotFound("no such endpoint: %s %s", r.Method, r.URL.Path)
+	}))
+	return mux
+}
+
+func apiDecode(r *http.Request, v any) error {
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		return apiBadRequest("invalid JSON body: %v", err)
+	}
+	return nil
+}
+
+func apiPathId(r *http.Request) (int, error) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || id <= 0 {
+		return 0, apiBadRequest("invalid id: %q", r.PathValue("id"))
+	}
+	return id, nil
+}
+
+// apiQueryInt parses an optional positive integer query parameter.
+func apiQueryInt(r *http.Request, name string) (int, error) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		return 0, apiBadRequest("invalid %s: %q", name, value)
+	}
+	return n, nil
+}
+
+// apiQueryBool parses an optional true/false query parameter.
+func apiQueryBool(r *http.Request, name string) (*bool, error) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return nil, nil
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return nil, apiBadRequest("invalid %s: %q", name, value)
+	}
+	return &b, nil
+}
+
+func apiLimit(r *http.Request) (int, error) {
+	limit, err := apiQueryInt(r, "limit")
+	if err != nil {
+		return 0, err
+	}
+	if limit == 0 {
+		limit = apiDefaultLimit
+	}
+	if limit > apiMaxLimit {
+		limit = apiMaxLimit
+	}
+	return limit, nil
+}
+
+// likeEscaper escapes the LIKE wildcards of a search, for use with ESCAPE '\'.
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// likePattern returns a LIKE pattern matching text that contains str.
+func likePattern(str string) string {
+	return "%" + likeEscaper.Replace(str) + "%"
+}
+
+func (reader *Reader) apiOpenAPI(w http.ResponseWriter, r *http.Request) error {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	_, err := w.Write(openapiDocument)
+	return err
+}
+
+func (reader *Reader) apiCategory(id int) (*Category, error) {
+	categories, err := reader.GetCategories()
+	if err != nil {
+		return nil, err
+	}
+	for _, category := range categories {
+		if category.Id == id {
+			return category, nil
+		}
+	}
+	return nil, apiNotFound("category %d not found", id)
+}
+
+func (reader *Reader) apiListCategories(w http.ResponseWriter, r *http.Request) error {
+	categories, err := reader.GetCategories()
+	if err != nil {
+		return err
+	}
+	if categories == nil {
+		categories = []*Category{}
+	}
+	return reader.apiJson(w, http.StatusOK, APIPage{Data: categories, Total: int64(len(categories))})
+}
+
+func (reader *Reader) apiCreateCategory(w http.ResponseWriter, r *http.Request) error {
+	var body struct {
+		Name string `json:"name"`
+	}
+	if err := apiDecode(r, &body); err != nil {
+		return err
+	}
+	if strings.TrimSpace(body.Name) == "" {
+		return apiBadRequest("name is required")
+	}
+	id, err := reader.CreateCategory(strings.TrimSpace(body.Name))
+	if err != nil {
+		return apiError(http.StatusConflict, "conflict", "cannot create category: %v", err)
+	}
+	category, err := reader.apiCategory(id)
+	if err != nil {
+		return err
+	}
+	return reader.apiJson(w, http.StatusCreated, category)
+}
+
+func (reader *Reader) apiGetCategory(w http.ResponseWriter, r *http.Request) error {
+	id, err := apiPathId(r)
+	if err != nil {
+		return err
+	}
+	category, err := reader.apiCategory(id)
+	if err != nil {
+		return err
+	}
+	return reader.apiJson(w, http.StatusOK, category)
+}
+
+func (reader *Reader) apiUpdateCategory(w http.ResponseWriter, r *http.Request) error {
+	id, err := apiPathId(r)
+	if err != nil {
+		return err
+	}
+	if _, err := reader.apiCategory(id); err != nil {
+		return err
+	}
+	var body struct {
+		Name     *string `json:"name"`
+		Position *int    `json:"position"`
+	}
+	if err := apiDecode(r, &body); err != nil {
+		return err
+	}
+	if body.Name == nil && body.Position == nil {
+		return apiBadRequest("name or position is required")
+	}
+	if body.Name != nil {
+		name := strings.TrimSpace(*body.Name)
+		if name == "" {
+			return apiBadRequest("name cannot be empty")
+		}
+		if err := reader.UpdateCategory(id, name); err != nil {
+			return apiError(http.StatusConflict, "conflict", "cannot rename category: %v", err)
+		}
+	}
+	if body.Position != nil {
+		if *body.Position < 1 {
+			return apiBadRequest("position must be at least 1")
+		}
+		if err := reader.PositionCategory(id, *body.Position); err != nil {
+			return err
+		}
+	}
+	category, err := reader.apiCategory(id)
+	if err != nil {
+		return err
+	}
+	return reader.apiJson(w, http.StatusOK, category)
+}
+
+// apiDeleteCategory moves the category's feeds to ?move_to=, or to the
+// default category, before deleting it.
+func (reader *Reader) apiDeleteCategory(w http.ResponseWriter, r *http.Request) error {
+	id, err := apiPathId(r)
+	if err != nil {
+		return err
+	}
+	defaultId, err := reader.DefaultCategoryId()
+	if err != nil {
+		return err
+	}
+	if id == defaultId {
+		return apiBadRequest("the default category cannot be deleted")
+	}
+	if _, err := reader.apiCategory(id); err != nil {
+		return err
+	}
+	moveTo, err := apiQueryInt(r, "move_to")
+	if err != nil {
+		return err
+	}
+	if moveTo == 0 {
+		moveTo = defaultId
+	}
+	if moveTo == id {
+		return apiBadRequest("move_to must be another category")
+	}
+	if _, err := reader.apiCategory(moveTo); err != nil {
+		return err
+	}
+	if err := reader.DeleteCategory(id, moveTo); err != nil {
+		return err
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// apiOrderCategories sets the sort order; categories left out keep their
+// relative order after the listed ones.
+func (reader *Reader) apiOrderCategories(w http.ResponseWriter, r *http.Request) error {
+	var body struct {
+		Ids []int `json:"ids"`
+	}
+	if err := apiDecode(r, &body); err != nil {
+		return err
+	}
+	for _, id := range body.Ids {
+		if _, err := reader.apiCategory(id); err != nil {
+			return err
+		}
+	}
+	if err := reader.ReorderCategories(body.Ids); err != nil {
+		return err
+	}
+	return reader.apiListCategories(w, r)
+}
+
+func (reader *Reader) apiMoveFeeds(w http.ResponseWriter, r *http.Request) error {
+	var body struct {
+		FeedIds    []int `json:"feed_ids"`
+		CategoryId int   `json:"category_id"`
+	}
+	if err := apiDecode(r, &body); err != nil {
+		return err
+	}
+	if len(body.FeedIds) == 0 {
+		return apiBadRequest("feed_ids is required")
+	}
+	if _, err := reader.apiCategory(body.CategoryId); err != nil {
+		return err
+	}
+	moved, err := reader.MoveFeeds(body.FeedIds, body.CategoryId)
+	if err != nil {
+		return err
+	}
+	return reader.apiJson(w, http.StatusOK, H{"moved": moved})
+}
+
+func (reader *Reader) apiFeed(id int) (*Feed, error) {
+	feeds, err := reader.GetFeeds([]string{fmt.Sprintf("f.id = %d", id)})
+	if err != nil {
+		return nil, err
+	}
+	if len(feeds) == 0 {
+		return nil, apiNotFound("feed %d not found", id)
+	}
+	return feeds[0], nil
+}
+
+// apiListFeeds lists feeds ordered by id, using the last id as the cursor.
+func (reader *Reader) apiListFeeds(w http.ResponseWriter, r *http.Request) error {
+	limit, err := apiLimit(r)
+	if err != nil {
+		return err
+	}
+	var conditions []string
+	var args []any
+	category, err := apiQueryInt(r, "category")
+	if err != nil {
+		return err
+	}
+	if category > 0 {
+		conditions = append(conditions, fmt.Sprintf("g.id = %d", category))
+	}
+	if q := r.URL.Query().Get("q"); q != "" {
+		conditions = append(conditions, `(f.name LIKE ? ESCAPE '\' OR f.link LIKE ? ESCAPE '\')`)
+		args = append(args, likePattern(q), likePattern(q))
+	}
+	feeds, err := reader.GetFeeds(conditions, args...)
+	if err != nil {
+		return err
+	}
+	total := int64(len(feeds))
+	after := 0
+	if cursor := r.URL.Query().Get("cursor"); cursor != "" {
+		if after, err = strconv.Atoi(cursor); err != nil {
+			return apiBadRequest("invalid cursor: %q", cursor)
+		}
+	}
+	page := make([]*Feed, 0, limit)
+	next := ""
+	sort.Slice(feeds, func(i, j int) bool { return feeds[i].Id < feeds[j].Id })
+	for _, feed := range feeds {
+		if feed.Id <= after {
+			continue
+		}
+		if len(page) == limit {
+			next = strconv.Itoa(page[len(page)-1].Id)
+			break
+		}
+		page = append(page, feed)
+	}
+	return reader.apiJson(w, http.StatusOK, APIPage{Data: page, NextCursor: next, Total: total})
+}
+
+func (reader *Reader) apiCreateFeed(w http.ResponseWriter, r *http.Request) error {
+	var body FeedUpdate
+	if err := apiDecode(r, &body); err != nil {
+		return err
+	}
+	if body.Link == nil || *body.Link == "" {
+		return apiBadRequest("link is required")
+	}
+	categoryId, err := reader.DefaultCategoryId()
+	if err != nil {
+		return err
+	}
+	if body.CategoryId != nil {
+		if _, err := reader.apiCategory(*body.CategoryId); err != nil {
+			return err
+		}
+		categoryId = *body.CategoryId
+	}
+	var id int
+	if body.Name != nil && body.Home != nil && body.Type != nil {
+		id, err = reader.CreateFeed(*body.Type, *body.Name, *body.Home, *body.Link, categoryId)
+		if err == nil {
+			go reader.updateFeedPosts(fmt.Sprint(id))
+		}
+	} else {
+		title := ""
+		if body.Name != nil {
+			title = *body.Name
+		}
+		id, err = reader.SubscribeFeed(*body.Link, title, categoryId)
+	}
+	if err != nil {
+		return apiError(http.StatusUnprocessableEntity, "unprocessable", "cannot subscribe: %v", err)
+	}
+	feed, err := reader.apiFeed(id)
+	if err != nil {
+		return err
+	}
+	return reader.apiJson(w, http.StatusCreated, feed)
+}
+
+func (reader *Reader) apiGetFeed(w http.ResponseWriter, r *http.Request) error {
+	id, err := apiPathId(r)
+	if err != nil {
+		return err
+	}
+	feed, err := reader.apiFeed(id)
+	if err != nil {
+		return err
+	}
+	return reader.apiJson(w, http.StatusOK, feed)
+}
+
+func (reader *Reader) apiUpdateFeed(w http.ResponseWriter, r *http.Request) error {
+	id, err := apiPathId(r)
+	if err != nil {
+		return err
+	}
+	if _, err := reader.apiFeed(id); err != nil {
+		return err
+	}
+	var body FeedUpdate
+	if err := apiDecode(r, &body); err != nil {
+		return err
+	}
+	if body.CategoryId != nil {
+		if _, err := reader.apiCategory(*body.CategoryId); err != nil {
+			return err
+		}
+	}
+	newId, err := reader.UpdateFeed(strconv.Itoa(id), body)
+	if errors.Is(err, ErrInvalidFeed) {
+		return apiError(http.StatusUnprocessableEntity, "unprocessable", "%v", err)
+	}
+	if err != nil {
+		return apiError(http.StatusConflict, "conflict", "cannot update feed: %v", err)
+	}
+	id, _ = strconv.Atoi(newId)
+	feed, err := reader.apiFeed(id)
+	if err != nil {
+		return err
+	}
+	return reader.apiJson(w, http.StatusOK, feed)
+}
+
+func (reader *Reader) apiDeleteFeed(w http.ResponseWriter, r *http.Request) error {
+	id, err := apiPathId(r)
+	if err != nil {
+		return err
+	}
+	if _, err := reader.apiFeed(id); err != nil {
+		return err
+	}
+	if err := reader.DeleteFeed(strconv.Itoa(id)); err != nil {
+		return err
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+func (reader *Reader) apiRefreshFeed(w http.ResponseWriter, r *http.Request) error {
+	id, err := apiPathId(r)
+	if err != nil {
+		return err
+	}
+	if _, err := reader.apiFeed(id); err != nil {
+		return err
+	}
+	if err := reader.updateFeedPosts(strconv.Itoa(id)); err != nil {
+		return apiError(http.StatusBadGateway, "fetch_failed", "cannot refresh feed: %v", err)
+	}
+	feed, err := reader.apiFeed(id)
+	if err != nil {
+		return err
+	}
+	return reader.apiJson(w, http.StatusOK, feed)
+}
+
+func (reader *Reader) apiRefresh(w http.ResponseWriter, r *http.Request) error {
+	go reader.RefreshFeeds()
+	return reader.apiJson(w, http.StatusAccepted, H{"status": "refreshing"})
+}
+
+// apiPostConditions builds GetPosts conditions and their arguments from the
+// shared post filters.
+func apiPostConditions(r *http.Request) (conditions []string, args []any, err error) {
+	feedId, err := apiQueryInt(r, "feed")
+	if err != nil {
+		return
+	}
+	if feedId > 0 {
+		conditions = append(conditions, fmt.Sprintf("p.feed_id = %d", feedId))
+	}
+	categoryId, err := apiQueryInt(r, "category")
+	if err != nil {
+		return
+	}
+	if categoryId > 0 {
+		conditions = append(conditions, fmt.Sprintf("g.id = %d", categoryId))
+	}
+	read, err := apiQueryBool(r, "read")
+	if err != nil {
+		return
+	}
+	if read != nil {
+		conditions = append(conditions, fmt.Sprintf("p.is_read = %d", b2i(*read)))
+	}
+	saved, err := apiQueryBool(r, "saved")
+	if err != nil {
+		return
+	}
+	if saved != nil {
+		conditions = append(conditions, fmt.Sprintf("p.is_saved = %d", b2i(*saved)))
+	}
+	if q := r.URL.Query().Get("q"); q != "" {
+		conditions = append(conditions, `(p.title LIKE ? ESCAPE '\' OR p.content LIKE ? ESCAPE '\')`)
+		args = append(args, likePattern(q), likePattern(q))
+	}
+	for name, op := range map[string]string{"since": ">=", "until": "<"} {
+		value := r.URL.Query().Get(name)
+		if value == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return nil, nil, apiBadRequest("invalid %s, expected RFC 3339: %q", name, value)
+		}
+		conditions = append(conditions, fmt.Sprintf("%s %s %d", postTimeKey, op, t.Unix()))
+	}
+	return
+}
+
+// postTimeKey orders posts by publication time independently of the stored time zone.
+const postTimeKey = "CAST(strftime('%s', p.pub_date) AS INTEGER)"
+
+// encodePostCursor encodes the position after the given post.
+func encodePostCursor(post Post) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf("%d:%d", post.PubDate.Unix(), post.Id)))
+}
+
+func decodePostCursor(cursor string) (condition string, err error) {
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return "", apiBadRequest("invalid cursor")
+	}
+	var ts int64
+	var id int
+	if _, err := fmt.Sscanf(string(data), "%d:%d", &ts, &id); err != nil {
+		return "", apiBadRequest("invalid cursor")
+	}
+	return fmt.Sprintf("(%s < %d OR (%s = %d AND p.id < %d))", postTimeKey, ts, postTimeKey, ts, id), nil
+}
+
+// apiListPosts lists posts newest first with keyset pagination.
+func (reader *Reader) apiListPosts(w http.ResponseWriter, r *http.Request) error {
+	limit, err := apiLimit(r)
+	if err != nil {
+		return err
+	}
+	conditions, args, err := apiPostConditions(r)
+	if err != nil {
+		return err
+	}
+	total, err := reader.CountPosts(conditions, args...)
+	if err != nil {
+		return err
+	}
+	if cursor := r.URL.Query().Get("cursor"); cursor != "" {
+		condition, err := decodePostCursor(cursor)
+		if err != nil {
+			return err
+		}
+		conditions = append(conditions, condition)
+	}
+	// fetch one extra post to know whether there is a next page
+	page := &Pagination{Page: 1, Size: limit + 1, Order: postTimeKey + " DESC, p.id DESC"}
+	posts, err := reader.GetPosts(conditions, page, args...)
+	if err != nil {
+		return err
+	}
+	next := ""
+	if len(posts) > limit {
+		posts = posts[:limit]
+		next = encodePostCursor(posts[limit-1])
+	}
+	content := r.URL.Query().Get("content") != "false"
+	data := make([]APIPost, 0, len(posts))
+	for _, post := range posts {
+		data = append(data, newAPIPost(post, content))
+	}
+	return reader.apiJson(w, http.StatusOK, APIPage{Data: data, NextCursor: next, Total: int64(total)})
+}
+
+func (reader *Reader) apiPost(id int) (post Post, err error) {
+	posts, err := reader.GetPosts([]string{fmt.Sprintf("p.id = %d", id)}, nil)
+	if err != nil {
+		return
+	}
+	if len(posts) == 0 {
+		return post, apiNotFound("post %d not found", id)
+	}
+	return posts[0], nil
+}
+
+func (reader *Reader) apiGetPost(w http.ResponseWriter, r *http.Request) error {
+	id, err := apiPathId(r)
+	if err != nil {
+		return err
+	}
+	post, err := reader.apiPost(id)
+	if err != nil {
+		return err
+	}
+	return reader.apiJson(w, http.StatusOK, newAPIPost(post, true))
+}
+
+// APIPostState is the request body for changing post flags.
+type APIPostState struct {
+	IsRead  *bool `json:"is_read"`
+	IsSaved *bool `json:"is_saved"`
+}
+
+func (state APIPostState) updates() (updates []string) {
+	if state.IsRead != nil {
+		updates = append(updates, fmt.Sprintf("is_read = %d", b2i(*state.IsRead)))
+	}
+	if state.IsSaved != nil {
+		updates = append(updates, fmt.Sprintf("is_saved = %d", b2i(*state.IsSaved)))
+	}
+	return
+}
+
+func (reader *Reader) apiUpdatePost(w http.ResponseWriter, r *http.Request) error {
+	id, err := apiPathId(r)
+	if err != nil {
+		return err
+	}
+	if _, err := reader.apiPost(id); err != nil {
+		return err
+	}
+	var body APIPostState
+	if err := apiDecode(r, &body); err != nil {
+		return err
+	}
+	if updates := body.updates(); len(updates) > 0 {
+		if err := reader.UpdatePost(strconv.Itoa(id), updates); err != nil {
+			return err
+		}
+	}
+	post, err := reader.apiPost(id)
+	if err != nil {
+		return err
+	}
+	return reader.apiJson(w, http.StatusOK, newAPIPost(post, true))
+}
+
+// apiBulkActions maps bulk action names to post updates.
+var apiBulkActions = map[string]string{
+	"read":   "is_read = 1",
+	"unread": "is_read = 0",
+	"save":   "is_saved = 1",
+	"unsave": "is_saved = 0",
+}
+
+// apiBulkPosts applies an action either to explicit ids or to every post
+// matching the same filters accepted by GET /api/v1/posts.
+func (reader *Reader) apiBulkPosts(w http.ResponseWriter, r *http.Request) error {
+	var body struct {
+		Action string `json:"action"`
+		Ids    []int  `json:"ids"`
+	}
+	if err := apiDecode(r, &body); err != nil {
+		return err
+	}
+	update, ok := apiBulkActions[body.Action]
+	if !ok {
+		return apiBadRequest("unknown action: %q", body.Action)
+	}
+	conditions, args, err := apiPostConditions(r)
+	if err != nil {
+		return err
+	}
+	if len(body.Ids) > 0 {
+		ids := make([]string, 0, len(body.Ids))
+		for _, id := range body.Ids {
+			ids = append(ids, strconv.Itoa(id))
+		}
+		conditions = append(conditions, fmt.Sprintf("p.id IN (%s)", strings.Join(ids, ",")))
+	} else if len(conditions) == 0 && r.URL.Query().Get("all") != "true" {
+		return apiBadRequest("ids or a filter is required, use all=true to update every post")
+	}
+	if err := reader.UpdatePosts(conditions, []string{update}, args...); err != nil {
+		return err
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+func (reader *Reader) apiExportOPML(w http.ResponseWriter, r *http.Request) error {
+	out, err := reader.ExportOPML()
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", "text/x-opml; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="feeds.opml"`)
+	return feed.WriteOPML(w, out)
+}
+
+// apiUpload reads an uploaded file, sent either as the "file" field of a
+// multipart form or as the request body.
+func apiUpload(r *http.Request) ([]byte, error) {
+	if !strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		return io.ReadAll(r.Body)
+	}
+	f, _, err := r.FormFile("file")
+	if err != nil {
+		return nil, apiBadRequest("missing file: %v", err)
+	}
+	defer f.Close()
+	return io.ReadAll(f)
+}
+
+func (reader *Reader) apiImportOPML(w http.ResponseWriter, r *http.Request) error {
+	data, err := apiUpload(r)
+	if err != nil {
+		return err
+	}
+	job, err := reader.ImportOPML(data)
+	if err != nil {
+		return apiError(http.StatusUnprocessableEntity, "unprocessable", "cannot import OPML: %v", err)
+	}
+	w.Header().Set("Location", "/api/v1/opml/imports/"+job.Id)
+	return reader.apiJson(w, http.StatusAccepted, job)
+}
+
+func (reader *Reader) apiImportHistory(w http.ResponseWriter, r *http.Request) error {
+	data, err := apiUpload(r)
+	if err != nil {
+		return err
+	}
+	report, err := reader.ImportHistory(data)
+	if err != nil {
+		return apiError(http.StatusUnprocessableEntity, "unprocessable", "cannot import history: %v", err)
+	}
+	return reader.apiJson(w, http.StatusOK, report)
+}
+
+func (reader *Reader) apiExportArchive(w http.ResponseWriter, r *http.Request) error {
+	var buf bytes.Buffer
+	if err := reader.ExportArchive(&buf); err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", reader.ArchiveName()))
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+func (reader *Reader) apiRestoreArchive(w http.ResponseWriter, r *http.Request) error {
+	data, err := apiUpload(r)
+	if err != nil {
+		return err
+	}
+	report, err := reader.RestoreArchive(data)
+	if err != nil {
+		return apiError(http.StatusUnprocessableEntity, "unprocessable", "cannot restore archive: %v", err)
+	}
+	return reader.apiJson(w, http.StatusOK, report)
+}
+
+func (reader *Reader) apiGetImport(w http.ResponseWriter, r *http.Request) error {
+	job := reader.ImportJob(r.PathValue("id"))
+	if job == nil {
+		return apiNotFound("import %q not found", r.PathValue("id"))
+	}
+	return reader.apiJson(w, http.StatusOK, job)
+}
+
+func (reader *Reader) apiListTokens(w http.ResponseWriter, r *http.Request) error {
+	tokens, err := reader.GetTokens()
+	if err != nil {
+		return err
+	}
+	if tokens == nil {
+		tokens = []*Token{}
+	}
+	return reader.apiJson(w, http.StatusOK, APIPage{Data: tokens, Total: int64(len(tokens))})
+}
+
+// apiCreateToken returns the new token together with its secret, which is
+// only shown once.
+func (reader *Reader) apiCreateToken(w http.ResponseWriter, r *http.Request) error {
+	var body struct {
+		Name   string   `json:"name"`
+		Scopes []string `json:"scopes"`
+	}
+	if err := apiDecode(r, &body); err != nil {
+		return err
+	}
+	scopes, err := ParseScopes(strings.Join(body.Scopes, ","))
+	if err != nil {
+		return apiBadRequest("%v", err)
+	}
+	secret, token, err := reader.CreateToken(reader.user.Username, body.Name, scopes)
+	if err != nil {
+		return apiError(http.StatusConflict, "conflict", "cannot create token: %v", err)
+	}
+	return reader.apiJson(w, http.StatusCreated, H{"token": token, "secret": secret})
+}
+
+func (reader *Reader) apiRevokeToken(w http.ResponseWriter, r *http.Request) error {
+	id, err := apiPathId(r)
+	if err != nil {
+		return err
+	}
+	if err := reader.RevokeToken(id); err != nil {
+		return apiNotFound("%v", err)
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
@@ -2,7 +2,7 @@ package reader
 
 import (
 	"encoding/json"
-	"encoding/xml"
+	"errors"
 	"fmt"
 	"html/template"
 	"io"
@@ -11,34 +11,61 @@ import (
 	"net/url"
 	"os"
 	"path/filepath"
+	"sort"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/lsongdev/feedreader/feed"
-	"github.com/lsongdev/feedreader/templates"
 	"gopkg.in/yaml.v2"
 )
 
 type H map[string]interface{}
 
 type User struct {
+	Id       int    `json:"id" yaml:"-"`
 	Username string `json:"username"`
-	Password string `json:"password"`
+	// Password is the legacy plaintext password, prefer PasswordHash.
+	Password     string `json:"password" yaml:"password"`
+	PasswordHash string `json:"password_hash" yaml:"password_hash"`
+	// OIDCSubject links the user to the OpenID Connect account with this sub claim.
+	OIDCSubject string `json:"oidc_subject" yaml:"oidc_subject"`
 }
 
 type Config struct {
-	Dir        string `json:"-" yaml:"-"`
-	Title      string `json:"title" yaml:"title"`
-	Listen     string `json:"listen" yaml:"listen"`
-	Users      []User `json:"users" yaml:"users"`
-	Stylesheet string `json:"stylesheet" yaml:"stylesheet"`
+	Dir        string     `json:"-" yaml:"-"`
+	Title      string     `json:"title" yaml:"title"`
+	Listen     string     `json:"listen" yaml:"listen"`
+	Users      []User     `json:"users" yaml:"users"`
+	Stylesheet string     `json:"stylesheet" yaml:"stylesheet"`
+	SessionTTL string     `json:"session_ttl" yaml:"session_ttl"`
+	Auth       AuthConfig `json:"auth" yaml:"auth"`
+	// BaseURL is the public address of the server, such as
+	// https://reader.example.com. Output feeds, share links and the OpenID
+	// Connect callback use it instead of the request's host.
+	BaseURL string `json:"base_url" yaml:"base_url"`
+	// TrustedProxies lists the CIDRs, or single IPs, of the reverse proxies
+	// whose X-Forwarded-Proto header is believed.
+	TrustedProxies []string `json:"trusted_proxies" yaml:"trusted_proxies"`
+	// RefreshInterval is the default time between fetches of a feed.
+	RefreshInterval string `json:"refresh_interval" yaml:"refresh_interval"`
+	// AutoMarkRead marks a post read when it is opened, defaults to true.
+	AutoMarkRead *bool `json:"auto_mark_read" yaml:"auto_mark_read"`
+	// OfflinePosts is how many unread posts are kept for offline reading, defaults to 100.
+	OfflinePosts int `json:"offline_posts" yaml:"offline_posts"`
+	// Theme is light, dark or auto, the default. Users can pick their own in settings.
+	Theme string `json:"theme" yaml:"theme"`
+	// Dev reparses templates on every request, for editing them.
+	Dev bool `json:"dev" yaml:"dev"`
+	// Backup schedules database backups.
+	Backup BackupConfig `json:"backup" yaml:"backup"`
 }
 
 func NewConfig() *Config {
 	return &Config{
 		Title:  "Reader",
 		Listen: "0.0.0.0:8080",
-		Users:  []User{{"admin", "admin123"}},
+		Users:  []User{{Username: "admin", Password: "admin123"}},
 	}
 }
 
@@ -54,10 +81,30 @@ func (conf *Config) Load() error {
 	return yaml.Unmarshal(data, &conf)
 }
 
+// RefreshDuration returns how often feeds without their own refresh interval are fetched.
+func (conf *Config) RefreshDuration() time.Duration {
+	return parseDuration(conf.RefreshInterval, time.Minute)
+}
+
+// MarkReadOnOpen reports whether opening a post marks it read.
+func (conf *Config) MarkReadOnOpen() bool {
+	return conf.AutoMarkRead == nil || *conf.AutoMarkRead
+}
+
+// OfflineLimit returns how many unread posts browsers cache for offline reading.
+func (conf *Config) OfflineLimit() int {
+	if conf.OfflinePosts <= 0 {
+		return 100
+	}
+	return conf.OfflinePosts
+}
+
 type Pagination struct {
 	Page  int
 	Size  int
 	Total int64
+	// Order overrides the default newest-first ordering.
+	Order string
 }
 
 func (limit Pagination) Offset() int {
@@ -102,9 +149,12 @@ func (reader *Reader) Render(w http.ResponseWriter, templateName string, data H)
 	}
 	data["AppName"] = reader.config.Title
 	data["Stylesheet"] = template.CSS(reader.config.Stylesheet)
-	// tmpl, err := template.ParseFiles("templates/layout.html", "templates/"+templateName+".html")
-	// Parse templates from embedded file system
-	tmpl, err := template.New("").ParseFS(templates.Files, "layout.html", templateName+".html")
+	data["CSRFToken"] = reader.csrf
+	data["User"] = reader.user
+	data["OfflinePosts"] = reader.config.OfflineLimit()
+	data["Theme"] = reader.theme
+	data["Lang"] = reader.language()
+	tmpl, err := reader.views.page(data["Lang"].(string), templateName)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -124,19 +174,74 @@ func (reader *Reader) Error(w http.ResponseWriter, err error) {
 	})
 }
 
+// Handler routes the web UI and the APIs, behind login and CSRF protection.
+func (reader *Reader) Handler() http.Handler {
+	router := http.NewServeMux()
+	router.HandleFunc("/", reader.IndexView)
+	router.HandleFunc("/login", reader.LoginView)
+	router.HandleFunc("/logout", reader.LogoutView)
+	router.HandleFunc("/oidc/login", reader.OIDCLoginView)
+	router.HandleFunc("/oidc/callback", reader.OIDCCallbackView)
+	router.HandleFunc("/new", reader.NewView)
+	router.HandleFunc("/posts", reader.PostView)
+	router.HandleFunc("/posts/mark", reader.MarkView)
+	router.HandleFunc("/read", reader.ReadView)
+	router.HandleFunc("/read.json", reader.ReadJson)
+	router.HandleFunc("/events", reader.EventsView)
+	router.HandleFunc("/offline", reader.OfflineView)
+	router.HandleFunc("/proxy", reader.ImageProxyView)
+	router.HandleFunc("/manifest.webmanifest", reader.StaticView)
+	router.HandleFunc("/sw.js", reader.StaticView)
+	router.HandleFunc("/offline.js", reader.StaticView)
+	router.HandleFunc("/icon.svg", reader.StaticView)
+	router.HandleFunc("/static/", reader.AssetView)
+	router.HandleFunc("/feeds", reader.FeedView)
+	router.HandleFunc("/feeds/edit", reader.EditFeedView)
+	router.HandleFunc("/refresh", reader.RefreshView)
+	router.HandleFunc("/import", reader.ImportView)
+	router.HandleFunc("/lists", reader.ListsView)
+	router.HandleFunc("/categories", reader.CategoryView)
+	router.HandleFunc("/settings", reader.SettingsView)
+	router.HandleFunc("/rss.xml", reader.RssXml)
+	router.HandleFunc("/atom.xml", reader.AomXml)
+	router.HandleFunc("/feed.json", reader.FeedJson)
+	router.HandleFunc("/share/", reader.ShareView)
+	router.HandleFunc("/opml.xml", reader.OpmlXml)
+	router.HandleFunc("/archive.zip", reader.ArchiveView)
+	router.HandleFunc("/feeds.json", reader.FeedsJson)
+	router.HandleFunc("/posts.json", reader.PostsJson)
+	router.HandleFunc("/metrics.json", reader.MetricsJson)
+	router.Handle("/api/v1/", reader.APIHandler())
+	router.HandleFunc("/fever/", reader.FeverView)
+	router.HandleFunc("/accounts/ClientLogin", reader.GReaderLoginView)
+	router.HandleFunc("/reader/api/0/", reader.GReaderView)
+	router.HandleFunc("/index.php/apps/news/api/v1-3/", reader.NextcloudView)
+	return reader.CSRFProtect(reader.RequireLogin(router))
+}
+
 // IndexView handles requests to the home page.
 func (reader *Reader) IndexView(w http.ResponseWriter, r *http.Request) {
 	reader.PostView(w, r)
 }
 
-func (reader *Reader) CheckAuth(w http.ResponseWriter, r *http.Request) bool {
+// BasicAuthUser returns the configured user matching the request's Basic Auth credentials.
+func (reader *Reader) BasicAuthUser(r *http.Request) *User {
 	username, password, ok := r.BasicAuth()
-	if ok {
-		for _, user := range reader.config.Users {
-			if username == user.Username && password == user.Password {
-				return true
-			}
-		}
+	if !ok {
+		return nil
+	}
+	return reader.Login(username, password)
+}
+
+// CheckAuth reports whether the request is signed in, answering 401 when it
+// is not. Behind RequireLogin it trusts the user stored in the context, so
+// requests made with an API token pass as well.
+func (reader *Reader) CheckAuth(w http.ResponseWriter, r *http.Request) bool {
+	if user, ok := r.Context().Value(userKey{}).(*User); ok && user != nil {
+		return true
+	}
+	if reader.CurrentUser(r) != nil {
+		return true
 	}
 	w.Header().Set("WWW-Authenticate", "Basic realm=Restricted")
 	w.WriteHeader(http.StatusUnauthorized)
@@ -146,6 +251,7 @@ func (reader *Reader) CheckAuth(w http.ResponseWriter, r *http.Request) bool {
 
 // NewView handles requests to the new subscription page.
 func (reader *Reader) NewView(w http.ResponseWriter, r *http.Request) {
+	reader = reader.forRequest(r)
 	if r.Method == "POST" {
 		if !reader.CheckAuth(w, r) {
 			return
@@ -192,10 +298,15 @@ func (reader *Reader) NewView(w http.ResponseWriter, r *http.Request) {
 }
 
 func (reader *Reader) FeedsView(w http.ResponseWriter, r *http.Request) {
+	reader = reader.forRequest(r)
 	var conditions []string
 	if r.URL.Query().Has("category") {
-		categoryId := r.URL.Query().Get("category")
-		conditions = append(conditions, fmt.Sprintf("g.id = %s", categoryId))
+		categoryId, err := strconv.Atoi(r.URL.Query().Get("category"))
+		if err != nil {
+			reader.Error(w, fmt.Errorf("invalid category id"))
+			return
+		}
+		conditions = append(conditions, fmt.Sprintf("g.id = %d", categoryId))
 	}
 	feeds, err := reader.GetFeeds(conditions)
 	if err != nil {
@@ -214,6 +325,7 @@ func (reader *Reader) FeedsView(w http.ResponseWriter, r *http.Request) {
 }
 
 func (reader *Reader) PostsView(w http.ResponseWriter, r *http.Request) {
+	reader = reader.forRequest(r)
 	feedId := r.URL.Query().Get("id")
 	feed, err := reader.GetFeed(feedId)
 	if err != nil {
@@ -226,14 +338,17 @@ func (reader *Reader) PostsView(w http.ResponseWriter, r *http.Request) {
 		reader.Error(w, err)
 		return
 	}
-	reader.Render(w, "posts", H{
+	reader.Render(w, "posts", reader.undoData(r, H{
 		"feed":       feed,
 		"posts":      posts,
 		"pagination": limit,
-	})
+		"filter":     filterURL(fmt.Sprintf("feed=%d", feed.Id)),
+		"next":       pageURI(r),
+	}))
 }
 
 func (reader *Reader) DeleteFeedView(w http.ResponseWriter, r *http.Request) {
+	reader = reader.forRequest(r)
 	feedId := r.URL.Query().Get("id")
 	err := reader.DeleteFeed(feedId)
 	if err != nil {
@@ -243,8 +358,136 @@ func (reader *Reader) DeleteFeedView(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 }
 
+// formInt reads an optional integer form field, empty meaning 0.
+func formInt(r *http.Request, name string) (int, error) {
+	value := strings.TrimSpace(r.FormValue(name))
+	if value == "" {
+		return 0, nil
+	}
+	return strconv.Atoi(value)
+}
+
+// parseHeaders reads "Name: value" lines into a header map.
+func parseHeaders(text string) (map[string]string, error) {
+	headers := make(map[string]string)
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(line, ":")
+		if !ok || strings.TrimSpace(name) == "" {
+			return nil, fmt.Errorf("%w: invalid header line %q", ErrInvalidFeed, line)
+		}
+		headers[strings.TrimSpace(name)] = strings.TrimSpace(value)
+	}
+	if len(headers) == 0 {
+		return nil, nil
+	}
+	return headers, nil
+}
+
+// EditFeedView shows and saves the settings of a subscription.
+func (reader *Reader) EditFeedView(w http.ResponseWriter, r *http.Request) {
+	reader = reader.forRequest(r)
+	if !requireMethod(w, r, "GET", "POST") {
+		return
+	}
+	id := r.FormValue("id")
+	current, err := reader.GetFeed(id)
+	if err != nil {
+		reader.Error(w, err)
+		return
+	}
+	categories, err := reader.GetCategories()
+	if err != nil {
+		reader.Error(w, err)
+		return
+	}
+	data := H{"feed": current, "categories": categories}
+	if r.Method == "POST" {
+		newId, err := reader.saveFeedForm(id, r)
+		if err == nil {
+			http.Redirect(w, r, "/feeds?id="+newId, http.StatusFound)
+			return
+		}
+		if !errors.Is(err, ErrInvalidFeed) {
+			reader.Error(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		data["error"] = err.Error()
+	}
+	var headers []string
+	if current.FetchOptions != nil {
+		for name, value := range current.FetchOptions.Headers {
+			headers = append(headers, name+": "+value)
+		}
+		sort.Strings(headers)
+	}
+	data["headers"] = strings.Join(headers, "\n")
+	reader.Render(w, "edit", data)
+}
+
+// saveFeedForm applies the edit form to a subscription.
+func (reader *Reader) saveFeedForm(id string, r *http.Request) (string, error) {
+	name := strings.TrimSpace(r.FormValue("name"))
+	home := strings.TrimSpace(r.FormValue("home"))
+	link := strings.TrimSpace(r.FormValue("link"))
+	disabled := r.FormValue("enabled") == ""
+	categoryId, err := strconv.Atoi(r.FormValue("category"))
+	if err != nil {
+		return id, fmt.Errorf("%w: invalid category", ErrInvalidFeed)
+	}
+	interval, err := formInt(r, "refresh_interval")
+	if err != nil {
+		return id, fmt.Errorf("%w: refresh interval must be a number of minutes", ErrInvalidFeed)
+	}
+	timeout, err := formInt(r, "timeout")
+	if err != nil {
+		return id, fmt.Errorf("%w: timeout must be a number of seconds", ErrInvalidFeed)
+	}
+	headers, err := parseHeaders(r.FormValue("headers"))
+	if err != nil {
+		return id, err
+	}
+	options := &feed.FetchOptions{
+		UserAgent: strings.TrimSpace(r.FormValue("user_agent")),
+		Headers:   headers,
+		Username:  strings.TrimSpace(r.FormValue("username")),
+		Password:  r.FormValue("password"),
+		Timeout:   timeout,
+	}
+	update := FeedUpdate{
+		Name:            &name,
+		Home:            &home,
+		Link:            &link,
+		CategoryId:      &categoryId,
+		RefreshInterval: &interval,
+		Disabled:        &disabled,
+	}
+	// Only re-fetch with the options when they actually changed.
+	stored, err := reader.userFetchOptions(id)
+	if err != nil {
+		return id, err
+	}
+	if stored == nil {
+		stored = &feed.FetchOptions{}
+	}
+	if options.Password == "" && options.Username == stored.Username {
+		options.Password = stored.Password
+	}
+	before, _ := json.Marshal(stored)
+	after, _ := json.Marshal(options)
+	if string(before) != string(after) {
+		update.FetchOptions = options
+	}
+	return reader.UpdateFeed(id, update)
+}
+
 // FeedView handles requests to the feed page.
 func (reader *Reader) FeedView(w http.ResponseWriter, r *http.Request) {
+	reader = reader.forRequest(r)
 	switch r.Method {
 	case "GET":
 		if r.URL.Query().Has("id") {
@@ -254,15 +497,18 @@ func (reader *Reader) FeedView(w http.ResponseWriter, r *http.Request) {
 		}
 	case "DELETE":
 		reader.DeleteFeedView(w, r)
+	default:
+		requireMethod(w, r, "GET", "DELETE")
 	}
-
 }
 
-// PostView handles requests to view a specific post.
+// PostView handles requests to view a specific post, marking it read unless
+// auto_mark_read is off, and lists posts matching the query's filters.
 func (reader *Reader) PostView(w http.ResponseWriter, r *http.Request) {
+	reader = reader.forRequest(r)
 	if r.URL.Query().Has("id") {
 		id := r.URL.Query().Get("id")
-		post, err := reader.GetPost(id)
+		post, err := reader.openPost(id)
 		if err != nil {
 			reader.Error(w, err)
 			return
@@ -270,39 +516,43 @@ func (reader *Reader) PostView(w http.ResponseWriter, r *http.Request) {
 		reader.Render(w, "post", H{
 			"post": post,
 			"body": template.HTML(post.Content),
+			"next": pageURI(r),
 		})
 		return
 	}
-	var conditions []string
-	if r.URL.Query().Has("unread") {
-		conditions = append(conditions, "is_read = 0")
-	}
-	if r.URL.Query().Has("readed") {
-		conditions = append(conditions, "is_read = 1")
-	}
-	if r.URL.Query().Has("saved") {
-		conditions = append(conditions, "is_saved = 1")
-	}
-	if r.URL.Query().Has("category") {
-		categoryId := r.URL.Query().Get("category")
-		conditions = append(conditions, fmt.Sprintf("g.id = %s", categoryId))
+	conditions, args, err := postConditions(r.URL.Query())
+	if err != nil {
+		reader.Error(w, err)
+		return
 	}
 	limit := NewLimitFromQuery(r.URL.Query())
-	posts, err := reader.GetPosts(conditions, limit)
+	posts, err := reader.GetPosts(conditions, limit, args...)
 	if err != nil {
 		reader.Error(w, err)
 		return
 	}
 	// Render the template with the data
-	reader.Render(w, "posts", H{
+	reader.Render(w, "posts", reader.undoData(r, H{
 		"posts":      posts,
 		"pagination": limit,
-	})
+		"filter":     filterURL(postFilterQuery(r.URL.Query())),
+		"next":       pageURI(r),
+	}))
 }
 
+// ImportView starts an OPML import, and shows the progress of the import
+// given by ?id=.
 func (reader *Reader) ImportView(w http.ResponseWriter, r *http.Request) {
+	reader = reader.forRequest(r)
 	if r.Method != "POST" {
-		reader.Render(w, "import", nil)
+		var job *ImportJob
+		if id := r.URL.Query().Get("id"); id != "" {
+			if job = reader.ImportJob(id); job == nil {
+				reader.Error(w, fmt.Errorf("import %q not found", id))
+				return
+			}
+		}
+		reader.Render(w, "import", H{"job": job})
 		return
 	}
 	if !reader.CheckAuth(w, r) {
@@ -320,151 +570,316 @@ func (reader *Reader) ImportView(w http.ResponseWriter, r *http.Request) {
 		reader.Error(w, err)
 		return
 	}
-	err = reader.ImportOPML(data)
+	switch r.FormValue("kind") {
+	case "history":
+		report, err := reader.ImportHistory(data)
+		if err != nil {
+			reader.Error(w, err)
+			return
+		}
+		reader.Render(w, "import", H{"history": report})
+		return
+	case "archive":
+		report, err := reader.RestoreArchive(data)
+		if err != nil {
+			reader.Error(w, err)
+			return
+		}
+		var shareURLs []string
+		for _, share := range report.NewShares {
+			shareURLs = append(shareURLs, share.Name+": "+reader.ShareURL(r, share.Secret, "atom.xml"))
+		}
+		reader.Render(w, "import", H{"restore": report, "shareURLs": shareURLs})
+		return
+	}
+	job, err := reader.ImportOPML(data)
 	if err != nil {
 		reader.Error(w, err)
 		return
 	}
-	http.Redirect(w, r, "/feeds", http.StatusFound)
+	http.Redirect(w, r, "/import?id="+job.Id, http.StatusFound)
 }
 
-func (reader *Reader) RssXml(w http.ResponseWriter, r *http.Request) {
-	posts, err := reader.GetPosts(nil, nil)
+func (reader *Reader) OpmlXml(w http.ResponseWriter, r *http.Request) {
+	reader = reader.forRequest(r)
+	out, err := reader.ExportOPML()
 	if err != nil {
 		reader.Error(w, err)
 		return
 	}
 	w.Header().Set("Content-Type", "text/xml; charset=utf-8")
-	rss := feed.RssFeed{
-		Title: "Reader",
-	}
-	for _, post := range posts {
-		rss.Items = append(rss.Items, feed.RssItem{
-			Title:       post.Title,
-			Description: post.Content,
-			Link:        post.Link,
-			PubDate:     post.CreatedAt.Format(time.RFC1123Z),
-		})
-	}
-	err = xml.NewEncoder(w).Encode(rss)
+	err = feed.WriteOPML(w, out)
 	if err != nil {
 		reader.Error(w, err)
 		return
 	}
 }
 
-func (reader *Reader) AomXml(w http.ResponseWriter, r *http.Request) {
-	posts, err := reader.GetPosts(nil, nil)
+func (reader *Reader) FeedsJson(w http.ResponseWriter, r *http.Request) {
+	reader = reader.forRequest(r)
+	feeds, err := reader.GetFeeds(nil)
 	if err != nil {
 		reader.Error(w, err)
 		return
 	}
-	w.Header().Set("Content-Type", "text/xml; charset=utf-8")
-	atom := feed.AtomFeed{
-		Title:   feed.AtomText{Data: "Reader"},
-		Updated: time.Now().Format(time.RFC3339),
-		Generator: feed.AtomGenerator{
-			Name:    "Reader",
-			Version: "1.0.0",
-			URI:     "https://github.com/song940/feedreader",
-		},
-	}
-	for _, post := range posts {
-		atom.Entries = append(atom.Entries, feed.AtomEntry{
-			ID:      fmt.Sprintf("%d", post.Id),
-			Title:   feed.AtomText{Data: post.Title},
-			Content: feed.AtomText{Data: post.Content, Type: "html"},
-			Links:   []feed.AtomLink{{Href: post.Link}},
-			Updated: post.CreatedAt.Format(time.RFC3339),
-		})
-	}
-	w.Header().Set("Content-Type", "text/xml; charset=utf-8")
-	err = xml.NewEncoder(w).Encode(atom)
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	err = json.NewEncoder(w).Encode(feeds)
 	if err != nil {
 		reader.Error(w, err)
 		return
 	}
 }
 
-func (reader *Reader) OpmlXml(w http.ResponseWriter, r *http.Request) {
-	subscriptions, err := reader.GetFeeds(nil)
+func (reader *Reader) PostsJson(w http.ResponseWriter, r *http.Request) {
+	reader = reader.forRequest(r)
+	posts, err := reader.GetPosts(nil, nil)
 	if err != nil {
 		reader.Error(w, err)
 		return
 	}
-	out := &feed.OPML{
-		Title:     reader.config.Title,
-		CreatedAt: time.Now(),
-	}
-	for _, subscription := range subscriptions {
-		out.Outlines = append(out.Outlines, feed.Outline{
-			Type:    subscription.Type,
-			Title:   subscription.Name,
-			Text:    subscription.Name,
-			HTMLURL: subscription.Home,
-			XMLURL:  subscription.Link,
-		})
-	}
-	w.Header().Set("Content-Type", "text/xml; charset=utf-8")
-	err = xml.NewEncoder(w).Encode(out)
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	err = json.NewEncoder(w).Encode(posts)
 	if err != nil {
 		reader.Error(w, err)
 		return
 	}
 }
 
-func (reader *Reader) FeedsJson(w http.ResponseWriter, r *http.Request) {
-	feeds, err := reader.GetFeeds(nil)
-	if err != nil {
-		reader.Error(w, err)
-		return
-	}
+func (reader *Reader) MetricsJson(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json; charset=utf-8")
-	err = json.NewEncoder(w).Encode(feeds)
+	err := json.NewEncoder(w).Encode(reader.metrics.Snapshot())
 	if err != nil {
 		reader.Error(w, err)
 		return
 	}
 }
 
-func (reader *Reader) PostsJson(w http.ResponseWriter, r *http.Request) {
-	posts, err := reader.GetPosts(nil, nil)
+// CategoryView lists categories for management and handles creating,
+// renaming, deleting and reordering them, and moving feeds between them.
+func (reader *Reader) CategoryView(w http.ResponseWriter, r *http.Request) {
+	reader = reader.forRequest(r)
+	if !requireMethod(w, r, "GET", "POST") {
+		return
+	}
+	if !reader.CheckAuth(w, r) {
+		return
+	}
+	if r.Method == "POST" {
+		next, err := reader.saveCategoryForm(r)
+		if err != nil {
+			reader.Error(w, err)
+			return
+		}
+		http.Redirect(w, r, next, http.StatusFound)
+		return
+	}
+	categories, err := reader.GetCategories()
 	if err != nil {
 		reader.Error(w, err)
 		return
 	}
-
-	w.Header().Set("Content-Type", "application/json; charset=utf-8")
-	err = json.NewEncoder(w).Encode(posts)
+	defaultId, err := reader.DefaultCategoryId()
 	if err != nil {
 		reader.Error(w, err)
 		return
 	}
+	reader.Render(w, "categories", H{
+		"categories": categories,
+		"defaultId":  defaultId,
+	})
 }
 
-func (reader *Reader) CategoryView(w http.ResponseWriter, r *http.Request) {
-	if r.Method != "POST" {
+// saveCategoryForm applies a category form action and returns where to go next.
+func (reader *Reader) saveCategoryForm(r *http.Request) (next string, err error) {
+	action := r.FormValue("action")
+	if action == "" || action == "create" {
+		name := strings.TrimSpace(r.FormValue("name"))
+		if name == "" {
+			return "", fmt.Errorf("category name is required")
+		}
+		id, err := reader.CreateCategory(name)
+		return fmt.Sprintf("/posts?category=%d", id), err
+	}
+	if action == "move" {
+		categoryId, err := formInt(r, "category")
+		if err != nil {
+			return "", fmt.Errorf("invalid category id")
+		}
+		var feedIds []int
+		for _, value := range r.Form["feed_ids"] {
+			id, err := strconv.Atoi(value)
+			if err != nil {
+				return "", fmt.Errorf("invalid feed id: %q", value)
+			}
+			feedIds = append(feedIds, id)
+		}
+		_, err = reader.MoveFeeds(feedIds, categoryId)
+		return fmt.Sprintf("/feeds?category=%d", categoryId), err
+	}
+	id, err := formInt(r, "id")
+	if err != nil || id == 0 {
+		return "", fmt.Errorf("invalid category id")
+	}
+	switch action {
+	case "rename":
+		name := strings.TrimSpace(r.FormValue("name"))
+		if name == "" {
+			return "", fmt.Errorf("category name is required")
+		}
+		err = reader.UpdateCategory(id, name)
+	case "delete":
+		defaultId, err := reader.DefaultCategoryId()
+		if err != nil {
+			return "", err
+		}
+		if id == defaultId {
+			return "", fmt.Errorf("the default category cannot be deleted")
+		}
+		moveTo, err := formInt(r, "move_to")
+		if err != nil {
+			return "", fmt.Errorf("invalid category id")
+		}
+		if moveTo == 0 {
+			moveTo = defaultId
+		}
+		err = reader.DeleteCategory(id, moveTo)
+		if err != nil {
+			return "", err
+		}
+	case "up", "down":
+		// index is the category's 0-based place in the list, positions are 1-based.
+		index, err := formInt(r, "index")
+		if err != nil {
+			return "", fmt.Errorf("invalid index")
+		}
+		position := index + 2
+		if action == "up" {
+			position = index
+		}
+		err = reader.PositionCategory(id, position)
+		if err != nil {
+			return "", err
+		}
+	default:
+		return "", fmt.Errorf("unknown action: %q", action)
+	}
+	return "/categories", err
+}
+
+// SettingsView lists API tokens and shared feeds and handles creating and
+// revoking them. A filter in the query prefills the new share form.
+func (reader *Reader) SettingsView(w http.ResponseWriter, r *http.Request) {
+	reader = reader.forRequest(r)
+	user := reader.user
+	if user == nil {
+		reader.CheckAuth(w, r)
 		return
 	}
-	r.ParseForm()
-	if !reader.CheckAuth(w, r) {
+	var secret string
+	var shareURLs []string
+	if r.Method == "POST" {
+		switch r.FormValue("action") {
+		case "create":
+			scopes, err := ParseScopes(strings.Join(r.Form["scopes"], ","))
+			if err != nil {
+				reader.Error(w, err)
+				return
+			}
+			secret, _, err = reader.CreateToken(user.Username, r.FormValue("name"), scopes)
+			if err != nil {
+				reader.Error(w, err)
+				return
+			}
+		case "language":
+			if err := reader.SetUserLanguage(r.FormValue("language")); err != nil {
+				reader.Error(w, err)
+				return
+			}
+			http.Redirect(w, r, "/settings", http.StatusFound)
+			return
+		case "theme":
+			if err := reader.setTheme(w, r, r.FormValue("theme")); err != nil {
+				reader.Error(w, err)
+				return
+			}
+			http.Redirect(w, r, "/settings", http.StatusFound)
+			return
+		case "revoke":
+			id, _ := strconv.Atoi(r.FormValue("id"))
+			if err := reader.RevokeToken(id); err != nil {
+				reader.Error(w, err)
+				return
+			}
+			http.Redirect(w, r, "/settings", http.StatusFound)
+			return
+		case "share":
+			query := url.Values{}
+			for name, values := range r.PostForm {
+				if len(values) > 0 && values[0] != "" {
+					query.Set(name, values[0])
+				}
+			}
+			shareSecret, _, err := reader.CreateShare(r.FormValue("name"), query)
+			if err != nil {
+				reader.Error(w, err)
+				return
+			}
+			for _, format := range []string{"atom.xml", "rss.xml", "feed.json"} {
+				shareURLs = append(shareURLs, reader.ShareURL(r, shareSecret, format))
+			}
+		case "unshare":
+			id, _ := strconv.Atoi(r.FormValue("id"))
+			if err := reader.RevokeShare(id); err != nil {
+				reader.Error(w, err)
+				return
+			}
+			http.Redirect(w, r, "/settings#shares", http.StatusFound)
+			return
+		}
+	}
+	tokens, err := reader.GetTokens()
+	if err != nil {
+		reader.Error(w, err)
 		return
 	}
-	categoryName := r.FormValue("name")
-	categoryId, err := reader.CreateCategory(categoryName)
+	shares, err := reader.GetShares()
 	if err != nil {
 		reader.Error(w, err)
 		return
 	}
-	http.Redirect(w, r, fmt.Sprintf("/posts?category=%d", categoryId), http.StatusFound)
+	categories, err := reader.GetCategories()
+	if err != nil {
+		reader.Error(w, err)
+		return
+	}
+	reader.Render(w, "settings", H{
+		"tokens":     tokens,
+		"secret":     secret,
+		"shares":     shares,
+		"shareURLs":  shareURLs,
+		"shareQuery": r.URL.Query(),
+		"categories": categories,
+		"themes":     themes,
+		"languages":  reader.Languages(),
+		"language":   reader.UserLanguage(),
+	})
 }
 
 func (reader *Reader) RefreshView(w http.ResponseWriter, r *http.Request) {
+	reader = reader.forRequest(r)
+	if !requireMethod(w, r, "POST") {
+		return
+	}
 	id := r.URL.Query().Get("id")
 	if id == "" {
-		go reader.updatePostsPeriodically()
+		go reader.RefreshFeeds()
 		http.Redirect(w, r, "/posts", http.StatusFound)
 	} else {
+		if _, err := reader.GetFeed(id); err != nil {
+			reader.Error(w, err)
+			return
+		}
 		reader.updateFeedPosts(id)
 		http.Redirect(w, r, fmt.Sprintf("/feeds?id=%s", id), http.StatusFound)
 	}
@@ -0,0 +1,151 @@
+package reader
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Share is a secret URL publishing one of a user's output feeds without a
+// login, for integrations that cannot authenticate. Only a hash of the
+// secret is stored.
+type Share struct {
+	Id         int
+	Name       string
+	Username   string
+	Filter     string
+	CreatedAt  time.Time
+	LastUsedAt *time.Time
+	// Scope names the filter, empty for all posts.
+	Scope []string
+}
+
+// ShareURL is the address of a share's feed in the given format, one of
+// the outputFormats, under the configured base URL.
+func (reader *Reader) ShareURL(r *http.Request, secret, format string) string {
+	return fmt.Sprintf("%s/share/%s/%s", reader.baseURL(r), secret, format)
+}
+
+// CreateShare binds a new secret to the filters in query and returns the
+// secret, which is not stored.
+func (reader *Reader) CreateShare(name string, query url.Values) (secret string, share *Share, err error) {
+	if strings.TrimSpace(name) == "" {
+		return "", nil, fmt.Errorf("share name is required")
+	}
+	if _, _, err = postConditions(query); err != nil {
+		return
+	}
+	// Reject feeds and categories of other users.
+	if _, err = reader.outputScope(query); err != nil {
+		return
+	}
+	if secret, err = newShareSecret(); err != nil {
+		return
+	}
+	share = &Share{Name: name, Username: reader.user.Username, Filter: postFilterQuery(query), CreatedAt: time.Now()}
+	err = reader.db.QueryRow(`
+		INSERT INTO shares (name, username, token_hash, filter) VALUES (?, ?, ?, ?) RETURNING id
+	`, share.Name, share.Username, hashToken(secret), share.Filter).Scan(&share.Id)
+	return
+}
+
+// newShareSecret returns a random secret for a share.
+func newShareSecret() (string, error) {
+	buf := make([]byte, 24)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+func (reader *Reader) queryShares(condition string, args ...any) (shares []*Share, err error) {
+	rows, err := reader.db.Query(fmt.Sprintf(`
+		SELECT id, name, username, filter, created_at, last_used_at FROM shares %s ORDER BY id
+	`, condition), args...)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var share Share
+		var lastUsed sql.NullTime
+		if err = rows.Scan(&share.Id, &share.Name, &share.Username, &share.Filter, &share.CreatedAt, &lastUsed); err != nil {
+			return nil, err
+		}
+		if lastUsed.Valid {
+			share.LastUsedAt = &lastUsed.Time
+		}
+		shares = append(shares, &share)
+	}
+	return shares, rows.Err()
+}
+
+// GetShares lists the user's shares with the names of their filters.
+func (reader *Reader) GetShares() ([]*Share, error) {
+	shares, err := reader.queryShares("WHERE username = ?", reader.user.Username)
+	if err != nil {
+		return nil, err
+	}
+	for _, share := range shares {
+		query, _ := url.ParseQuery(share.Filter)
+		// The share's category may have been deleted since.
+		if share.Scope, err = reader.outputScope(query); err != nil {
+			share.Scope = []string{share.Filter}
+		}
+	}
+	return shares, nil
+}
+
+// RevokeShare deletes one of the user's shares, its URLs stop working.
+func (reader *Reader) RevokeShare(id int) error {
+	res, err := reader.db.Exec("DELETE FROM shares WHERE id = ? AND username = ?", id, reader.user.Username)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("share %d not found", id)
+	}
+	return nil
+}
+
+// AuthenticateShare returns the share matching a secret, or nil.
+func (reader *Reader) AuthenticateShare(secret string) *Share {
+	shares, err := reader.queryShares("WHERE token_hash = ?", hashToken(secret))
+	if err != nil || len(shares) == 0 {
+		return nil
+	}
+	share := shares[0]
+	reader.db.Exec("UPDATE shares SET last_used_at = ? WHERE id = ?", time.Now(), share.Id)
+	return share
+}
+
+// ShareView serves /share/{secret}/{format}, the output feed of a share as
+// its owner would see it. Only ?limit= can be added to the share's filter.
+func (reader *Reader) ShareView(w http.ResponseWriter, r *http.Request) {
+	secret, name, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/share/"), "/")
+	format, ok := outputFormats[name]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	share := reader.AuthenticateShare(secret)
+	if share == nil {
+		http.NotFound(w, r)
+		return
+	}
+	user := reader.FindUser(share.Username)
+	if user == nil {
+		http.NotFound(w, r)
+		return
+	}
+	query, _ := url.ParseQuery(share.Filter)
+	if limit := r.URL.Query().Get("limit"); limit != "" {
+		query.Set("limit", limit)
+	}
+	reader.As(user).serveOutput(w, r, query, format)
+}
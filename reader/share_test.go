@@ -0,0 +1,46 @@
+package reader
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestShareURLsUseBaseURL(t *testing.T) {
+	reader := newTestReader(t)
+	reader.config.BaseURL = "https://reader.example.com"
+	addTestPost(t, reader.As(&reader.config.Users[0]))
+
+	form := url.Values{"action": {"share"}, "name": {"chat"}}
+	r := httptest.NewRequest("POST", "/settings", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.Host = "evil.example"
+	w := httptest.NewRecorder()
+	reader.SettingsView(w, withUser(r, &reader.config.Users[0]))
+	body := w.Body.String()
+	if strings.Contains(body, "evil.example") {
+		t.Fatalf("share URLs use the request's host:\n%s", body)
+	}
+	link := regexp.MustCompile(`https://reader\.example\.com(/share/[0-9a-f]+/feed\.json)`).FindStringSubmatch(body)
+	if link == nil {
+		t.Fatalf("no share URL under the base URL:\n%s", body)
+	}
+
+	r = httptest.NewRequest("GET", link[1], nil)
+	r.Host = "evil.example"
+	w = httptest.NewRecorder()
+	reader.ShareView(w, r)
+	var doc struct {
+		FeedURL string `json:"feed_url"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&doc); err != nil || w.Code != http.StatusOK {
+		t.Fatalf("share feed: %d %v", w.Code, err)
+	}
+	if doc.FeedURL != link[0] {
+		t.Errorf("shared feed_url = %s, want %s", doc.FeedURL, link[0])
+	}
+}
@@ -0,0 +1,170 @@
+package reader
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Scopes granted to API tokens, each one implying the scopes before it.
+const (
+	ScopeRead  = "read"
+	ScopeWrite = "write"
+	ScopeAdmin = "admin"
+)
+
+var scopeLevels = map[string]int{
+	ScopeRead:  1,
+	ScopeWrite: 2,
+	ScopeAdmin: 3,
+}
+
+// tokenPrefix marks API tokens so they are easy to recognise in configs and logs.
+const tokenPrefix = "fr_"
+
+// Token is a named, revocable API credential. Only a hash of the secret is stored.
+type Token struct {
+	Id         int        `json:"id"`
+	Name       string     `json:"name"`
+	Username   string     `json:"username"`
+	Scopes     []string   `json:"scopes"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+}
+
+// tokenKey stores the token that authenticated a request in its context.
+type tokenKey struct{}
+
+// Allows reports whether the token grants the given scope.
+func (token *Token) Allows(scope string) bool {
+	for _, granted := range token.Scopes {
+		if scopeLevels[granted] >= scopeLevels[scope] {
+			return true
+		}
+	}
+	return false
+}
+
+func hashToken(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// ParseScopes validates a comma separated scope list.
+func ParseScopes(str string) (scopes []string, err error) {
+	for _, scope := range strings.Split(str, ",") {
+		scope = strings.TrimSpace(scope)
+		if scope == "" {
+			continue
+		}
+		if _, ok := scopeLevels[scope]; !ok {
+			return nil, fmt.Errorf("unknown scope: %q", scope)
+		}
+		scopes = append(scopes, scope)
+	}
+	if len(scopes) == 0 {
+		return nil, fmt.Errorf("at least one scope is required")
+	}
+	return
+}
+
+// CreateToken generates a new token and returns its secret, which is not stored.
+func (reader *Reader) CreateToken(username, name string, scopes []string) (secret string, token *Token, err error) {
+	if strings.TrimSpace(name) == "" {
+		return "", nil, fmt.Errorf("token name is required")
+	}
+	buf := make([]byte, 24)
+	if _, err = rand.Read(buf); err != nil {
+		return
+	}
+	secret = tokenPrefix + hex.EncodeToString(buf)
+	// Fever clients send md5(username:password), so also keep a hash of
+	// the key they derive when the token is used as the password.
+	feverKey := (&User{Username: username, Password: secret}).FeverAuthKey()
+	token = &Token{Name: name, Username: username, Scopes: scopes, CreatedAt: time.Now()}
+	err = reader.db.QueryRow(`
+		INSERT INTO api_tokens (name, username, token_hash, fever_hash, scopes) VALUES (?, ?, ?, ?, ?) RETURNING id
+	`, name, username, hashToken(secret), hashToken(feverKey), strings.Join(scopes, ",")).Scan(&token.Id)
+	return
+}
+
+func (reader *Reader) queryTokens(condition string, args ...any) (tokens []*Token, err error) {
+	rows, err := reader.db.Query(fmt.Sprintf(`
+		SELECT id, name, username, scopes, created_at, last_used_at FROM api_tokens %s ORDER BY id
+	`, condition), args...)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var token Token
+		var scopes string
+		var lastUsed sql.NullTime
+		if err = rows.Scan(&token.Id, &token.Name, &token.Username, &scopes, &token.CreatedAt, &lastUsed); err != nil {
+			return nil, err
+		}
+		token.Scopes = strings.Split(scopes, ",")
+		if lastUsed.Valid {
+			token.LastUsedAt = &lastUsed.Time
+		}
+		tokens = append(tokens, &token)
+	}
+	return tokens, rows.Err()
+}
+
+// GetTokens lists API tokens without their secret. A reader scoped to a
+// user only sees that user's tokens.
+func (reader *Reader) GetTokens() ([]*Token, error) {
+	if reader.user != nil {
+		return reader.queryTokens("WHERE username = ?", reader.user.Username)
+	}
+	return reader.queryTokens("")
+}
+
+// RevokeToken deletes a token so it can no longer be used.
+func (reader *Reader) RevokeToken(id int) error {
+	query, args := "DELETE FROM api_tokens WHERE id = ?", []any{id}
+	if reader.user != nil {
+		query, args = query+" AND username = ?", append(args, reader.user.Username)
+	}
+	res, err := reader.db.Exec(query, args...)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("token %d not found", id)
+	}
+	return nil
+}
+
+// authenticateToken looks up a token by the hash of the given column.
+func (reader *Reader) authenticateToken(column, secret string) *Token {
+	tokens, err := reader.queryTokens(fmt.Sprintf("WHERE %s = ?", column), hashToken(secret))
+	if err != nil || len(tokens) == 0 {
+		return nil
+	}
+	token := tokens[0]
+	reader.db.Exec("UPDATE api_tokens SET last_used_at = ? WHERE id = ?", time.Now(), token.Id)
+	return token
+}
+
+// AuthenticateToken returns the token matching a secret, or nil.
+func (reader *Reader) AuthenticateToken(secret string) *Token {
+	if !strings.HasPrefix(secret, tokenPrefix) {
+		return nil
+	}
+	return reader.authenticateToken("token_hash", secret)
+}
+
+// AuthenticateFeverToken accepts either the token itself or the Fever key
+// derived from it as api_key.
+func (reader *Reader) AuthenticateFeverToken(apiKey string) *Token {
+	if token := reader.AuthenticateToken(apiKey); token != nil {
+		return token
+	}
+	return reader.authenticateToken("fever_hash", strings.ToUpper(apiKey))
+}
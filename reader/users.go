@@ -0,0 +1,291 @@
+package reader
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+)
+
+// defaultCategory names the first category created for every user. The
+// user's oldest category receives feeds without a category.
+const defaultCategory = "Default"
+
+type userKey struct{}
+
+// As returns a copy of the reader whose queries are scoped to the given user.
+// A nil user sees no data.
+func (reader *Reader) As(user *User) *Reader {
+	scoped := *reader
+	scoped.user = user
+	return &scoped
+}
+
+// forRequest scopes the reader to the user authenticated for the request.
+func (reader *Reader) forRequest(r *http.Request) *Reader {
+	user, ok := r.Context().Value(userKey{}).(*User)
+	if !ok {
+		user = reader.CurrentUser(r)
+	}
+	scoped := reader.As(user)
+	scoped.csrf, _ = r.Context().Value(csrfKey{}).(string)
+	if token, ok := r.Context().Value(tokenKey{}).(*Token); ok {
+		scoped.readOnly = !token.Allows(ScopeWrite)
+	}
+	scoped.theme = reader.requestTheme(r)
+	scoped.acceptLanguage = r.Header.Get("Accept-Language")
+	return scoped
+}
+
+// withUser stores the authenticated user in the request context.
+func withUser(r *http.Request, user *User) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), userKey{}, user))
+}
+
+func (reader *Reader) userId() int {
+	if reader.user == nil {
+		return 0
+	}
+	return reader.user.Id
+}
+
+// feedsTable selects the user's subscriptions with the columns of the feeds
+// table. Fetch options are only visible on the user's own copy of a feed.
+func (reader *Reader) feedsTable() string {
+	return fmt.Sprintf(`(
+		SELECT f.id, f.type, COALESCE(NULLIF(sub.name, ''), f.name) AS name, f.home, f.link,
+			sub.category_id, sub.created_at, sub.disabled, sub.list_id, f.refresh_interval,
+			CASE WHEN f.owner_id = %[1]d THEN f.fetch_options END AS fetch_options, f.fetched_at, f.fetch_error
+		FROM subscriptions sub JOIN feeds f ON f.id = sub.feed_id
+		WHERE sub.user_id = %[1]d
+	)`, reader.userId())
+}
+
+// categoriesTable selects the user's categories.
+func (reader *Reader) categoriesTable() string {
+	return fmt.Sprintf("(SELECT id, name FROM categories WHERE user_id = %d)", reader.userId())
+}
+
+// postsTable selects posts together with the user's read and saved state,
+// and when that state last changed.
+func (reader *Reader) postsTable() string {
+	return fmt.Sprintf(`(
+		SELECT p.id, p.entry_id, p.title, p.content, p.link, p.author, p.pub_date, p.created_at, p.feed_id,
+			COALESCE(st.is_read, 0) AS is_read, COALESCE(st.is_saved, 0) AS is_saved, st.updated_at
+		FROM posts p LEFT JOIN post_states st ON st.post_id = p.id AND st.user_id = %d
+	)`, reader.userId())
+}
+
+// postsFrom is the FROM clause used with GetPosts style conditions.
+func (reader *Reader) postsFrom() string {
+	return fmt.Sprintf("%s p, %s s, %s g", reader.postsTable(), reader.feedsTable(), reader.categoriesTable())
+}
+
+// createUserTables creates the per-user tables and migrates a single-user
+// database, whose categories, feeds and post flags were global, to the first
+// configured user.
+func createUserTables(db *sql.DB, config *Config) (err error) {
+	if _, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS users (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			username TEXT NOT NULL,
+			provider TEXT,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			UNIQUE (username)
+		)
+	`); err != nil {
+		return
+	}
+	// provider names the auth backend that created the user, if any.
+	if err = addColumn(db, "users", "provider", "TEXT"); err != nil {
+		return
+	}
+	// language is the user's UI language, NULL to follow the browser.
+	if err = addColumn(db, "users", "language", "TEXT"); err != nil {
+		return
+	}
+	// oidc_identities link OpenID Connect accounts, by issuer and subject, to users.
+	if _, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS oidc_identities (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			issuer TEXT NOT NULL,
+			subject TEXT NOT NULL,
+			user_id INTEGER NOT NULL,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			UNIQUE (issuer, subject),
+			FOREIGN KEY (user_id) REFERENCES users (id)
+		)
+	`); err != nil {
+		return
+	}
+	for i := range config.Users {
+		user := &config.Users[i]
+		if _, err = db.Exec("INSERT OR IGNORE INTO users (username) VALUES (?)", user.Username); err != nil {
+			return
+		}
+		if err = db.QueryRow("SELECT id FROM users WHERE username = ?", user.Username).Scan(&user.Id); err != nil {
+			return
+		}
+	}
+	// Legacy databases have a categories table without user_id.
+	legacy := false
+	if _, err := db.Exec("SELECT 1 FROM categories LIMIT 0"); err == nil {
+		_, err := db.Exec("SELECT user_id FROM categories LIMIT 0")
+		legacy = err != nil
+	}
+	if legacy {
+		if len(config.Users) == 0 {
+			return fmt.Errorf("a user is required to migrate the database")
+		}
+		owner := config.Users[0]
+		log.Printf("Migrating feeds, categories and read state to user %q", owner.Username)
+		return migrateLegacyData(db, owner.Id)
+	}
+	if err = createScopedTables(db); err != nil {
+		return
+	}
+	if err = addColumn(db, "categories", "position", "INTEGER DEFAULT 0"); err != nil {
+		return
+	}
+	if err = addColumn(db, "subscriptions", "disabled", "BOOLEAN DEFAULT 0"); err != nil {
+		return
+	}
+	// updated_at is when the state last changed, for clients syncing changes.
+	if err = addColumn(db, "post_states", "updated_at", "DATETIME"); err != nil {
+		return
+	}
+	for column, definition := range map[string]string{
+		"attributes": "TEXT",
+		"list_id":    "INTEGER",
+		"list_link":  "TEXT",
+		// disabled_by_list marks feeds a list disabled, the ones it resumes.
+		"disabled_by_list": "BOOLEAN DEFAULT 0",
+	} {
+		if err = addColumn(db, "subscriptions", column, definition); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// execer is satisfied by both *sql.DB and *sql.Tx.
+type execer interface {
+	Exec(query string, args ...any) (sql.Result, error)
+}
+
+func createScopedTables(db execer) (err error) {
+	if _, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS categories (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id INTEGER NOT NULL,
+			name TEXT NOT NULL,
+			position INTEGER DEFAULT 0,
+			UNIQUE (user_id, name),
+			FOREIGN KEY (user_id) REFERENCES users (id)
+		)
+	`); err != nil {
+		return
+	}
+	if _, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS subscriptions (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id INTEGER NOT NULL,
+			feed_id INTEGER NOT NULL,
+			category_id INTEGER NOT NULL,
+			name TEXT,
+			disabled BOOLEAN DEFAULT 0,
+			attributes TEXT,
+			list_id INTEGER,
+			list_link TEXT,
+			disabled_by_list BOOLEAN DEFAULT 0,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			UNIQUE (user_id, feed_id),
+			FOREIGN KEY (user_id) REFERENCES users (id),
+			FOREIGN KEY (feed_id) REFERENCES feeds (id),
+			FOREIGN KEY (category_id) REFERENCES categories (id)
+		)
+	`); err != nil {
+		return
+	}
+	// feed_lists are remote OPML files whose feeds are kept subscribed.
+	if _, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS feed_lists (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id INTEGER NOT NULL,
+			name TEXT NOT NULL,
+			url TEXT NOT NULL,
+			category_id INTEGER NOT NULL,
+			dropped TEXT NOT NULL DEFAULT 'keep',
+			synced_at DATETIME,
+			sync_error TEXT,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			UNIQUE (user_id, url),
+			FOREIGN KEY (user_id) REFERENCES users (id),
+			FOREIGN KEY (category_id) REFERENCES categories (id)
+		)
+	`); err != nil {
+		return
+	}
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS post_states (
+			user_id INTEGER NOT NULL,
+			post_id INTEGER NOT NULL,
+			is_read BOOLEAN DEFAULT 0,
+			is_saved BOOLEAN DEFAULT 0,
+			updated_at DATETIME,
+			PRIMARY KEY (user_id, post_id),
+			FOREIGN KEY (user_id) REFERENCES users (id),
+			FOREIGN KEY (post_id) REFERENCES posts (id)
+		)
+	`)
+	return
+}
+
+func migrateLegacyData(db *sql.DB, owner int) (err error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return
+	}
+	defer tx.Rollback()
+	if _, err = tx.Exec("ALTER TABLE categories RENAME TO categories_legacy"); err != nil {
+		return
+	}
+	if err = createScopedTables(tx); err != nil {
+		return
+	}
+	statements := []string{
+		"INSERT INTO categories (id, user_id, name) SELECT id, ?, name FROM categories_legacy",
+		"INSERT INTO subscriptions (user_id, feed_id, category_id, name, created_at) SELECT ?, id, category_id, name, created_at FROM feeds",
+		"INSERT INTO post_states (user_id, post_id, is_read, is_saved) SELECT ?, id, is_read, is_saved FROM posts WHERE is_read = 1 OR is_saved = 1",
+	}
+	for _, statement := range statements {
+		if _, err = tx.Exec(statement, owner); err != nil {
+			return
+		}
+	}
+	if _, err = tx.Exec("DROP TABLE categories_legacy"); err != nil {
+		return
+	}
+	return tx.Commit()
+}
+
+// ensureDefaultCategories gives every user a default category.
+func (reader *Reader) ensureDefaultCategories() error {
+	for i := range reader.config.Users {
+		if _, err := reader.As(&reader.config.Users[i]).GetOrCreateCategory(defaultCategory); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DefaultCategoryId returns the user's oldest category, creating it if needed.
+// It cannot be deleted, but can be renamed.
+func (reader *Reader) DefaultCategoryId() (id int, err error) {
+	err = reader.db.QueryRow("SELECT id FROM categories WHERE user_id = ? ORDER BY id LIMIT 1", reader.userId()).Scan(&id)
+	if err == sql.ErrNoRows {
+		return reader.CreateCategory(defaultCategory)
+	}
+	return
+}
@@ -0,0 +1,187 @@
+package reader
+
+import (
+	"fmt"
+	"html/template"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lsongdev/feedreader/templates"
+)
+
+// themes are the values accepted for config.Theme and the theme cookie.
+var themes = []string{"auto", "light", "dark"}
+
+const themeCookie = "theme"
+
+// overlayFS serves files from upper, falling back to lower.
+type overlayFS struct {
+	upper, lower fs.FS
+}
+
+func (o overlayFS) Open(name string) (fs.File, error) {
+	if f, err := o.upper.Open(name); err == nil {
+		return f, nil
+	}
+	return o.lower.Open(name)
+}
+
+// ReadDir merges both directories so that fs.Glob sees every file.
+func (o overlayFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	lower, lowerErr := fs.ReadDir(o.lower, name)
+	upper, upperErr := fs.ReadDir(o.upper, name)
+	if lowerErr != nil && upperErr != nil {
+		return nil, lowerErr
+	}
+	entries := make(map[string]fs.DirEntry)
+	for _, entry := range lower {
+		entries[entry.Name()] = entry
+	}
+	for _, entry := range upper {
+		entries[entry.Name()] = entry
+	}
+	merged := make([]fs.DirEntry, 0, len(entries))
+	for _, entry := range entries {
+		merged = append(merged, entry)
+	}
+	sort.Slice(merged, func(i, j int) bool { return merged[i].Name() < merged[j].Name() })
+	return merged, nil
+}
+
+// views holds every page template parsed together with layout.html, once
+// for each locale. Files in config.Dir/templates override the embedded files
+// of the same name.
+type views struct {
+	files  fs.FS
+	reload bool
+
+	mu      sync.RWMutex
+	locales map[string]*locale
+	pages   map[string]map[string]*template.Template
+}
+
+func newViews(dir string, reload bool) (*views, error) {
+	v := &views{files: templates.Files, reload: reload}
+	if dir != "" {
+		override := filepath.Join(dir, "templates")
+		if info, err := os.Stat(override); err == nil && info.IsDir() {
+			v.files = overlayFS{upper: os.DirFS(override), lower: templates.Files}
+		}
+	}
+	return v, v.parse()
+}
+
+func (v *views) parse() error {
+	locales, err := loadLocales(v.files)
+	if err != nil {
+		return err
+	}
+	names, err := fs.Glob(v.files, "*.html")
+	if err != nil {
+		return err
+	}
+	pages := make(map[string]map[string]*template.Template)
+	for lang, l := range locales {
+		pages[lang] = make(map[string]*template.Template)
+		for _, file := range names {
+			if file == "layout.html" {
+				continue
+			}
+			tmpl, err := template.New("").Funcs(l.funcs()).ParseFS(v.files, "layout.html", file)
+			if err != nil {
+				return fmt.Errorf("parse template %s: %w", file, err)
+			}
+			pages[lang][strings.TrimSuffix(file, ".html")] = tmpl
+		}
+	}
+	v.mu.Lock()
+	v.locales = locales
+	v.pages = pages
+	v.mu.Unlock()
+	return nil
+}
+
+// page returns a page parsed for lang, reparsing every template first in
+// dev mode.
+func (v *views) page(lang, name string) (*template.Template, error) {
+	if v.reload {
+		if err := v.parse(); err != nil {
+			return nil, err
+		}
+	}
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	tmpl, ok := v.pages[lang][name]
+	if !ok {
+		return nil, fmt.Errorf("template %q not found", name)
+	}
+	return tmpl, nil
+}
+
+func (v *views) locale(lang string) (*locale, bool) {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	l, ok := v.locales[lang]
+	return l, ok
+}
+
+// requestTheme returns the request's theme cookie, or the configured default.
+func (reader *Reader) requestTheme(r *http.Request) string {
+	if cookie, err := r.Cookie(themeCookie); err == nil && validTheme(cookie.Value) {
+		return cookie.Value
+	}
+	if validTheme(reader.config.Theme) {
+		return reader.config.Theme
+	}
+	return "auto"
+}
+
+func validTheme(theme string) bool {
+	for _, name := range themes {
+		if name == theme {
+			return true
+		}
+	}
+	return false
+}
+
+// setTheme remembers the browser's theme for a year.
+func (reader *Reader) setTheme(w http.ResponseWriter, r *http.Request, theme string) error {
+	if !validTheme(theme) {
+		return fmt.Errorf("unknown theme: %q", theme)
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     themeCookie,
+		Value:    theme,
+		Path:     "/",
+		Expires:  time.Now().AddDate(1, 0, 0),
+		HttpOnly: true,
+		Secure:   reader.isSecureRequest(r),
+		SameSite: http.SameSiteLaxMode,
+	})
+	return nil
+}
+
+// AssetView serves files under /static/ from config.Dir/templates or the
+// embedded templates, except the templates themselves.
+func (reader *Reader) AssetView(w http.ResponseWriter, r *http.Request) {
+	if !requireMethod(w, r, "GET", "HEAD") {
+		return
+	}
+	name := strings.TrimPrefix(r.URL.Path, "/static/")
+	if !fs.ValidPath(name) || strings.HasSuffix(name, ".html") {
+		http.NotFound(w, r)
+		return
+	}
+	if info, err := fs.Stat(reader.views.files, name); err != nil || info.IsDir() {
+		http.NotFound(w, r)
+		return
+	}
+	http.ServeFileFS(w, r, reader.views.files, name)
+}
@@ -0,0 +1,179 @@
+// offline.js keeps the latest unread posts, their images and read/save
+// changes made offline in IndexedDB. Pages and the service worker share it.
+(function (global) {
+  const DB_NAME = 'feedreader';
+  // Version 2 drops posts cached before the server sanitized their content.
+  const DB_VERSION = 2;
+  const SYNC_INTERVAL = 10 * 60 * 1000;
+  let opening;
+
+  function open() {
+    opening = opening || new Promise((resolve, reject) => {
+      const req = indexedDB.open(DB_NAME, DB_VERSION);
+      req.onupgradeneeded = e => {
+        const db = req.result;
+        if (e.oldVersion >= 1) {
+          req.transaction.objectStore('posts').clear();
+          req.transaction.objectStore('meta').delete('synced_at');
+          return;
+        }
+        db.createObjectStore('posts', { keyPath: 'id' });
+        db.createObjectStore('images');
+        db.createObjectStore('queue', { autoIncrement: true });
+        db.createObjectStore('meta');
+      };
+      req.onsuccess = () => resolve(req.result);
+      req.onerror = () => reject(req.error);
+    });
+    return opening;
+  }
+
+  const done = req => new Promise((resolve, reject) => {
+    req.onsuccess = () => resolve(req.result);
+    req.onerror = () => reject(req.error);
+  });
+
+  // run calls fn with the object store and resolves when the transaction commits.
+  async function run(name, mode, fn) {
+    const db = await open();
+    return new Promise((resolve, reject) => {
+      const tx = db.transaction(name, mode);
+      let result;
+      Promise.resolve(fn(tx.objectStore(name))).then(value => { result = value; });
+      tx.oncomplete = () => resolve(result);
+      tx.onerror = () => reject(tx.error);
+      tx.onabort = () => reject(tx.error);
+    });
+  }
+
+  const get = (name, key) => run(name, 'readonly', store => done(store.get(key)));
+  const put = (name, value, key) => run(name, 'readwrite', store => done(store.put(value, key)));
+
+  async function posts() {
+    const all = await run('posts', 'readonly', store => done(store.getAll()));
+    return all.sort((a, b) => new Date(b.pub_date) - new Date(a.pub_date));
+  }
+
+  const image = url => get('images', url);
+
+  // localize parses a post's content, which the server has sanitized, with
+  // its images pointed at the proxy so they can be cached. It calls found
+  // with each proxied url.
+  function localize(post, found) {
+    const doc = new DOMParser().parseFromString(post.content || '', 'text/html');
+    doc.querySelectorAll('img[src]').forEach(img => {
+      let src;
+      try {
+        src = new URL(img.getAttribute('src'), post.link);
+      } catch (err) {
+        return;
+      }
+      if (src.protocol !== 'http:' && src.protocol !== 'https:') return;
+      const local = '/proxy?url=' + encodeURIComponent(src.href);
+      img.setAttribute('src', local);
+      found(local);
+    });
+    return doc.body;
+  }
+
+  // render shows a cached post's content in container, as it came from the
+  // server apart from the image urls.
+  function render(post, container) {
+    container.replaceChildren(...localize(post, () => {}).childNodes);
+  }
+
+  async function fetchPosts(limit) {
+    const all = [];
+    let cursor = '';
+    while (all.length < limit) {
+      let url = '/api/v1/posts?read=false&limit=' + Math.min(500, limit - all.length);
+      if (cursor) url += '&cursor=' + encodeURIComponent(cursor);
+      const res = await fetch(url, { credentials: 'same-origin' });
+      if (!res.ok) throw new Error('sync failed: ' + res.status);
+      const page = await res.json();
+      all.push(...page.data);
+      if (!page.next_cursor) break;
+      cursor = page.next_cursor;
+    }
+    return all;
+  }
+
+  // sync replays pending changes, then replaces the cache with the latest
+  // unread posts and downloads their images.
+  async function sync(limit) {
+    await replay();
+    const fresh = await fetchPosts(limit);
+    const images = new Set();
+    fresh.forEach(post => localize(post, url => images.add(url)));
+    await run('posts', 'readwrite', store => {
+      store.clear();
+      fresh.forEach(post => store.put(post));
+    });
+    const cached = new Set(await run('images', 'readonly', store => done(store.getAllKeys())));
+    await run('images', 'readwrite', store => {
+      cached.forEach(url => { if (!images.has(url)) store.delete(url); });
+    });
+    for (const url of images) {
+      if (cached.has(url)) continue;
+      try {
+        const res = await fetch(url, { credentials: 'same-origin' });
+        if (res.ok) await put('images', await res.blob(), url);
+      } catch (err) {
+        // Images are best effort, the post is still readable without them.
+      }
+    }
+    await put('meta', Date.now(), 'synced_at');
+    return fresh.length;
+  }
+
+  // syncIfStale syncs when the last sync is older than SYNC_INTERVAL. The
+  // cache starts over when another user signs in on the same browser.
+  async function syncIfStale(limit, user) {
+    if (await get('meta', 'user') !== user) {
+      await clear();
+      await put('meta', user, 'user');
+    }
+    const syncedAt = await get('meta', 'synced_at');
+    if (syncedAt && Date.now() - syncedAt < SYNC_INTERVAL) return replay();
+    return sync(limit);
+  }
+
+  async function clear() {
+    for (const name of ['posts', 'images', 'queue', 'meta']) {
+      await run(name, 'readwrite', store => done(store.clear()));
+    }
+  }
+
+  // change records a read or save change locally and queues it for the API.
+  async function change(id, state) {
+    const post = await get('posts', id);
+    if (post) await put('posts', Object.assign(post, state));
+    await put('queue', { id, state });
+    if (global.navigator.onLine) await replay().catch(() => {});
+  }
+
+  async function pending() {
+    return run('queue', 'readonly', store => done(store.count()));
+  }
+
+  // replay sends queued changes in order and stops at the first network error.
+  async function replay() {
+    const entries = await run('queue', 'readonly', store => Promise.all([
+      done(store.getAllKeys()), done(store.getAll()),
+    ]));
+    const [keys, changes] = entries;
+    for (let i = 0; i < keys.length; i++) {
+      const res = await fetch('/api/v1/posts/' + changes[i].id, {
+        method: 'PATCH',
+        credentials: 'same-origin',
+        headers: { 'Content-Type': 'application/json' },
+        body: JSON.stringify(changes[i].state),
+      });
+      // A post removed on the server cannot be updated, drop the change.
+      if (!res.ok && res.status !== 404) throw new Error('replay failed: ' + res.status);
+      await run('queue', 'readwrite', store => done(store.delete(keys[i])));
+    }
+  }
+
+  global.offline = { posts, image, render, sync, syncIfStale, change, pending, replay, clear };
+})(self);
@@ -0,0 +1,54 @@
+// The service worker keeps the app shell for offline use, falls back to the
+// offline page when a page cannot be loaded, and serves proxied images from
+// the IndexedDB cache filled by offline.js.
+importScripts('/offline.js');
+
+const SHELL = 'shell-v2';
+const SHELL_FILES = ['/offline', '/offline.js', '/static/style.css', '/icon.svg', '/manifest.webmanifest'];
+
+self.addEventListener('install', e => {
+  e.waitUntil(caches.open(SHELL)
+    .then(cache => cache.addAll(SHELL_FILES))
+    .then(() => self.skipWaiting()));
+});
+
+self.addEventListener('activate', e => {
+  e.waitUntil(caches.keys()
+    .then(keys => Promise.all(keys.filter(key => key !== SHELL).map(key => caches.delete(key))))
+    .then(() => self.clients.claim()));
+});
+
+// fromNetwork updates the shell cache, falling back to it when offline.
+function fromNetwork(req) {
+  return fetch(req).then(res => {
+    if (res.ok) {
+      const copy = res.clone();
+      caches.open(SHELL).then(cache => cache.put(req, copy));
+    }
+    return res;
+  }).catch(() => caches.match(req));
+}
+
+self.addEventListener('fetch', e => {
+  const req = e.request;
+  const url = new URL(req.url);
+  if (req.method !== 'GET' || url.origin !== location.origin) return;
+  if (url.pathname === '/proxy') {
+    e.respondWith(offline.image(url.pathname + url.search)
+      .catch(() => null)
+      .then(blob => blob ? new Response(blob) : fetch(req)));
+    return;
+  }
+  if (req.mode === 'navigate') {
+    e.respondWith(fetch(req).catch(() => caches.match('/offline')));
+    return;
+  }
+  if (SHELL_FILES.includes(url.pathname)) {
+    e.respondWith(fromNetwork(req));
+  }
+});
+
+// Browsers with Background Sync replay offline changes once they reconnect.
+self.addEventListener('sync', e => {
+  if (e.tag === 'replay') e.waitUntil(offline.replay());
+});
@@ -2,5 +2,5 @@ package templates
 
 import "embed"
 
-//go:embed *.html
+//go:embed *.html *.js *.css *.svg *.webmanifest locales/*.json
 var Files embed.FS